@@ -3,71 +3,360 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"syscall"
 	"time"
 
+	"github.com/Dor1ma/log-finder/internal/alerting"
+	"github.com/Dor1ma/log-finder/internal/audit"
+	"github.com/Dor1ma/log-finder/internal/auth"
 	"github.com/Dor1ma/log-finder/internal/config"
+	"github.com/Dor1ma/log-finder/internal/logging"
+	"github.com/Dor1ma/log-finder/internal/metrics"
+	"github.com/Dor1ma/log-finder/internal/patterns"
+	"github.com/Dor1ma/log-finder/internal/redact"
 	"github.com/Dor1ma/log-finder/internal/server/handlers"
+	"github.com/Dor1ma/log-finder/internal/server/middleware"
 	"github.com/Dor1ma/log-finder/internal/server/routers"
 	"github.com/Dor1ma/log-finder/internal/service"
 	"github.com/Dor1ma/log-finder/internal/storage/repository"
+	"github.com/Dor1ma/log-finder/internal/tlsconfig"
+	"github.com/Dor1ma/log-finder/internal/tracing"
+	"github.com/Dor1ma/log-finder/pkg/reader"
 )
 
 func main() {
-	cfg := config.Load()
-
-	log.Println("Current config parameters")
-	log.Println("Logs directory: ", cfg.LogDir)
-	log.Println("Server port: ", cfg.ServerPort)
-	log.Println("Cache TTL: ", cfg.CacheTTL)
-	log.Println("Max open files: ", cfg.MaxOpenFiles)
-	log.Println("File cache TTL: ", cfg.FileCacheTTL)
-	log.Println("Rate limit: ", cfg.RateLimit)
-	log.Println("Refresh interval: ", cfg.RefreshInterval)
-
-	repo, err := repository.NewLogRepository(
-		cfg.LogDir,
-		cfg.MaxOpenFiles,
-		cfg.FileCacheTTL,
-		cfg.RefreshInterval,
-	)
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := logging.Setup(os.Stdout, cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Invalid logging config: %v", err)
+	}
+
+	slog.Info("Current config parameters",
+		"log_dir", cfg.LogDir,
+		"server_port", cfg.ServerPort,
+		"cache_ttl", cfg.CacheTTL,
+		"negative_cache_ttl", cfg.NegativeTTL,
+		"cache_max_entries", cfg.CacheMaxEntries,
+		"cache_max_bytes", cfg.CacheMaxBytes,
+		"max_open_files", cfg.MaxOpenFiles,
+		"max_mapped_bytes", cfg.MaxMappedBytes,
+		"max_resident_bytes", cfg.MaxResidentBytes,
+		"file_cache_ttl", cfg.FileCacheTTL,
+		"rate_limit", cfg.RateLimit,
+		"rate_limit_routes", cfg.RateLimitRoutes,
+		"rate_limit_costs", cfg.RateLimitCosts,
+		"rate_limit_max_clients", cfg.RateLimitClients,
+		"rate_limit_auth_failures", cfg.AuthFailureLimit,
+		"refresh_interval", cfg.RefreshInterval,
+		"watch_debounce", cfg.WatchDebounce,
+		"reader_mode", cfg.ReaderMode,
+		"query_timeout", cfg.QueryTimeout,
+		"range_max_results", cfg.MaxRangeResults,
+		"search_workers", cfg.SearchWorkers,
+		"query_workers", cfg.QueryWorkers,
+		"log_level", cfg.LogLevel,
+		"log_format", cfg.LogFormat,
+		"trace_export", cfg.TraceExport,
+		"admin_api", cfg.AdminToken != "",
+		"auth_api_keys_file", cfg.AuthAPIKeysFile,
+		"auth_jwt_secret_file", cfg.AuthJWTSecretFile,
+		"auth_jwt_issuer", cfg.AuthJWTIssuer,
+		"auth_jwt_audience", cfg.AuthJWTAudience,
+		"auth_htpasswd_file", cfg.AuthHtpasswdFile,
+		"auth_policy_file", cfg.AuthPolicyFile,
+		"auth_reload_interval", cfg.AuthReloadInterval,
+		"tls_cert_file", cfg.TLSCertFile,
+		"tls_client_ca_file", cfg.TLSClientCAFile,
+		"tls_client_auth", cfg.TLSClientAuth,
+		"tls_reload_interval", cfg.TLSReloadInterval,
+		"redact_rules_file", cfg.RedactRulesFile,
+		"saved_searches_file", cfg.SavedSearchesFile,
+		"alert_interval", cfg.AlertInterval,
+		"alert_webhook", cfg.AlertWebhookURL != "",
+		"alert_repeat_interval", cfg.AlertRepeatInterval,
+		"alert_anomalies", cfg.AlertAnomalies,
+		"anomaly_baseline", cfg.AnomalyBaseline,
+		"anomaly_threshold", cfg.AnomalyThreshold,
+		"anomaly_min_error_ratio", cfg.AnomalyMinErrorRatio,
+		"pattern_max", cfg.PatternMax,
+		"pattern_similarity", cfg.PatternSimilarity,
+		"audit_log_file", cfg.AuditLogFile,
+		"audit_log_max_size", cfg.AuditLogMaxSize,
+		"audit_log_max_files", cfg.AuditLogMaxFiles)
+
+	if cfg.TraceExport != "" {
+		exporter, err := tracing.Open(cfg.TraceExport)
+		if err != nil {
+			fatal("Failed to open TRACE_EXPORT", err)
+		}
+		tracing.SetExporter(exporter)
+		defer exporter.Close()
+	}
+
+	readerMode, err := reader.ParseMode(cfg.ReaderMode)
+	if err != nil {
+		fatal("Invalid READER_MODE", err)
+	}
+	readerModes := make(map[string]reader.Mode, len(cfg.ReaderModes))
+	for source, mode := range cfg.ReaderModes {
+		if readerModes[source], err = reader.ParseMode(mode); err != nil {
+			fatal("Invalid READER_MODE_SOURCES", err)
+		}
+	}
+
+	var miner *patterns.Miner
+	if cfg.PatternMax > 0 {
+		miner = patterns.NewMiner(patterns.Options{MaxPatterns: cfg.PatternMax, Similarity: cfg.PatternSimilarity})
+	}
+
+	repo, err := repository.NewLogRepository(cfg.LogDir, repository.Options{
+		MaxOpenFiles:     cfg.MaxOpenFiles,
+		MaxMappedBytes:   cfg.MaxMappedBytes,
+		MaxResidentBytes: cfg.MaxResidentBytes,
+		FileCacheTTL:     cfg.FileCacheTTL,
+		RefreshInterval:  cfg.RefreshInterval,
+		WatchDebounce:    cfg.WatchDebounce,
+		ReaderMode:       readerMode,
+		ReaderModes:      readerModes,
+		SearchWorkers:    cfg.SearchWorkers,
+		QueryParallelism: cfg.QueryWorkers,
+		ErrorPattern:     regexp.MustCompile(cfg.AnomalyErrorPattern),
+		Patterns:         miner,
+	})
 
 	if err != nil {
-		log.Fatalf("Error occured during repo creating: %v", err)
+		fatal("Error occured during repo creating", err)
 	}
 
-	service := service.NewLogService(repo, cfg.CacheTTL)
+	service := service.NewLogService(repo, serviceOptions(cfg))
+	defer service.Close()
 	handler := handlers.NewLogHandler(service)
+	registerMetrics(service, repo, miner)
 
+	authn, requireAuth, err := newAuthenticator(cfg)
+	if err != nil {
+		fatal("Failed to load credentials", err)
+	}
+
+	var policy *auth.Policy
+	if cfg.AuthPolicyFile != "" {
+		if policy, err = auth.NewPolicy(cfg.AuthPolicyFile, cfg.AuthReloadInterval); err != nil {
+			fatal("Failed to load AUTH_POLICY_FILE", err)
+		}
+	}
+
+	var redactor *redact.Redactor
+	if cfg.RedactRulesFile != "" {
+		if redactor, err = redact.Load(cfg.RedactRulesFile); err != nil {
+			fatal("Failed to load REDACT_RULES_FILE", err)
+		}
+	}
+
+	var auditLog *audit.Logger
+	if cfg.AuditLogFile != "" {
+		if auditLog, err = audit.Open(cfg.AuditLogFile, cfg.AuditLogMaxSize, cfg.AuditLogMaxFiles); err != nil {
+			fatal("Failed to open AUDIT_LOG_FILE", err)
+		}
+		defer auditLog.Close()
+	}
+
+	var searches *handlers.SearchHandler
+	if cfg.SavedSearchesFile != "" {
+		opts := alerting.Options{
+			Interval:       cfg.AlertInterval,
+			Webhook:        cfg.AlertWebhookURL,
+			RepeatInterval: cfg.AlertRepeatInterval,
+			Timeout:        cfg.AlertTimeout,
+		}
+		if cfg.AlertAnomalies {
+			opts.Anomalies = service
+		}
+		scheduler, err := alerting.NewScheduler(cfg.SavedSearchesFile, service, opts)
+		if err != nil {
+			fatal("Failed to load SAVED_SEARCHES_FILE", err)
+		}
+		scheduler.Start()
+		defer scheduler.Close()
+		searches = handlers.NewSearchHandler(scheduler)
+	}
+
+	// Every request context derives from baseCtx, so cancelling it on
+	// shutdown aborts searches that are still running.
+	baseCtx, cancelSearches := context.WithCancel(context.Background())
+	defer cancelSearches()
+
+	limiter := middleware.NewRateLimiter(rateLimitOptions(cfg))
 	server := &http.Server{
-		Addr:    ":" + cfg.ServerPort,
-		Handler: routers.NewRouter(handler, cfg.RateLimit),
+		Addr: ":" + cfg.ServerPort,
+		Handler: routers.NewRouter(handler, routers.Options{
+			RateLimiter:   limiter,
+			Authenticator: authn,
+			RequireAuth:   requireAuth,
+			Policy:        policy,
+			AuditLog:      auditLog,
+			Redactor:      redactor,
+			Searches:      searches,
+		}),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
+	server.RegisterOnShutdown(cancelSearches)
+
+	certs, err := newTLSReloader(cfg)
+	if err != nil {
+		fatal("Invalid TLS config", err)
+	}
+	if certs != nil {
+		defer certs.Close()
+		server.TLSConfig = certs.Config()
+	}
+
+	reloadOnHangup(&hotReload{
+		cfg:     cfg,
+		repo:    repo,
+		service: service,
+		limiter: limiter,
+		files:   reloadableFiles(authn, policy, certs, redactor),
+	})
 
 	// Graceful shutdown
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+		var err error
+		if certs != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Server error", err)
 		}
 	}()
 
-	log.Printf("Server started on port %s", cfg.ServerPort)
+	slog.Info("Server started", "port", cfg.ServerPort, "tls", certs != nil)
 
 	<-done
-	log.Println("Server is shutting down...")
+	slog.Info("Server is shutting down...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server shutdown error: %v", err)
+		fatal("Server shutdown error", err)
+	}
+
+	slog.Info("Server stopped")
+}
+
+// newAuthenticator chains every configured credential source. Once any
+// source is configured, anonymous requests are no longer served.
+func newAuthenticator(cfg *config.Config) (auth.Authenticator, bool, error) {
+	var chain auth.Chain
+	if cfg.AdminToken != "" {
+		chain = append(chain, auth.NewStaticToken(cfg.AdminToken,
+			&auth.Principal{Name: "admin", Method: "admin_token", Roles: []string{auth.RoleAdmin}}))
+	}
+
+	if cfg.AuthAPIKeysFile != "" {
+		keys, err := auth.NewAPIKeys(cfg.AuthAPIKeysFile, cfg.AuthReloadInterval)
+		if err != nil {
+			return nil, false, err
+		}
+		chain = append(chain, keys)
+	}
+
+	if cfg.AuthJWTSecretFile != "" {
+		jwt, err := auth.NewJWT(cfg.AuthJWTSecretFile, cfg.AuthJWTIssuer, cfg.AuthJWTAudience, cfg.AuthReloadInterval)
+		if err != nil {
+			return nil, false, err
+		}
+		chain = append(chain, jwt)
+	}
+
+	if cfg.AuthHtpasswdFile != "" {
+		htpasswd, err := auth.NewHtpasswd(cfg.AuthHtpasswdFile, cfg.AuthReloadInterval)
+		if err != nil {
+			return nil, false, err
+		}
+		chain = append(chain, htpasswd)
+	}
+
+	// Client certificates verified by the TLS handshake; header credentials
+	// take precedence when a request carries both.
+	if cfg.TLSClientCAFile != "" {
+		chain = append(chain, auth.ClientCert{})
 	}
 
-	log.Println("Server stopped")
+	requireAuth := len(chain) > 0
+	if !requireAuth {
+		slog.Warn("No credentials configured, logs are served to anonymous clients; " +
+			"set ADMIN_TOKEN, AUTH_API_KEYS_FILE, AUTH_JWT_SECRET_FILE, AUTH_HTPASSWD_FILE or TLS_CLIENT_CA_FILE")
+	}
+	return chain, requireAuth, nil
+}
+
+// newTLSReloader returns nil when TLS is not configured.
+func newTLSReloader(cfg *config.Config) (*tlsconfig.Reloader, error) {
+	if cfg.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	return tlsconfig.New(tlsconfig.Options{
+		CertFile:          cfg.TLSCertFile,
+		KeyFile:           cfg.TLSKeyFile,
+		ClientCAFile:      cfg.TLSClientCAFile,
+		RequireClientCert: cfg.TLSClientAuth == "require",
+		ReloadInterval:    cfg.TLSReloadInterval,
+	})
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func registerMetrics(logService *service.LogService, repo *repository.LogRepository, miner *patterns.Miner) {
+	metrics.Default.NewGaugeFunc("logfinder_index_files", "Files in the index.", func() float64 {
+		return float64(repo.FileCount())
+	})
+	if miner != nil {
+		metrics.Default.NewGaugeFunc("logfinder_patterns", "Message patterns mined from indexed lines.", func() float64 {
+			return float64(miner.Len())
+		})
+	}
+
+	metrics.Default.NewCollector(func() []metrics.Sample {
+		stats := logService.CacheStats()
+		return []metrics.Sample{
+			{Name: "logfinder_result_cache_hits_total", Help: "Result cache hits for found entries.", Kind: "counter", Value: float64(stats.Hits)},
+			{Name: "logfinder_result_cache_negative_hits_total", Help: "Result cache hits for cached misses.", Kind: "counter", Value: float64(stats.NegativeHits)},
+			{Name: "logfinder_result_cache_misses_total", Help: "Result cache misses.", Kind: "counter", Value: float64(stats.Misses)},
+			{Name: "logfinder_result_cache_coalesced_total", Help: "Lookups that joined an identical in-flight search.", Kind: "counter", Value: float64(stats.Coalesced)},
+			{Name: "logfinder_result_cache_evictions_total", Help: "Entries evicted to stay within the cache bounds.", Kind: "counter", Value: float64(stats.Evictions)},
+			{Name: "logfinder_result_cache_invalidations_total", Help: "Entries dropped because the index changed.", Kind: "counter", Value: float64(stats.Invalidations)},
+			{Name: "logfinder_result_cache_entries", Help: "Entries in the result cache.", Kind: "gauge", Value: float64(stats.Entries)},
+			{Name: "logfinder_result_cache_bytes", Help: "Approximate size of the result cache.", Kind: "gauge", Value: float64(stats.Bytes)},
+		}
+	})
+
+	metrics.Default.NewCollector(func() []metrics.Sample {
+		stats := repo.FileCacheStats()
+		return []metrics.Sample{
+			{Name: "logfinder_file_cache_hits_total", Help: "File cache lookups served by an open reader.", Kind: "counter", Value: float64(stats.Hits)},
+			{Name: "logfinder_file_cache_misses_total", Help: "File cache lookups that had to open the file.", Kind: "counter", Value: float64(stats.Misses)},
+			{Name: "logfinder_file_cache_entries", Help: "Open files in the file cache.", Kind: "gauge", Value: float64(stats.Entries)},
+			{Name: "logfinder_file_cache_mapped_bytes", Help: "Address space mapped by the file cache, including evicted mappings still in use.", Kind: "gauge", Value: float64(stats.MappedBytes)},
+			{Name: "logfinder_file_cache_resident_bytes", Help: "Resident memory of cached mappings.", Kind: "gauge", Value: float64(stats.ResidentBytes)},
+		}
+	})
 }
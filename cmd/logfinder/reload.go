@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/Dor1ma/log-finder/internal/anomaly"
+	"github.com/Dor1ma/log-finder/internal/auth"
+	"github.com/Dor1ma/log-finder/internal/config"
+	"github.com/Dor1ma/log-finder/internal/logging"
+	"github.com/Dor1ma/log-finder/internal/redact"
+	"github.com/Dor1ma/log-finder/internal/server/middleware"
+	"github.com/Dor1ma/log-finder/internal/service"
+	"github.com/Dor1ma/log-finder/internal/storage/repository"
+	"github.com/Dor1ma/log-finder/internal/tlsconfig"
+)
+
+type reloadable interface {
+	Reload() error
+}
+
+// hotReload applies a re-read configuration to the running server. Only
+// settings that are safe to change under load are applied; the others are
+// reported and wait for a restart.
+type hotReload struct {
+	cfg     *config.Config
+	repo    *repository.LogRepository
+	service *service.LogService
+	limiter *middleware.RateLimiter
+	files   []reloadable
+}
+
+func reloadOnHangup(h *hotReload) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			h.reload()
+		}
+	}()
+}
+
+func (h *hotReload) reload() {
+	slog.Info("Reloading configuration")
+
+	next, err := config.Load()
+	if err != nil {
+		slog.Error("Config reload failed, keeping current settings", "error", err)
+		return
+	}
+	if keys := h.cfg.RestartRequired(next); len(keys) > 0 {
+		slog.Warn("Some changed settings only apply after a restart", "settings", keys)
+	}
+
+	if err := logging.SetLevel(next.LogLevel); err != nil {
+		slog.Error("Failed to change log level", "error", err)
+	}
+	// New limits reset every client's bucket, so leave them alone unless
+	// they changed.
+	if !reflect.DeepEqual(rateLimitOptions(h.cfg), rateLimitOptions(next)) {
+		h.limiter.SetOptions(rateLimitOptions(next))
+	}
+	h.service.Reconfigure(serviceOptions(next))
+	h.repo.SetFileCacheTTL(next.FileCacheTTL)
+	if next.RefreshInterval != h.cfg.RefreshInterval {
+		if err := h.repo.SetRefreshInterval(context.Background(), next.RefreshInterval); err != nil {
+			slog.Error("Failed to change refresh interval", "error", err)
+		}
+	}
+
+	// Credentials, the policy and certificates are re-read as well, and the
+	// log directory is rescanned so new sources show up right away.
+	for _, file := range h.files {
+		if err := file.Reload(); err != nil {
+			slog.Error("Failed to reload file, keeping previous version", "error", err)
+		}
+	}
+	if err := h.repo.RefreshMetadata(context.Background()); err != nil {
+		slog.Error("Metadata refresh failed", "error", err)
+	}
+
+	h.cfg = next
+	slog.Info("Configuration reloaded")
+}
+
+func serviceOptions(cfg *config.Config) service.Options {
+	return service.Options{
+		Cache: service.CacheOptions{
+			TTL:         cfg.CacheTTL,
+			NegativeTTL: cfg.NegativeTTL,
+			MaxEntries:  cfg.CacheMaxEntries,
+			MaxBytes:    cfg.CacheMaxBytes,
+		},
+		QueryTimeout:    cfg.QueryTimeout,
+		MaxRangeResults: cfg.MaxRangeResults,
+		Anomaly: anomaly.Options{
+			Baseline:      cfg.AnomalyBaseline,
+			Threshold:     cfg.AnomalyThreshold,
+			MinErrorRatio: cfg.AnomalyMinErrorRatio,
+		},
+	}
+}
+
+func rateLimitOptions(cfg *config.Config) middleware.RateLimitOptions {
+	return middleware.RateLimitOptions{
+		Rate:         cfg.RateLimit,
+		Routes:       cfg.RateLimitRoutes,
+		Costs:        cfg.RateLimitCosts,
+		MaxClients:   cfg.RateLimitClients,
+		AuthFailures: cfg.AuthFailureLimit,
+	}
+}
+
+// reloadableFiles collects everything backed by files that SIGHUP should
+// re-read.
+func reloadableFiles(authn auth.Authenticator, policy *auth.Policy, certs *tlsconfig.Reloader, redactor *redact.Redactor) []reloadable {
+	var files []reloadable
+	if chain, ok := authn.(auth.Chain); ok {
+		for _, a := range chain {
+			if file, ok := a.(reloadable); ok {
+				files = append(files, file)
+			}
+		}
+	}
+	if policy != nil {
+		files = append(files, policy)
+	}
+	if certs != nil {
+		files = append(files, certs)
+	}
+	if redactor != nil {
+		files = append(files, redactor)
+	}
+	return files
+}
@@ -0,0 +1,379 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/anomaly"
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCounter struct {
+	mutex   sync.Mutex
+	result  models.CountResult
+	err     error
+	queries []models.RangeQuery
+	access  []models.SourceAccess
+}
+
+func (c *fakeCounter) CountRange(ctx context.Context, query models.RangeQuery) (models.CountResult, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.queries = append(c.queries, query)
+	c.access = append(c.access, models.SourceAccessFrom(ctx))
+	return c.result, c.err
+}
+
+func (c *fakeCounter) set(total int, truncated bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.result = models.CountResult{Total: total, Truncated: truncated}
+}
+
+type receiver struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	alerts []Alert
+	status int
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rec := &receiver{status: http.StatusOK}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var alert Alert
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+
+		rec.mutex.Lock()
+		defer rec.mutex.Unlock()
+		if rec.status == http.StatusOK {
+			rec.alerts = append(rec.alerts, alert)
+		}
+		w.WriteHeader(rec.status)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (r *receiver) received() []Alert {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Alert(nil), r.alerts...)
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status = status
+}
+
+func newTestScheduler(t *testing.T, counter Counter, opts Options) (*Scheduler, *time.Time) {
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	scheduler, err := NewScheduler(filepath.Join(t.TempDir(), "searches.json"), counter, opts)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 10, 13, 0, 0, 0, time.UTC)
+	scheduler.now = func() time.Time { return now }
+	return scheduler, &now
+}
+
+func TestSchedulerFiresDedupsAndResolves(t *testing.T) {
+	rec := newReceiver(t)
+	counter := &fakeCounter{}
+	scheduler, now := newTestScheduler(t, counter, Options{Webhook: rec.URL})
+
+	require.NoError(t, scheduler.Put(&SavedSearch{
+		Name: "errors", Pattern: "ERROR", Sources: []string{"nginx"}, Window: "5m", Threshold: 10,
+	}))
+
+	counter.set(3, false)
+	scheduler.Evaluate(context.Background())
+	assert.Empty(t, rec.received())
+
+	require.Len(t, counter.queries, 1)
+	assert.Equal(t, now.Add(-5*time.Minute), counter.queries[0].From)
+	assert.Equal(t, *now, counter.queries[0].To)
+	assert.True(t, counter.queries[0].Matches("an ERROR line"))
+	assert.False(t, counter.queries[0].Matches("an INFO line"))
+	assert.Equal(t, models.SourceAccess{"nginx": {{}}}, counter.access[0])
+
+	counter.set(12, false)
+	started := *now
+	scheduler.Evaluate(context.Background())
+	*now = now.Add(time.Minute)
+	scheduler.Evaluate(context.Background())
+
+	alerts := rec.received()
+	require.Len(t, alerts, 1, "a firing alert is sent once")
+	assert.Equal(t, StatusFiring, alerts[0].Status)
+	assert.Equal(t, "log-finder/errors", alerts[0].DedupKey)
+	assert.Equal(t, 12, alerts[0].Count)
+	assert.Equal(t, 10, alerts[0].Threshold)
+	assert.Equal(t, started, alerts[0].StartedAt)
+
+	status, err := scheduler.Search("errors")
+	require.NoError(t, err)
+	assert.True(t, status.Firing)
+	assert.Equal(t, 12, status.LastCount)
+
+	// A truncated count cannot show the errors stopped.
+	counter.set(2, true)
+	scheduler.Evaluate(context.Background())
+	assert.Len(t, rec.received(), 1)
+
+	counter.set(2, false)
+	*now = now.Add(time.Minute)
+	scheduler.Evaluate(context.Background())
+
+	alerts = rec.received()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, StatusResolved, alerts[1].Status)
+	assert.Equal(t, "log-finder/errors", alerts[1].DedupKey)
+	assert.Equal(t, started, alerts[1].StartedAt)
+	require.NotNil(t, alerts[1].ResolvedAt)
+	assert.Equal(t, *now, *alerts[1].ResolvedAt)
+
+	scheduler.Evaluate(context.Background())
+	assert.Len(t, rec.received(), 2)
+}
+
+func TestSchedulerBelowAndRepeat(t *testing.T) {
+	rec := newReceiver(t)
+	counter := &fakeCounter{}
+	scheduler, now := newTestScheduler(t, counter, Options{RepeatInterval: 10 * time.Minute})
+
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "heartbeat", Window: "1m", Threshold: 0, Below: true, Webhook: rec.URL}))
+
+	counter.set(0, false)
+	scheduler.Evaluate(context.Background())
+	*now = now.Add(5 * time.Minute)
+	scheduler.Evaluate(context.Background())
+	assert.Len(t, rec.received(), 1)
+
+	*now = now.Add(5 * time.Minute)
+	scheduler.Evaluate(context.Background())
+	alerts := rec.received()
+	require.Len(t, alerts, 2, "a firing alert is repeated after the repeat interval")
+	assert.Equal(t, alerts[0].StartedAt, alerts[1].StartedAt)
+	assert.True(t, alerts[1].Below)
+}
+
+func TestSchedulerRetriesFailedNotifications(t *testing.T) {
+	rec := newReceiver(t)
+	counter := &fakeCounter{}
+	scheduler, _ := newTestScheduler(t, counter, Options{Webhook: rec.URL})
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "errors", Window: "5m", Threshold: 1}))
+
+	counter.set(5, false)
+	rec.setStatus(http.StatusServiceUnavailable)
+	scheduler.Evaluate(context.Background())
+	status, _ := scheduler.Search("errors")
+	assert.False(t, status.Firing)
+
+	rec.setStatus(http.StatusOK)
+	scheduler.Evaluate(context.Background())
+	require.Len(t, rec.received(), 1)
+	assert.Equal(t, StatusFiring, rec.received()[0].Status)
+}
+
+func TestSchedulerResolvesDeletedSearches(t *testing.T) {
+	rec := newReceiver(t)
+	counter := &fakeCounter{}
+	scheduler, _ := newTestScheduler(t, counter, Options{})
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "errors", Window: "5m", Threshold: 1, Webhook: rec.URL}))
+
+	counter.set(5, false)
+	scheduler.Evaluate(context.Background())
+	require.NoError(t, scheduler.Delete("errors"))
+	scheduler.Evaluate(context.Background())
+
+	alerts := rec.received()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, StatusResolved, alerts[1].Status)
+	assert.Equal(t, "log-finder/errors", alerts[1].DedupKey)
+	assert.ErrorIs(t, scheduler.Delete("errors"), ErrSearchNotFound)
+}
+
+type fakeDetector struct {
+	report anomaly.Report
+	err    error
+	from   time.Time
+	to     time.Time
+}
+
+func (d *fakeDetector) Anomalies(ctx context.Context, from, to time.Time) (anomaly.Report, error) {
+	d.from, d.to = from, to
+	return d.report, d.err
+}
+
+func TestSchedulerAnomalies(t *testing.T) {
+	rec := newReceiver(t)
+	detector := &fakeDetector{}
+	scheduler, now := newTestScheduler(t, &fakeCounter{}, Options{Webhook: rec.URL, Interval: time.Minute, Anomalies: detector})
+
+	drop := anomaly.Anomaly{Source: "db", Kind: anomaly.VolumeDrop, Start: now.Add(-3 * time.Minute), End: *now}
+	spike := anomaly.Anomaly{Source: "app", Kind: anomaly.VolumeSpike, Start: now.Add(-8 * time.Minute), End: now.Add(-6 * time.Minute)}
+	detector.report = anomaly.Report{To: now.Add(-time.Minute), Anomalies: []anomaly.Anomaly{spike, drop}}
+	scheduler.Evaluate(context.Background())
+
+	assert.Equal(t, now.Add(-10*time.Minute), detector.from)
+	assert.Equal(t, *now, detector.to)
+	alerts := rec.received()
+	require.Len(t, alerts, 1, "anomalies that are already over are not notified")
+	assert.Equal(t, StatusFiring, alerts[0].Status)
+	assert.Equal(t, "log-finder/anomaly/db/volume_drop/2024-06-10T12:57:00Z", alerts[0].DedupKey)
+
+	*now = now.Add(time.Minute)
+	drop.End = *now
+	detector.report = anomaly.Report{To: now.Add(-time.Minute), Anomalies: []anomaly.Anomaly{drop}}
+	scheduler.Evaluate(context.Background())
+	assert.Len(t, rec.received(), 1, "an ongoing anomaly is notified once")
+
+	*now = now.Add(time.Minute)
+	detector.report = anomaly.Report{To: now.Add(-time.Minute), Anomalies: []anomaly.Anomaly{drop}}
+	rec.setStatus(http.StatusServiceUnavailable)
+	scheduler.Evaluate(context.Background())
+	rec.setStatus(http.StatusOK)
+	scheduler.Evaluate(context.Background())
+
+	alerts = rec.received()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, StatusResolved, alerts[1].Status)
+	assert.Equal(t, alerts[0].DedupKey, alerts[1].DedupKey)
+
+	scheduler.Evaluate(context.Background())
+	assert.Len(t, rec.received(), 2)
+
+	// An anomaly that left the checked span is resolved too.
+	*now = now.Add(time.Minute)
+	drop = anomaly.Anomaly{Source: "db", Kind: anomaly.VolumeDrop, Start: now.Add(-time.Minute), End: *now}
+	detector.report = anomaly.Report{To: now.Add(-time.Minute), Anomalies: []anomaly.Anomaly{drop}}
+	scheduler.Evaluate(context.Background())
+	detector.report = anomaly.Report{To: *now}
+	scheduler.Evaluate(context.Background())
+
+	alerts = rec.received()
+	require.Len(t, alerts, 4)
+	assert.Equal(t, StatusFiring, alerts[2].Status)
+	assert.Equal(t, StatusResolved, alerts[3].Status)
+	assert.Equal(t, alerts[2].DedupKey, alerts[3].DedupKey)
+}
+
+func TestSchedulerStoresSearches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "searches.json")
+	scheduler, err := NewScheduler(path, &fakeCounter{}, Options{Webhook: "http://alerts.example/hook"})
+	require.NoError(t, err)
+
+	for name, search := range map[string]*SavedSearch{
+		"bad name":      {Name: "a/b", Window: "5m", Threshold: 1},
+		"bad window":    {Name: "a", Window: "5", Threshold: 1},
+		"bad pattern":   {Name: "a", Window: "5m", Threshold: 1, Pattern: "("},
+		"bad threshold": {Name: "a", Window: "5m"},
+		"bad webhook":   {Name: "a", Window: "5m", Threshold: 1, Webhook: "ftp://x"},
+	} {
+		assert.ErrorIs(t, scheduler.Put(search), ErrInvalidSearch, name)
+	}
+
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "b", Window: "1m", Threshold: 1}))
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "a", Window: "5m", Threshold: 3, Pattern: "ERROR"}))
+
+	reopened, err := NewScheduler(path, &fakeCounter{}, Options{Webhook: "http://alerts.example/hook"})
+	require.NoError(t, err)
+	searches := reopened.Searches()
+	require.Len(t, searches, 2)
+	assert.Equal(t, "a", searches[0].Name)
+	assert.Equal(t, "ERROR", searches[0].Pattern)
+	assert.Equal(t, "b", searches[1].Name)
+
+	// Searches that rely on the default webhook cannot load without one.
+	_, err = NewScheduler(path, &fakeCounter{}, Options{})
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name": "a", "window": "oops", "threshold": 1}]`), 0600))
+	_, err = NewScheduler(path, &fakeCounter{}, Options{Webhook: "http://alerts.example/hook"})
+	assert.Error(t, err)
+}
+
+func TestSchedulerStartAndClose(t *testing.T) {
+	rec := newReceiver(t)
+	counter := &fakeCounter{}
+	counter.set(1, false)
+	scheduler, _ := newTestScheduler(t, counter, Options{Webhook: rec.URL, Interval: 10 * time.Millisecond})
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "errors", Window: "5m", Threshold: 1}))
+
+	scheduler.Start()
+	assert.Eventually(t, func() bool { return len(rec.received()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	scheduler.Close()
+}
+
+func TestSchedulerCloseCancelsDelivery(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hung.Close)
+
+	counter := &fakeCounter{}
+	counter.set(1, false)
+	scheduler, _ := newTestScheduler(t, counter, Options{Webhook: hung.URL, Interval: 10 * time.Millisecond, Timeout: time.Minute})
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "errors", Window: "5m", Threshold: 1}))
+
+	scheduler.Start()
+	<-requested
+
+	closed := make(chan struct{})
+	go func() {
+		scheduler.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the hung webhook")
+	}
+}
+
+func TestSchedulerDeliveryTimeout(t *testing.T) {
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hung.Close)
+	rec := newReceiver(t)
+
+	counter := &fakeCounter{}
+	counter.set(1, false)
+	scheduler, _ := newTestScheduler(t, counter, Options{Webhook: rec.URL, Timeout: 50 * time.Millisecond})
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "a", Window: "5m", Threshold: 1, Webhook: hung.URL}))
+	require.NoError(t, scheduler.Put(&SavedSearch{Name: "b", Window: "5m", Threshold: 1}))
+
+	scheduler.Evaluate(context.Background())
+	alerts := rec.received()
+	require.Len(t, alerts, 1, "a hung webhook does not hold up the other searches")
+	assert.Equal(t, "b", alerts[0].Search)
+}
+
+func TestLogClock(t *testing.T) {
+	now := logClock()
+	assert.Equal(t, time.UTC, now.Location())
+	assert.WithinDuration(t, time.Now(), now, time.Second)
+}
@@ -0,0 +1,412 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/anomaly"
+	"github.com/Dor1ma/log-finder/internal/metrics"
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+type Counter interface {
+	CountRange(ctx context.Context, query models.RangeQuery) (models.CountResult, error)
+}
+
+type AnomalyDetector interface {
+	Anomalies(ctx context.Context, from, to time.Time) (anomaly.Report, error)
+}
+
+type Options struct {
+	// Interval between evaluations of every saved search.
+	Interval time.Duration
+	// Webhook receives alerts of searches that do not name their own.
+	Webhook string
+	// RepeatInterval re-sends a firing alert this long after the last
+	// notification; zero sends it once.
+	RepeatInterval time.Duration
+	Timeout        time.Duration
+	// Anomalies, when set, are checked at every evaluation and reported to
+	// the default webhook.
+	Anomalies AnomalyDetector
+}
+
+// Scheduler evaluates the saved searches on an interval and notifies their
+// webhooks when a search starts and stops breaching its threshold. A
+// notification that fails is retried at the next evaluation.
+type Scheduler struct {
+	store   *store
+	counter Counter
+	webhook *Webhook
+	opts    Options
+	now     func() time.Time
+
+	mutex  sync.Mutex
+	states map[string]*state
+	// anomalies holds the anomalies notified as firing, by dedup key; only
+	// the evaluation goroutine uses it.
+	anomalies map[string]anomaly.Anomaly
+
+	// ctx is cancelled by Close, ending deliveries still in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type state struct {
+	webhook   string
+	firing    bool
+	since     time.Time
+	notified  time.Time
+	count     int
+	evaluated time.Time
+	err       string
+}
+
+// Status is a saved search with the outcome of its last evaluation.
+type Status struct {
+	*SavedSearch
+	Firing      bool       `json:"firing"`
+	FiringSince *time.Time `json:"firing_since,omitempty"`
+	LastCount   int        `json:"last_count"`
+	EvaluatedAt *time.Time `json:"evaluated_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func NewScheduler(path string, counter Counter, opts Options) (*Scheduler, error) {
+	store, err := openStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Webhook != "" && !validWebhook(opts.Webhook) {
+		return nil, errors.New("default webhook must be an http or https URL")
+	}
+	for _, search := range store.list() {
+		if search.Webhook == "" && opts.Webhook == "" {
+			return nil, fmt.Errorf("search %q has no webhook and no default is configured", search.Name)
+		}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		store:     store,
+		counter:   counter,
+		webhook:   NewWebhook(opts.Timeout),
+		opts:      opts,
+		now:       logClock,
+		states:    make(map[string]*state),
+		anomalies: make(map[string]anomaly.Anomaly),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// logClock reads the wall clock in UTC, the zone log timestamps are parsed
+// in.
+func logClock() time.Time {
+	return time.Now().UTC()
+}
+
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Evaluate(s.ctx)
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the evaluations and cancels notifications being sent.
+func (s *Scheduler) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) Searches() []Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var statuses []Status
+	for _, search := range s.store.list() {
+		statuses = append(statuses, s.status(search))
+	}
+	return statuses
+}
+
+func (s *Scheduler) Search(name string) (Status, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, search := range s.store.list() {
+		if search.Name == name {
+			return s.status(search), nil
+		}
+	}
+	return Status{}, ErrSearchNotFound
+}
+
+func (s *Scheduler) status(search *SavedSearch) Status {
+	status := Status{SavedSearch: search}
+	if st, ok := s.states[search.Name]; ok {
+		status.Firing = st.firing
+		status.LastCount = st.count
+		status.Error = st.err
+		if st.firing {
+			status.FiringSince = &st.since
+		}
+		if !st.evaluated.IsZero() {
+			status.EvaluatedAt = &st.evaluated
+		}
+	}
+	return status
+}
+
+// Put creates or replaces a saved search. A replaced search keeps its alert
+// state, so editing a firing search does not notify again.
+func (s *Scheduler) Put(search *SavedSearch) error {
+	if err := search.compile(); err != nil {
+		return err
+	}
+	if search.Webhook == "" && s.opts.Webhook == "" {
+		return fmt.Errorf("%w: webhook is required, no default is configured", ErrInvalidSearch)
+	}
+	return s.store.put(search)
+}
+
+// Delete removes a saved search; a firing alert of it is resolved at the
+// next evaluation.
+func (s *Scheduler) Delete(name string) error {
+	return s.store.delete(name)
+}
+
+// Evaluate counts every saved search over the window ending now and sends
+// the notifications its state calls for.
+func (s *Scheduler) Evaluate(ctx context.Context) {
+	now := s.now()
+	searches := s.store.list()
+
+	current := make(map[string]bool, len(searches))
+	for _, search := range searches {
+		current[search.Name] = true
+		s.evaluate(ctx, search, now)
+	}
+
+	s.mutex.Lock()
+	var orphans map[string]*state
+	for name, st := range s.states {
+		if !current[name] {
+			if orphans == nil {
+				orphans = make(map[string]*state)
+			}
+			orphans[name] = st
+		}
+	}
+	s.mutex.Unlock()
+
+	// Firing alerts of deleted searches would otherwise never resolve.
+	for name, st := range orphans {
+		if st.firing {
+			alert := Alert{Status: StatusResolved, DedupKey: dedupKey(name), Search: name, StartedAt: st.since, ResolvedAt: &now}
+			if err := s.notify(ctx, st.webhook, alert); err != nil {
+				continue
+			}
+		}
+		s.mutex.Lock()
+		delete(s.states, name)
+		s.mutex.Unlock()
+	}
+
+	if s.opts.Anomalies != nil {
+		s.evaluateAnomalies(ctx, now)
+	}
+}
+
+func (s *Scheduler) evaluate(ctx context.Context, search *SavedSearch, now time.Time) {
+	s.mutex.Lock()
+	st, ok := s.states[search.Name]
+	if !ok {
+		st = &state{}
+		s.states[search.Name] = st
+	}
+	firing, since, notified := st.firing, st.since, st.notified
+	s.mutex.Unlock()
+
+	query := models.RangeQuery{From: now.Add(-search.window), To: now, Pattern: search.pattern}
+	result, err := s.counter.CountRange(models.WithSourceAccess(ctx, search.access()), query)
+
+	s.mutex.Lock()
+	st.evaluated = now
+	st.err = ""
+	if err != nil {
+		st.err = err.Error()
+	} else {
+		st.count = result.Total
+	}
+	s.mutex.Unlock()
+
+	if err != nil {
+		slog.Warn("Saved search evaluation failed", "search", search.Name, "error", err)
+		metrics.AlertEvaluationErrors.Inc()
+		return
+	}
+
+	breached := search.breached(result.Total)
+	// A truncated count only errs low, so it cannot prove that a count
+	// stayed under a threshold, or that it fell below one.
+	if result.Truncated && breached == search.Below {
+		return
+	}
+
+	alert := Alert{
+		DedupKey:  dedupKey(search.Name),
+		Search:    search.Name,
+		Pattern:   search.Pattern,
+		Sources:   search.Sources,
+		Count:     result.Total,
+		Threshold: search.Threshold,
+		Below:     search.Below,
+		Window:    search.Window,
+		From:      query.From,
+		To:        query.To,
+		StartedAt: since,
+	}
+
+	switch {
+	case breached && !firing:
+		alert.Status = StatusFiring
+		alert.StartedAt = now
+	case breached && s.opts.RepeatInterval > 0 && now.Sub(notified) >= s.opts.RepeatInterval:
+		alert.Status = StatusFiring
+	case !breached && firing:
+		alert.Status = StatusResolved
+		alert.ResolvedAt = &now
+	default:
+		return
+	}
+
+	webhook := s.webhookOf(search)
+	if err := s.notify(ctx, webhook, alert); err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	st.webhook = webhook
+	st.firing = alert.Status == StatusFiring
+	st.since = alert.StartedAt
+	st.notified = now
+	s.mutex.Unlock()
+}
+
+func (s *Scheduler) webhookOf(search *SavedSearch) string {
+	if search.Webhook != "" {
+		return search.Webhook
+	}
+	return s.opts.Webhook
+}
+
+// deliver sends one notification, giving up after the timeout so a webhook
+// that hangs cannot hold up the other searches.
+func (s *Scheduler) deliver(ctx context.Context, url string, body any) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+	return s.webhook.Send(ctx, url, body)
+}
+
+func (s *Scheduler) notify(ctx context.Context, url string, alert Alert) error {
+	err := s.deliver(ctx, url, alert)
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+		slog.Warn("Failed to send alert", "search", alert.Search, "status", alert.Status, "error", err)
+	} else {
+		slog.Info("Alert sent", "search", alert.Search, "status", alert.Status, "count", alert.Count)
+	}
+	metrics.AlertNotifications.Inc(alert.Status, outcome)
+	return err
+}
+
+func dedupKey(name string) string {
+	return "log-finder/" + name
+}
+
+// anomalyLookback is the least span checked for anomalies, so one that
+// started shortly before an evaluation is not missed.
+const anomalyLookback = 10 * time.Minute
+
+func (s *Scheduler) evaluateAnomalies(ctx context.Context, now time.Time) {
+	report, err := s.opts.Anomalies.Anomalies(ctx, now.Add(-max(2*s.opts.Interval, anomalyLookback)), now)
+	if err != nil {
+		slog.Warn("Anomaly evaluation failed", "error", err)
+		metrics.AlertEvaluationErrors.Inc()
+		return
+	}
+
+	seen := make(map[string]bool, len(report.Anomalies))
+	for _, a := range report.Anomalies {
+		key := anomalyKey(a)
+		seen[key] = true
+		_, firing := s.anomalies[key]
+
+		switch {
+		case !firing && report.Ongoing(a):
+			if s.notifyAnomaly(ctx, StatusFiring, key, a) == nil {
+				s.anomalies[key] = a
+			}
+		case firing && !report.Ongoing(a):
+			if s.notifyAnomaly(ctx, StatusResolved, key, a) == nil {
+				delete(s.anomalies, key)
+			}
+		case firing:
+			s.anomalies[key] = a
+		}
+	}
+
+	// Runs that left the checked span ended long ago.
+	for key, a := range s.anomalies {
+		if !seen[key] && s.notifyAnomaly(ctx, StatusResolved, key, a) == nil {
+			delete(s.anomalies, key)
+		}
+	}
+}
+
+func (s *Scheduler) notifyAnomaly(ctx context.Context, status, key string, a anomaly.Anomaly) error {
+	err := s.deliver(ctx, s.opts.Webhook, AnomalyAlert{
+		Status:   status,
+		DedupKey: key,
+		Source:   a.Source,
+		Kind:     a.Kind,
+		Start:    a.Start,
+		End:      a.End,
+		Value:    a.Value,
+		Expected: a.Expected,
+		Score:    a.Score,
+	})
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+		slog.Warn("Failed to send anomaly alert", "source", a.Source, "kind", a.Kind, "status", status, "error", err)
+	} else {
+		slog.Info("Anomaly alert sent", "source", a.Source, "kind", a.Kind, "status", status)
+	}
+	metrics.AlertNotifications.Inc(status, outcome)
+	return err
+}
+
+func anomalyKey(a anomaly.Anomaly) string {
+	return "log-finder/anomaly/" + a.Source + "/" + a.Kind + "/" + a.Start.Format(time.RFC3339)
+}
@@ -0,0 +1,199 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+// SavedSearch is a named count query evaluated over the Window before each
+// check. It breaches when the count reaches Threshold, or with Below set
+// when the count falls to Threshold or under.
+type SavedSearch struct {
+	Name      string   `json:"name"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Sources   []string `json:"sources,omitempty"`
+	Window    string   `json:"window"`
+	Threshold int      `json:"threshold"`
+	Below     bool     `json:"below,omitempty"`
+	Webhook   string   `json:"webhook,omitempty"`
+
+	pattern *regexp.Regexp
+	window  time.Duration
+}
+
+var (
+	ErrInvalidSearch  = errors.New("invalid saved search")
+	ErrSearchNotFound = errors.New("saved search not found")
+
+	searchName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+func (s *SavedSearch) compile() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSearch, fmt.Sprintf(format, args...))
+	}
+
+	if !searchName.MatchString(s.Name) {
+		return invalid("name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+
+	var err error
+	if s.window, err = time.ParseDuration(s.Window); err != nil || s.window <= 0 {
+		return invalid("window must be a positive duration, got %q", s.Window)
+	}
+
+	s.pattern = nil
+	if s.Pattern != "" {
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return invalid("pattern: %v", err)
+		}
+	}
+
+	if s.Threshold < 0 || (s.Threshold == 0 && !s.Below) {
+		return invalid("threshold must be positive, or zero with below")
+	}
+
+	if s.Webhook != "" && !validWebhook(s.Webhook) {
+		return invalid("webhook must be an http or https URL")
+	}
+	return nil
+}
+
+func validWebhook(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (s *SavedSearch) breached(count int) bool {
+	if s.Below {
+		return count <= s.Threshold
+	}
+	return count >= s.Threshold
+}
+
+// access restricts the count to Sources; nil means every source.
+func (s *SavedSearch) access() models.SourceAccess {
+	if len(s.Sources) == 0 {
+		return nil
+	}
+	access := make(models.SourceAccess, len(s.Sources))
+	for _, source := range s.Sources {
+		access[source] = []models.TimeRange{{}}
+	}
+	return access
+}
+
+// store keeps the saved searches in a JSON file, rewritten on every change.
+type store struct {
+	path string
+
+	mutex    sync.Mutex
+	searches map[string]*SavedSearch
+}
+
+func openStore(path string) (*store, error) {
+	s := &store{path: path, searches: make(map[string]*SavedSearch)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var searches []*SavedSearch
+	if err := json.Unmarshal(data, &searches); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, search := range searches {
+		if err := search.compile(); err != nil {
+			return nil, fmt.Errorf("%s: search %q: %w", path, search.Name, err)
+		}
+		s.searches[search.Name] = search
+	}
+	return s, nil
+}
+
+func (s *store) list() []*SavedSearch {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sorted()
+}
+
+func (s *store) sorted() []*SavedSearch {
+	searches := make([]*SavedSearch, 0, len(s.searches))
+	for _, search := range s.searches {
+		searches = append(searches, search)
+	}
+	slices.SortFunc(searches, func(a, b *SavedSearch) int { return strings.Compare(a.Name, b.Name) })
+	return searches
+}
+
+func (s *store) put(search *SavedSearch) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, existed := s.searches[search.Name]
+	s.searches[search.Name] = search
+	if err := s.save(); err != nil {
+		if existed {
+			s.searches[search.Name] = previous
+		} else {
+			delete(s.searches, search.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *store) delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	search, ok := s.searches[name]
+	if !ok {
+		return ErrSearchNotFound
+	}
+	delete(s.searches, name)
+	if err := s.save(); err != nil {
+		s.searches[name] = search
+		return err
+	}
+	return nil
+}
+
+// save writes to a temporary file and renames it, so a crash never leaves
+// a half written file behind.
+func (s *store) save() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,83 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Alert is the JSON body posted to webhooks. Notifications about the same
+// condition share DedupKey, and the one that ends it has status resolved.
+type Alert struct {
+	Status     string     `json:"status"`
+	DedupKey   string     `json:"dedup_key"`
+	Search     string     `json:"search"`
+	Pattern    string     `json:"pattern,omitempty"`
+	Sources    []string   `json:"sources,omitempty"`
+	Count      int        `json:"count"`
+	Threshold  int        `json:"threshold"`
+	Below      bool       `json:"below,omitempty"`
+	Window     string     `json:"window"`
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// AnomalyAlert is the JSON body posted for an anomaly. Each run of flagged
+// minutes is one alert, resolved once the run is over.
+type AnomalyAlert struct {
+	Status   string    `json:"status"`
+	DedupKey string    `json:"dedup_key"`
+	Source   string    `json:"source"`
+	Kind     string    `json:"kind"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Value    float64   `json:"value"`
+	Expected float64   `json:"expected"`
+	Score    float64   `json:"score"`
+}
+
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+type Webhook struct {
+	client *http.Client
+}
+
+func NewWebhook(timeout time.Duration) *Webhook {
+	return &Webhook{client: &http.Client{Timeout: timeout}}
+}
+
+// Send posts alert to url; any status other than 2xx is an error.
+func (w *Webhook) Send(ctx context.Context, url string, alert any) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "log-finder")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package anomaly
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+// Kinds of anomaly.
+const (
+	VolumeSpike = "volume_spike"
+	VolumeDrop  = "volume_drop"
+	ErrorRatio  = "error_ratio"
+)
+
+type Options struct {
+	// Baseline is the span the moving average mostly reflects; it also sets
+	// how far before the requested range the series is read to warm up.
+	Baseline time.Duration
+	// Threshold is the deviation, in standard deviations, that is flagged.
+	Threshold float64
+	// MinErrorRatio is the least rise of the error ratio over its baseline
+	// that is flagged, so a source that rarely logs errors is not flagged
+	// for a single one.
+	MinErrorRatio float64
+}
+
+// Anomaly is a run of consecutive minutes of one source flagged for the
+// same reason. Value and Expected are those of the minute with the highest
+// Score; ratios are given for error_ratio, line counts otherwise.
+type Anomaly struct {
+	Source   string    `json:"source"`
+	Kind     string    `json:"kind"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Value    float64   `json:"value"`
+	Expected float64   `json:"expected"`
+	Score    float64   `json:"score"`
+}
+
+// Report holds the anomalies found in [From, To]. To may be earlier than
+// requested: minutes that have not finished yet are not judged.
+type Report struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// Ongoing reports whether a is still going on at the end of the report.
+func (r Report) Ongoing(a Anomaly) bool {
+	return a.End.After(r.To)
+}
+
+const (
+	// minSamples minutes are needed before a baseline is trusted.
+	minSamples = 10
+	// minErrorLines is the least lines a minute needs for its error ratio to
+	// be judged.
+	minErrorLines = 10
+	// Flagged minutes move the mean this much slower and leave the variance
+	// alone, so a lasting outage stays flagged for a while instead of
+	// becoming the new normal.
+	anomalyDamping = 10
+)
+
+// ewma is an exponentially weighted mean and variance.
+type ewma struct {
+	alpha    float64
+	mean     float64
+	variance float64
+	samples  int
+}
+
+func (e *ewma) update(x float64, damped bool) {
+	if e.samples == 0 {
+		e.mean = x
+		e.samples++
+		return
+	}
+	e.samples++
+	if damped {
+		e.mean += e.alpha / anomalyDamping * (x - e.mean)
+		return
+	}
+	diff := x - e.mean
+	incr := e.alpha * diff
+	e.mean += incr
+	e.variance = (1 - e.alpha) * (e.variance + diff*incr)
+}
+
+// Detect flags the minutes in [from, to] where a source's line count or
+// error ratio is far from its exponentially weighted moving average.
+// series should start a few baselines before from to warm the averages up.
+// A source is followed from its first minute with lines up to the last
+// finished minute of the whole index, so a source that goes silent while
+// others keep logging shows up as a volume drop.
+func Detect(series models.MinuteSeries, from, to time.Time, opts Options) Report {
+	last := series.End.Truncate(time.Minute).Add(-time.Minute)
+	if last.Before(to) {
+		to = last
+	}
+	to = to.Truncate(time.Minute)
+
+	alpha := 2 / (opts.Baseline.Minutes() + 1)
+	var anomalies []Anomaly
+	for source, counts := range series.Sources {
+		first := time.Time{}
+		for minute, count := range counts {
+			if count.Lines > 0 && (first.IsZero() || minute.Before(first)) {
+				first = minute
+			}
+		}
+		if first.IsZero() {
+			continue
+		}
+
+		d := detector{source: source, opts: opts, volume: ewma{alpha: alpha}, errors: ewma{alpha: alpha}}
+		for minute := first; !minute.After(to); minute = minute.Add(time.Minute) {
+			d.observe(minute, counts[minute], !minute.Before(from))
+		}
+		for _, a := range d.anomalies {
+			a.Value, a.Expected, a.Score = round(a.Value), round(a.Expected), round(a.Score)
+			anomalies = append(anomalies, a)
+		}
+	}
+
+	slices.SortFunc(anomalies, func(a, b Anomaly) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Source, b.Source); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Kind, b.Kind)
+	})
+	if anomalies == nil {
+		anomalies = []Anomaly{}
+	}
+	return Report{From: from, To: to, Anomalies: anomalies}
+}
+
+type detector struct {
+	source    string
+	opts      Options
+	volume    ewma
+	errors    ewma
+	anomalies []Anomaly
+	// open holds the index in anomalies of the run each kind is in, if any.
+	open map[string]int
+}
+
+func (d *detector) observe(minute time.Time, count models.MinuteCount, report bool) {
+	lines := float64(count.Lines)
+
+	volumeFlagged := false
+	if d.volume.samples >= minSamples {
+		// Line counts are at least as noisy as a Poisson process.
+		std := max(math.Sqrt(d.volume.variance), math.Sqrt(d.volume.mean), 1)
+		score := (lines - d.volume.mean) / std
+		if math.Abs(score) >= d.opts.Threshold {
+			volumeFlagged = true
+			kind := VolumeSpike
+			if score < 0 {
+				kind = VolumeDrop
+			}
+			d.flag(report, kind, minute, lines, d.volume.mean, math.Abs(score))
+		}
+	}
+	d.volume.update(lines, volumeFlagged)
+
+	if count.Lines < minErrorLines {
+		d.close(ErrorRatio)
+	} else {
+		ratio := float64(count.Errors) / lines
+		ratioFlagged := false
+		if d.errors.samples >= minSamples {
+			p := d.errors.mean
+			// Even a steady ratio varies with the lines of a minute.
+			std := max(math.Sqrt(d.errors.variance), math.Sqrt(p*(1-p)/lines), 0.01)
+			score := (ratio - p) / std
+			if score >= d.opts.Threshold && ratio-p >= d.opts.MinErrorRatio {
+				ratioFlagged = true
+				d.flag(report, ErrorRatio, minute, ratio, p, score)
+			}
+		}
+		if !ratioFlagged {
+			d.close(ErrorRatio)
+		}
+		d.errors.update(ratio, ratioFlagged)
+	}
+
+	if !volumeFlagged {
+		d.close(VolumeSpike)
+		d.close(VolumeDrop)
+	}
+}
+
+func (d *detector) flag(report bool, kind string, minute time.Time, value, expected, score float64) {
+	if !report {
+		return
+	}
+	if d.open == nil {
+		d.open = make(map[string]int)
+	}
+	// A spike right after a drop starts a new run of its own.
+	switch kind {
+	case VolumeSpike:
+		d.close(VolumeDrop)
+	case VolumeDrop:
+		d.close(VolumeSpike)
+	}
+
+	if i, ok := d.open[kind]; ok {
+		a := &d.anomalies[i]
+		a.End = minute.Add(time.Minute)
+		if score > a.Score {
+			a.Value, a.Expected, a.Score = value, expected, score
+		}
+		return
+	}
+	d.open[kind] = len(d.anomalies)
+	d.anomalies = append(d.anomalies, Anomaly{
+		Source:   d.source,
+		Kind:     kind,
+		Start:    minute,
+		End:      minute.Add(time.Minute),
+		Value:    value,
+		Expected: expected,
+		Score:    score,
+	})
+}
+
+func round(x float64) float64 {
+	return math.Round(x*1000) / 1000
+}
+
+func (d *detector) close(kind string) {
+	delete(d.open, kind)
+}
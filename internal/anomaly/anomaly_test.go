@@ -0,0 +1,112 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	start       = time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	testOptions = Options{Baseline: 30 * time.Minute, Threshold: 4, MinErrorRatio: 0.05}
+)
+
+func minute(i int) time.Time {
+	return start.Add(time.Duration(i) * time.Minute)
+}
+
+// steady logs about 100 lines a minute, one of them an error, over minutes
+// [from, to).
+func steady(counts map[time.Time]models.MinuteCount, from, to int) {
+	for i := from; i < to; i++ {
+		counts[minute(i)] = models.MinuteCount{Lines: 100 + i%5, Errors: 1}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	app := make(map[time.Time]models.MinuteCount)
+	steady(app, 0, 180)
+	app[minute(100)] = models.MinuteCount{Lines: 400, Errors: 1}
+	app[minute(101)] = models.MinuteCount{Lines: 450, Errors: 1}
+	app[minute(120)] = models.MinuteCount{Lines: 100, Errors: 40}
+
+	db := make(map[time.Time]models.MinuteCount)
+	steady(db, 0, 150)
+
+	series := models.MinuteSeries{
+		Sources: map[string]map[time.Time]models.MinuteCount{"app": app, "db": db},
+		End:     minute(180).Add(-time.Second),
+	}
+	report := Detect(series, minute(90), minute(200), testOptions)
+
+	assert.Equal(t, minute(178), report.To, "the unfinished last minute is not judged")
+	require.Len(t, report.Anomalies, 3)
+
+	spike := report.Anomalies[0]
+	assert.Equal(t, "app", spike.Source)
+	assert.Equal(t, VolumeSpike, spike.Kind)
+	assert.Equal(t, minute(100), spike.Start)
+	assert.Equal(t, minute(102), spike.End)
+	assert.Equal(t, float64(450), spike.Value)
+	assert.InDelta(t, 102, spike.Expected, 5)
+	assert.False(t, report.Ongoing(spike))
+
+	ratio := report.Anomalies[1]
+	assert.Equal(t, "app", ratio.Source)
+	assert.Equal(t, ErrorRatio, ratio.Kind)
+	assert.Equal(t, minute(120), ratio.Start)
+	assert.Equal(t, minute(121), ratio.End)
+	assert.Equal(t, 0.4, ratio.Value)
+
+	drop := report.Anomalies[2]
+	assert.Equal(t, "db", drop.Source)
+	assert.Equal(t, VolumeDrop, drop.Kind)
+	assert.Equal(t, minute(150), drop.Start)
+	assert.Equal(t, float64(0), drop.Value)
+	assert.True(t, report.Ongoing(drop), "a source that went silent stays flagged")
+}
+
+func TestDetectRange(t *testing.T) {
+	app := make(map[time.Time]models.MinuteCount)
+	steady(app, 0, 60)
+	app[minute(3)] = models.MinuteCount{Lines: 1000}
+	app[minute(40)] = models.MinuteCount{Lines: 1000}
+
+	series := models.MinuteSeries{
+		Sources: map[string]map[time.Time]models.MinuteCount{"app": app},
+		End:     minute(60),
+	}
+
+	report := Detect(series, minute(0), minute(30), testOptions)
+	assert.Empty(t, report.Anomalies, "nothing is flagged before the baseline warms up")
+	assert.NotNil(t, report.Anomalies)
+
+	report = Detect(series, minute(45), minute(59), testOptions)
+	assert.Empty(t, report.Anomalies, "anomalies before from are left out")
+
+	report = Detect(series, minute(30), minute(59), testOptions)
+	require.Len(t, report.Anomalies, 1)
+	assert.Equal(t, minute(40), report.Anomalies[0].Start)
+}
+
+func TestDetectErrorRatioNeedsLines(t *testing.T) {
+	quiet := make(map[time.Time]models.MinuteCount)
+	for i := range 60 {
+		quiet[minute(i)] = models.MinuteCount{Lines: 5}
+	}
+	quiet[minute(40)] = models.MinuteCount{Lines: 5, Errors: 5}
+
+	loud := make(map[time.Time]models.MinuteCount)
+	steady(loud, 0, 60)
+	loud[minute(40)] = models.MinuteCount{Lines: 100, Errors: 4}
+
+	series := models.MinuteSeries{
+		Sources: map[string]map[time.Time]models.MinuteCount{"quiet": quiet, "loud": loud},
+		End:     minute(60),
+	}
+	report := Detect(series, minute(0), minute(59), testOptions)
+	assert.Empty(t, report.Anomalies, "few lines or a small rise in the error ratio are not flagged")
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readRecords(t *testing.T, path string) []*Record {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		r := &Record{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestRecordFromContext(t *testing.T) {
+	// Reporting without an audited request is a no-op.
+	FromContext(context.Background()).AddSources("default")
+	FromContext(context.Background()).SetResults(1)
+
+	record := &Record{}
+	ctx := WithRecord(context.Background(), record)
+	FromContext(ctx).AddSources("nginx", "default")
+	FromContext(ctx).AddSources("nginx")
+	FromContext(ctx).SetResults(3)
+	FromContext(ctx).SetCached()
+
+	assert.Equal(t, []string{"default", "nginx"}, record.Sources)
+	require.NotNil(t, record.Results)
+	assert.Equal(t, 3, *record.Results)
+	assert.True(t, record.Cached)
+}
+
+func TestLoggerRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(path, 300, 2)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		logger.Write(&Record{Action: "search", Route: "/logs/range", Params: map[string]string{"pattern": strings.Repeat("x", 50)}})
+	}
+	require.NoError(t, logger.Close())
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		require.NoError(t, err, name)
+		assert.LessOrEqual(t, info.Size(), int64(300), name)
+		assert.NotEmpty(t, readRecords(t, name), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoggerAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(path, 0, 0)
+	require.NoError(t, err)
+	logger.Write(&Record{Action: "search"})
+	require.NoError(t, logger.Close())
+
+	logger, err = Open(path, 0, 0)
+	require.NoError(t, err)
+	logger.Write(&Record{Action: "admin"})
+	require.NoError(t, logger.Close())
+
+	records := readRecords(t, path)
+	require.Len(t, records, 2)
+	assert.Equal(t, "search", records[0].Action)
+	assert.Equal(t, "admin", records[1].Action)
+
+	var nilLogger *Logger
+	nilLogger.Write(&Record{})
+	assert.NoError(t, nilLogger.Close())
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// Logger appends records as JSON lines to a file. When the file would grow
+// past maxSize it is renamed to path.1, older files shift up, and at most
+// maxBackups of them are kept.
+type Logger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func Open(path string, maxSize int64, maxBackups int) (*Logger, error) {
+	l := &Logger{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Write appends r. Failures are logged rather than returned so a broken
+// audit file does not fail the request that was already served.
+func (l *Logger) Write(r *Record) {
+	if l == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	line, err := json.Marshal(r)
+	r.mutex.Unlock()
+	if err != nil {
+		slog.Error("Failed to encode audit record", "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			slog.Error("Failed to reopen audit log", "path", l.path, "error", err)
+			return
+		}
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			slog.Error("Failed to rotate audit log", "path", l.path, "error", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write audit record", "path", l.path, "error", err)
+	}
+}
+
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.maxBackups > 0 {
+		os.Remove(l.backup(l.maxBackups))
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, l.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *Logger) backup(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Record is one audited request. The fields filled in while the request
+// is served (sources, results, cached) are set through nil-safe methods, so
+// code below the handlers can report without knowing whether auditing is
+// on.
+type Record struct {
+	Time       time.Time         `json:"time"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Principal  string            `json:"principal,omitempty"`
+	AuthMethod string            `json:"auth_method,omitempty"`
+	RemoteAddr string            `json:"remote_addr"`
+	Action     string            `json:"action"`
+	Method     string            `json:"method"`
+	Route      string            `json:"route"`
+	Params     map[string]string `json:"params,omitempty"`
+	Sources    []string          `json:"sources,omitempty"`
+	Cached     bool              `json:"cached,omitempty"`
+	Results    *int              `json:"results,omitempty"`
+	Status     int               `json:"status"`
+	DurationMS float64           `json:"duration_ms"`
+
+	mutex sync.Mutex
+}
+
+func (r *Record) SetPrincipal(name, method string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Principal = name
+	r.AuthMethod = method
+}
+
+// AddSources notes sources the request read from.
+func (r *Record) AddSources(sources ...string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, source := range sources {
+		if !slices.Contains(r.Sources, source) {
+			r.Sources = append(r.Sources, source)
+		}
+	}
+	slices.Sort(r.Sources)
+}
+
+func (r *Record) SetResults(n int) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Results = &n
+}
+
+// SetCached marks a request answered from the result cache or by joining
+// another request's search; its sources are then recorded on that request
+// only.
+func (r *Record) SetCached() {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Cached = true
+}
+
+type recordKey struct{}
+
+func WithRecord(ctx context.Context, r *Record) context.Context {
+	return context.WithValue(ctx, recordKey{}, r)
+}
+
+// FromContext returns the record of the request, or nil when it is not
+// audited.
+func FromContext(ctx context.Context) *Record {
+	r, _ := ctx.Value(recordKey{}).(*Record)
+	return r
+}
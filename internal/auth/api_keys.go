@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates the X-API-Key header against a file with one key
+// per line:
+//
+//	name key [role,role...]
+//
+// Empty lines and lines starting with # are ignored. Keys are kept only as
+// hashes, so a lookup does not compare secrets byte by byte.
+type APIKeys struct {
+	source *fileSource[map[[sha256.Size]byte]*Principal]
+}
+
+func NewAPIKeys(path string, reloadInterval time.Duration) (*APIKeys, error) {
+	source, err := newFileSource(path, reloadInterval, parseAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &APIKeys{source: source}, nil
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+
+	p, ok := a.source.Get()[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return p, nil
+}
+
+func (a *APIKeys) Reload() error {
+	return a.source.Reload()
+}
+
+func parseAPIKeys(data []byte) (map[[sha256.Size]byte]*Principal, error) {
+	keys := make(map[[sha256.Size]byte]*Principal)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected \"name key [roles]\"", n)
+		}
+
+		p := &Principal{Name: fields[0], Method: "api_key"}
+		if len(fields) == 3 {
+			p.Roles = strings.Split(fields[2], ",")
+		}
+
+		hash := sha256.Sum256([]byte(fields[1]))
+		if _, exists := keys[hash]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key", n)
+		}
+		keys[hash] = p
+	}
+
+	return keys, scanner.Err()
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name   string
+	Method string
+	Roles  []string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return p != nil && slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Authenticator checks one kind of credentials. It returns nil and no error
+// when the request does not carry credentials it understands, so the next
+// authenticator can try.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each authenticator in turn. Credentials that none of them
+// accepts are rejected rather than treated as anonymous.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	if hasCredentials(r) {
+		return nil, ErrInvalidCredentials
+	}
+	return nil, nil
+}
+
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get(APIKeyHeader) != ""
+}
@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func signJWT(t *testing.T, alg, secret string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeFile(t, path, "# name key roles\nci secret-ci\nops secret-ops admin,search\n")
+
+	keys, err := NewAPIKeys(path, 0)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/logs", nil)
+	p, err := keys.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Nil(t, p, "no key is left to the next authenticator")
+
+	req.Header.Set(APIKeyHeader, "secret-ops")
+	p, err = keys.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ops", p.Name)
+	assert.True(t, p.HasRole(RoleAdmin))
+
+	req.Header.Set(APIKeyHeader, "wrong")
+	_, err = keys.Authenticate(req)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	t.Run("reloads changed file", func(t *testing.T) {
+		writeFile(t, path, "ci secret-rotated\n")
+		require.NoError(t, keys.Reload())
+
+		req.Header.Set(APIKeyHeader, "secret-ci")
+		_, err := keys.Authenticate(req)
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		req.Header.Set(APIKeyHeader, "secret-rotated")
+		p, err := keys.Authenticate(req)
+		require.NoError(t, err)
+		assert.Equal(t, "ci", p.Name)
+	})
+
+	t.Run("keeps previous keys on bad file", func(t *testing.T) {
+		writeFile(t, path, "only-one-field-and-a-longer-line-so-size-changes\n")
+		req.Header.Set(APIKeyHeader, "secret-rotated")
+		p, err := keys.Authenticate(req)
+		require.NoError(t, err)
+		assert.Equal(t, "ci", p.Name)
+	})
+}
+
+func TestFileSourceNoticesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeFile(t, path, "ci old-key\n")
+
+	keys, err := NewAPIKeys(path, 0)
+	require.NoError(t, err)
+
+	writeFile(t, path, "ci new-key-longer\n")
+	req := httptest.NewRequest("GET", "/logs", nil)
+	req.Header.Set(APIKeyHeader, "new-key-longer")
+	p, err := keys.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ci", p.Name)
+}
+
+func TestJWT(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, path, testSecret+"\n")
+
+	jwt, err := NewJWT(path, "issuer", "log-finder", 0)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	jwt.now = func() time.Time { return now }
+
+	authenticate := func(token string) (*Principal, error) {
+		req := httptest.NewRequest("GET", "/logs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return jwt.Authenticate(req)
+	}
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"sub":   "alice",
+			"iss":   "issuer",
+			"aud":   []string{"other", "log-finder"},
+			"exp":   now.Add(time.Minute).Unix(),
+			"roles": []string{"admin"},
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	p, err := authenticate(signJWT(t, "HS256", testSecret, claims(nil)))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "alice", Method: "jwt", Roles: []string{"admin"}}, p)
+
+	p, err = authenticate("not-a-jwt")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	for name, token := range map[string]string{
+		"expired":        signJWT(t, "HS256", testSecret, claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})),
+		"missing exp":    signJWT(t, "HS256", testSecret, claims(map[string]any{"exp": nil})),
+		"not yet valid":  signJWT(t, "HS256", testSecret, claims(map[string]any{"nbf": now.Add(time.Hour).Unix()})),
+		"wrong issuer":   signJWT(t, "HS256", testSecret, claims(map[string]any{"iss": "someone"})),
+		"wrong audience": signJWT(t, "HS256", testSecret, claims(map[string]any{"aud": "other"})),
+		"bad signature":  signJWT(t, "HS256", "another-secret-another-secret-xx", claims(nil)),
+		"alg none":       signJWT(t, "none", testSecret, claims(nil)),
+		"alg mismatch":   signJWT(t, "HS512", testSecret, claims(nil)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := authenticate(token)
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		})
+	}
+
+	t.Run("short secret", func(t *testing.T) {
+		short := filepath.Join(t.TempDir(), "short")
+		writeFile(t, short, "too-short")
+		_, err := NewJWT(short, "", "", 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeFile(t, path, "bob:"+string(hash)+"\nalice:"+string(hash)+":support,payments\n")
+
+	htpasswd, err := NewHtpasswd(path, 0)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/logs", nil)
+	req.SetBasicAuth("bob", "s3cret")
+	p, err := htpasswd.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", p.Name)
+	assert.Equal(t, "basic", p.Method)
+	assert.Empty(t, p.Roles)
+
+	req.SetBasicAuth("alice", "s3cret")
+	p, err = htpasswd.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"support", "payments"}, p.Roles)
+
+	req.SetBasicAuth("bob", "wrong")
+	_, err = htpasswd.Authenticate(req)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	req.SetBasicAuth("eve", "s3cret")
+	_, err = htpasswd.Authenticate(req)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	t.Run("rejects weak hashes", func(t *testing.T) {
+		weak := filepath.Join(t.TempDir(), "weak")
+		writeFile(t, weak, "bob:$apr1$salt$hash\n")
+		_, err := NewHtpasswd(weak, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeFile(t, path, "ci secret-ci\n")
+	keys, err := NewAPIKeys(path, 0)
+	require.NoError(t, err)
+
+	admin := &Principal{Name: "admin", Roles: []string{RoleAdmin}}
+	chain := Chain{NewStaticToken("admin-token", admin), keys}
+
+	req := httptest.NewRequest("GET", "/logs", nil)
+	p, err := chain.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Nil(t, p, "no credentials means anonymous")
+
+	req.Header.Set("Authorization", "Bearer admin-token")
+	p, err = chain.Authenticate(req)
+	require.NoError(t, err)
+	assert.Same(t, admin, p)
+
+	req.Header.Set("Authorization", "Bearer unknown")
+	_, err = chain.Authenticate(req)
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "credentials nobody accepts are rejected")
+}
+
+func TestPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	writeFile(t, path, `{
+		"roles": {
+			"payments": {"sources": ["payments"], "endpoints": ["search"], "max_age": "24h"},
+			"support": {"sources": ["default", "disk"], "endpoints": ["search", "tail"], "to": "2023-06-01T00:00:00Z"},
+			"auditor": {"sources": ["*"], "endpoints": ["search", "status"]}
+		},
+		"users": {"bob": ["support"]}
+	}`)
+
+	policy, err := NewPolicy(path, 0)
+	require.NoError(t, err)
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	policy.now = func() time.Time { return now }
+
+	t.Run("roles from the principal and from users", func(t *testing.T) {
+		access, ok := policy.Access(&Principal{Name: "bob", Roles: []string{"payments"}}, EndpointSearch)
+		require.True(t, ok)
+
+		assert.True(t, access.Allows("payments", now.Add(-time.Hour)))
+		assert.False(t, access.Allows("payments", now.Add(-48*time.Hour)))
+		assert.True(t, access.Allows("disk", time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)))
+		assert.False(t, access.Allows("disk", now))
+		assert.False(t, access.Allows("auth", now))
+	})
+
+	t.Run("endpoints", func(t *testing.T) {
+		access, ok := policy.Access(&Principal{Name: "bob"}, EndpointTail)
+		require.True(t, ok)
+		assert.False(t, access.Allows("payments", now))
+
+		_, ok = policy.Access(&Principal{Name: "bob"}, EndpointAdmin)
+		assert.False(t, ok)
+		_, ok = policy.Access(&Principal{Name: "carol", Roles: []string{"payments"}}, EndpointStatus)
+		assert.False(t, ok)
+		_, ok = policy.Access(nil, EndpointSearch)
+		assert.False(t, ok)
+	})
+
+	t.Run("unrestricted roles", func(t *testing.T) {
+		access, ok := policy.Access(&Principal{Name: "alice", Roles: []string{"auditor"}}, EndpointSearch)
+		assert.True(t, ok)
+		assert.Nil(t, access)
+
+		access, ok = policy.Access(&Principal{Name: "root", Roles: []string{RoleAdmin}}, EndpointAdmin)
+		assert.True(t, ok)
+		assert.Nil(t, access)
+	})
+
+	t.Run("no policy", func(t *testing.T) {
+		var none *Policy
+		_, ok := none.Access(nil, EndpointSearch)
+		assert.True(t, ok)
+		_, ok = none.Access(&Principal{Name: "bob"}, EndpointAdmin)
+		assert.False(t, ok)
+		_, ok = none.Access(&Principal{Name: "root", Roles: []string{RoleAdmin}}, EndpointAdmin)
+		assert.True(t, ok)
+	})
+
+	t.Run("invalid policies", func(t *testing.T) {
+		for _, content := range []string{
+			`{"roles": {"r": {"endpoints": ["search"]}}}`,
+			`{"roles": {"r": {"sources": ["a"], "endpoints": ["delete"]}}}`,
+			`{"roles": {"r": {"sources": ["a"], "max_age": "week"}}}`,
+			`{"users": {"bob": ["missing"]}}`,
+		} {
+			bad := filepath.Join(t.TempDir(), "policy.json")
+			writeFile(t, bad, content)
+			_, err := NewPolicy(bad, 0)
+			assert.Error(t, err, content)
+		}
+	})
+}
+
+func TestClientCert(t *testing.T) {
+	req := httptest.NewRequest("GET", "/logs", nil)
+	p, err := ClientCert{}.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner"}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+	p, err = ClientCert{}.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "ci-runner", Method: "client_cert"}, p)
+
+	leaf.Subject.CommonName = ""
+	leaf.DNSNames = []string{"runner.internal"}
+	p, err = ClientCert{}.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "runner.internal", p.Name)
+}
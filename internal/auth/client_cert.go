@@ -0,0 +1,24 @@
+package auth
+
+import "net/http"
+
+// ClientCert turns a client certificate verified during the TLS handshake
+// into a principal named after its subject common name, or its first DNS
+// name when the common name is empty. Roles come from the policy.
+type ClientCert struct{}
+
+func (ClientCert) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	leaf := r.TLS.VerifiedChains[0][0]
+	name := leaf.Subject.CommonName
+	if name == "" && len(leaf.DNSNames) > 0 {
+		name = leaf.DNSNames[0]
+	}
+	if name == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: name, Method: "client_cert"}, nil
+}
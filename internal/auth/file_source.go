@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileSource keeps the parsed contents of a credentials file and reloads it
+// when the file changes. Changes are noticed on use, at most once per check
+// interval, so no goroutine is needed. A file that fails to parse leaves the
+// previous contents in place.
+type fileSource[T any] struct {
+	path     string
+	parse    func(data []byte) (T, error)
+	interval time.Duration
+
+	mutex     sync.Mutex
+	value     T
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+func newFileSource[T any](path string, interval time.Duration, parse func([]byte) (T, error)) (*fileSource[T], error) {
+	s := &fileSource[T]{path: path, parse: parse, interval: interval}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSource[T]) Get() T {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Since(s.lastCheck) >= s.interval {
+		s.lastCheck = time.Now()
+		if info, err := os.Stat(s.path); err == nil &&
+			(!info.ModTime().Equal(s.modTime) || info.Size() != s.size) {
+			if err := s.load(); err != nil {
+				slog.Error("Failed to reload credentials file, keeping previous version", "path", s.path, "error", err)
+			}
+		}
+	}
+	return s.value
+}
+
+// Reload reads the file right away.
+func (s *fileSource[T]) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastCheck = time.Now()
+	return s.load()
+}
+
+func (s *fileSource[T]) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	value, err := s.parse(data)
+	if err != nil {
+		return err
+	}
+
+	s.value = value
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	slog.Info("Loaded credentials file", "path", s.path)
+	return nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd authenticates HTTP basic auth against an htpasswd file. Only
+// bcrypt ($2y$, $2a$, $2b$) and {SHA} hashes are accepted; bcrypt is the
+// one to use. A line may name the user's roles after a third colon:
+//
+//	user:hash[:role,role...]
+type Htpasswd struct {
+	source *fileSource[map[string]htpasswdUser]
+}
+
+type htpasswdUser struct {
+	hash  string
+	roles []string
+}
+
+func NewHtpasswd(path string, reloadInterval time.Duration) (*Htpasswd, error) {
+	source, err := newFileSource(path, reloadInterval, parseHtpasswd)
+	if err != nil {
+		return nil, err
+	}
+	return &Htpasswd{source: source}, nil
+}
+
+func (h *Htpasswd) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	entry, exists := h.source.Get()[user]
+	if !exists || !checkPassword(entry.hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: user, Method: "basic", Roles: entry.roles}, nil
+}
+
+func (h *Htpasswd) Reload() error {
+	return h.source.Reload()
+}
+
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func parseHtpasswd(data []byte) (map[string]htpasswdUser, error) {
+	users := make(map[string]htpasswdUser)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("line %d: expected \"user:hash[:roles]\"", n)
+		}
+		user, hash := fields[0], fields[1]
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$2") {
+			return nil, fmt.Errorf("line %d: unsupported hash for user %s, use bcrypt", n, user)
+		}
+
+		entry := htpasswdUser{hash: hash}
+		if len(fields) == 3 && fields[2] != "" {
+			entry.roles = strings.Split(fields[2], ",")
+		}
+		users[user] = entry
+	}
+
+	return users, scanner.Err()
+}
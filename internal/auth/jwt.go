@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clockSkew is tolerated when checking exp and nbf.
+const clockSkew = 30 * time.Second
+
+var jwtAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// JWT authenticates HMAC signed bearer tokens. The secret is read from a
+// file so it can be rotated without a restart. The sub claim names the
+// principal and the roles claim, if present, lists its roles.
+type JWT struct {
+	source   *fileSource[[]byte]
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+type jwtClaims struct {
+	Subject   string       `json:"sub"`
+	Issuer    string       `json:"iss"`
+	Audience  audience     `json:"aud"`
+	ExpiresAt *numericDate `json:"exp"`
+	NotBefore *numericDate `json:"nbf"`
+	Roles     []string     `json:"roles"`
+}
+
+// audience accepts both forms of the aud claim: a string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+type numericDate struct {
+	time.Time
+}
+
+func (d *numericDate) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return err
+	}
+	d.Time = time.Unix(0, int64(seconds*float64(time.Second)))
+	return nil
+}
+
+// NewJWT checks the iss and aud claims only when issuer and audience are
+// not empty.
+func NewJWT(secretPath, issuer, audience string, reloadInterval time.Duration) (*JWT, error) {
+	source, err := newFileSource(secretPath, reloadInterval, parseSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &JWT{source: source, issuer: issuer, audience: audience, now: time.Now}, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	claims, err := j.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return &Principal{Name: claims.Subject, Method: "jwt", Roles: claims.Roles}, nil
+}
+
+func (j *JWT) Reload() error {
+	return j.source.Reload()
+}
+
+func (j *JWT) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	// Only HMAC algorithms are accepted; "none" and asymmetric algorithms
+	// would let a token choose how it is verified.
+	newHash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	mac := hmac.New(newHash, j.source.Get())
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("signature mismatch")
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+
+	now := j.now()
+	switch {
+	case claims.Subject == "":
+		return nil, errors.New("missing sub claim")
+	case claims.ExpiresAt == nil:
+		return nil, errors.New("missing exp claim")
+	case now.After(claims.ExpiresAt.Add(clockSkew)):
+		return nil, errors.New("token expired")
+	case claims.NotBefore != nil && now.Add(clockSkew).Before(claims.NotBefore.Time):
+		return nil, errors.New("token not valid yet")
+	case j.issuer != "" && claims.Issuer != j.issuer:
+		return nil, errors.New("unexpected issuer")
+	case j.audience != "" && !slices.Contains(claims.Audience, j.audience):
+		return nil, errors.New("unexpected audience")
+	}
+
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func parseSecret(data []byte) ([]byte, error) {
+	secret := bytes.TrimSpace(data)
+	if len(secret) < 32 {
+		return nil, errors.New("JWT secret must be at least 32 bytes")
+	}
+	return secret, nil
+}
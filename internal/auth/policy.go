@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+// Endpoints a role can be granted.
+const (
+	EndpointSearch = "search"
+	EndpointTail   = "tail"
+	EndpointStatus = "status"
+	EndpointAdmin  = "admin"
+)
+
+var endpoints = []string{EndpointSearch, EndpointTail, EndpointStatus, EndpointAdmin}
+
+// Policy decides which endpoints, sources and time windows a principal may
+// use. It is read from a JSON file:
+//
+//	{
+//	  "roles": {
+//	    "payments": {"sources": ["payments"], "endpoints": ["search"], "max_age": "720h"},
+//	    "support": {"sources": ["default", "disk"], "endpoints": ["search", "tail", "status"]}
+//	  },
+//	  "users": {"bob": ["support"]}
+//	}
+//
+// A principal has the roles it authenticated with plus those listed for its
+// name under users. The source "*" stands for every source. A role named
+// admin that the file does not define grants everything.
+type Policy struct {
+	source *fileSource[*policyFile]
+	now    func() time.Time
+}
+
+type policyFile struct {
+	Roles map[string]*policyRole `json:"roles"`
+	Users map[string][]string    `json:"users"`
+}
+
+type policyRole struct {
+	Sources   []string   `json:"sources"`
+	Endpoints []string   `json:"endpoints"`
+	MaxAge    string     `json:"max_age"`
+	From      *time.Time `json:"from"`
+	To        *time.Time `json:"to"`
+
+	maxAge time.Duration
+}
+
+func NewPolicy(path string, reloadInterval time.Duration) (*Policy, error) {
+	source, err := newFileSource(path, reloadInterval, parsePolicy)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{source: source, now: time.Now}, nil
+}
+
+func (p *Policy) Reload() error {
+	return p.source.Reload()
+}
+
+// Access reports whether principal may use endpoint and which sources it
+// may read there. A nil Policy lets everyone search and only the admin role
+// manage the index; a nil SourceAccess means no restriction.
+func (p *Policy) Access(principal *Principal, endpoint string) (models.SourceAccess, bool) {
+	if p == nil {
+		return nil, endpoint != EndpointAdmin || principal.HasRole(RoleAdmin)
+	}
+	if principal == nil {
+		return nil, false
+	}
+
+	policy := p.source.Get()
+	now := p.now()
+
+	access := models.SourceAccess{}
+	granted := false
+	for _, name := range slices.Concat(principal.Roles, policy.Users[principal.Name]) {
+		role, ok := policy.Roles[name]
+		if !ok {
+			if name == RoleAdmin {
+				return nil, true
+			}
+			continue
+		}
+		if !slices.Contains(role.Endpoints, endpoint) {
+			continue
+		}
+
+		granted = true
+		window := role.window(now)
+		for _, source := range role.Sources {
+			if source == models.AllSources && window == (models.TimeRange{}) {
+				return nil, true
+			}
+			access[source] = append(access[source], window)
+		}
+	}
+	return access, granted
+}
+
+// Roles returns the roles principal authenticated with plus those the
+// policy lists for its name.
+func (p *Policy) Roles(principal *Principal) []string {
+	if principal == nil {
+		return nil
+	}
+	if p == nil {
+		return principal.Roles
+	}
+	return slices.Concat(principal.Roles, p.source.Get().Users[principal.Name])
+}
+
+func (r *policyRole) window(now time.Time) models.TimeRange {
+	var window models.TimeRange
+	if r.From != nil {
+		window.Start = *r.From
+	}
+	if r.To != nil {
+		window.End = *r.To
+	}
+	if r.maxAge > 0 {
+		if start := now.Add(-r.maxAge); start.After(window.Start) {
+			window.Start = start
+		}
+	}
+	return window
+}
+
+func parsePolicy(data []byte) (*policyFile, error) {
+	var policy policyFile
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+
+	for name, role := range policy.Roles {
+		if role == nil || len(role.Sources) == 0 {
+			return nil, fmt.Errorf("role %s: no sources", name)
+		}
+		for _, endpoint := range role.Endpoints {
+			if !slices.Contains(endpoints, endpoint) {
+				return nil, fmt.Errorf("role %s: unknown endpoint %q", name, endpoint)
+			}
+		}
+		if role.MaxAge != "" {
+			maxAge, err := time.ParseDuration(role.MaxAge)
+			if err != nil || maxAge <= 0 {
+				return nil, fmt.Errorf("role %s: invalid max_age %q", name, role.MaxAge)
+			}
+			role.maxAge = maxAge
+		}
+		if role.From != nil && role.To != nil && role.To.Before(*role.From) {
+			return nil, fmt.Errorf("role %s: to is before from", name)
+		}
+	}
+
+	for user, roles := range policy.Users {
+		for _, role := range roles {
+			if _, ok := policy.Roles[role]; !ok && role != RoleAdmin {
+				return nil, fmt.Errorf("user %s: unknown role %q", user, role)
+			}
+		}
+	}
+
+	return &policy, nil
+}
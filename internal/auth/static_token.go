@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const RoleAdmin = "admin"
+
+// StaticToken accepts one fixed bearer token, such as ADMIN_TOKEN, as the
+// given principal. Other bearer tokens are left to the next authenticator.
+type StaticToken struct {
+	token     []byte
+	principal *Principal
+}
+
+func NewStaticToken(token string, principal *Principal) *StaticToken {
+	return &StaticToken{token: []byte(token), principal: principal}
+}
+
+func (s *StaticToken) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+		return nil, nil
+	}
+	return s.principal, nil
+}
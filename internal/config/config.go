@@ -1,62 +1,323 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
 	"os"
+	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Dor1ma/log-finder/pkg/reader"
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	LogDir          string
-	ServerPort      string
-	CacheTTL        time.Duration
-	MaxOpenFiles    int
-	FileCacheTTL    time.Duration
-	RateLimit       int
-	RefreshInterval time.Duration
+	LogDir           string
+	ServerPort       string
+	CacheTTL         time.Duration
+	NegativeTTL      time.Duration
+	CacheMaxEntries  int
+	CacheMaxBytes    int64
+	MaxOpenFiles     int
+	MaxMappedBytes   int64
+	MaxResidentBytes int64
+	FileCacheTTL     time.Duration
+	RateLimit        int
+	RateLimitRoutes  map[string]int
+	RateLimitCosts   map[string]int
+	RateLimitClients int
+	AuthFailureLimit int
+	RefreshInterval  time.Duration
+	WatchDebounce    time.Duration
+	ReaderMode       string
+	// ReaderModes overrides ReaderMode for the sources it names.
+	ReaderModes     map[string]string
+	QueryTimeout    time.Duration
+	MaxRangeResults int
+	SearchWorkers   int
+	QueryWorkers    int
+	LogLevel        string
+	LogFormat       string
+	TraceExport     string
+	AdminToken      string
+
+	AuthAPIKeysFile    string
+	AuthJWTSecretFile  string
+	AuthJWTIssuer      string
+	AuthJWTAudience    string
+	AuthHtpasswdFile   string
+	AuthPolicyFile     string
+	AuthReloadInterval time.Duration
+
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSClientCAFile   string
+	TLSClientAuth     string
+	TLSReloadInterval time.Duration
+
+	RedactRulesFile string
+
+	AuditLogFile     string
+	AuditLogMaxSize  int64
+	AuditLogMaxFiles int
+
+	SavedSearchesFile   string
+	AlertInterval       time.Duration
+	AlertWebhookURL     string
+	AlertRepeatInterval time.Duration
+	AlertTimeout        time.Duration
+	AlertAnomalies      bool
+
+	AnomalyErrorPattern  string
+	AnomalyBaseline      time.Duration
+	AnomalyThreshold     float64
+	AnomalyMinErrorRatio float64
+
+	PatternMax        int
+	PatternSimilarity float64
 }
 
-func Load() *Config {
-	if err := godotenv.Load(); err != nil {
-		log.Println("Warning: No .env file found, using system default variables")
+// Load reads the settings from, in increasing priority, built-in defaults,
+// the YAML file named by CONFIG_FILE, and environment variables, which may
+// come from a .env file. Every invalid value is reported in the returned
+// error, not just the first one.
+func Load() (*Config, error) {
+	// .env is read into its own layer rather than into the environment, so
+	// a reload sees its edits and keys removed from it fall back to the
+	// config file.
+	dotenv, err := godotenv.Read()
+	if err != nil {
+		slog.Warn("No .env file found, using system default variables")
+	}
+	configFile, ok := os.LookupEnv("CONFIG_FILE")
+	if !ok {
+		configFile = dotenv["CONFIG_FILE"]
 	}
 
-	return &Config{
-		LogDir:          getEnv("LOG_DIR", "/tmp/log"),
-		ServerPort:      getEnv("SERVER_PORT", "8080"),
-		CacheTTL:        getEnvAsDuration("CACHE_TTL", 5*time.Minute),
-		MaxOpenFiles:    getEnvAsInt("MAX_OPEN_FILES", 20),
-		FileCacheTTL:    getEnvAsDuration("FILE_CACHE_TTL", 10*time.Minute),
-		RateLimit:       getEnvAsInt("RATE_LIMIT", 100),
-		RefreshInterval: getEnvAsDuration("REFRESH_INERVAL", 60*time.Minute),
+	l, err := newLoader(configFile, dotenv)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+	cfg := &Config{
+		LogDir:           l.string("LOG_DIR", "/tmp/log"),
+		ServerPort:       l.string("SERVER_PORT", "8080"),
+		CacheTTL:         l.duration("CACHE_TTL", 5*time.Minute),
+		NegativeTTL:      l.duration("NEGATIVE_CACHE_TTL", 5*time.Second),
+		CacheMaxEntries:  l.int("CACHE_MAX_ENTRIES", 100000),
+		CacheMaxBytes:    l.bytes("CACHE_MAX_BYTES", 64<<20),
+		MaxOpenFiles:     l.int("MAX_OPEN_FILES", 20),
+		MaxMappedBytes:   l.bytes("MAX_MAPPED_BYTES", 0),
+		MaxResidentBytes: l.bytes("MAX_RESIDENT_BYTES", 0),
+		FileCacheTTL:     l.duration("FILE_CACHE_TTL", 10*time.Minute),
+		RateLimit:        l.int("RATE_LIMIT", 100),
+		RateLimitRoutes:  l.intMap("RATE_LIMIT_ROUTES", nil),
+		RateLimitCosts:   l.intMap("RATE_LIMIT_COSTS", map[string]int{"/logs/range": 5, "/logs/count": 5}),
+		RateLimitClients: l.int("RATE_LIMIT_MAX_CLIENTS", 10000),
+		AuthFailureLimit: l.int("RATE_LIMIT_AUTH_FAILURES", 30),
+		RefreshInterval:  l.duration("REFRESH_INTERVAL", 60*time.Minute),
+		WatchDebounce:    l.duration("WATCH_DEBOUNCE", 50*time.Millisecond),
+		ReaderMode:       l.string("READER_MODE", "auto"),
+		ReaderModes:      l.stringMap("READER_MODE_SOURCES"),
+		QueryTimeout:     l.duration("QUERY_TIMEOUT", 10*time.Second),
+		MaxRangeResults:  l.int("RANGE_MAX_RESULTS", 1000),
+		SearchWorkers:    l.int("SEARCH_WORKERS", runtime.NumCPU()),
+		QueryWorkers:     l.int("QUERY_WORKERS", 4),
+		LogLevel:         l.string("LOG_LEVEL", "info"),
+		LogFormat:        l.string("LOG_FORMAT", "text"),
+		TraceExport:      l.string("TRACE_EXPORT", ""),
+		AdminToken:       l.string("ADMIN_TOKEN", ""),
+
+		AuthAPIKeysFile:    l.string("AUTH_API_KEYS_FILE", ""),
+		AuthJWTSecretFile:  l.string("AUTH_JWT_SECRET_FILE", ""),
+		AuthJWTIssuer:      l.string("AUTH_JWT_ISSUER", ""),
+		AuthJWTAudience:    l.string("AUTH_JWT_AUDIENCE", ""),
+		AuthHtpasswdFile:   l.string("AUTH_HTPASSWD_FILE", ""),
+		AuthPolicyFile:     l.string("AUTH_POLICY_FILE", ""),
+		AuthReloadInterval: l.duration("AUTH_RELOAD_INTERVAL", 5*time.Second),
+
+		TLSCertFile:       l.string("TLS_CERT_FILE", ""),
+		TLSKeyFile:        l.string("TLS_KEY_FILE", ""),
+		TLSClientCAFile:   l.string("TLS_CLIENT_CA_FILE", ""),
+		TLSClientAuth:     l.string("TLS_CLIENT_AUTH", "require"),
+		TLSReloadInterval: l.duration("TLS_RELOAD_INTERVAL", 30*time.Second),
+
+		RedactRulesFile: l.string("REDACT_RULES_FILE", ""),
+
+		AuditLogFile:     l.string("AUDIT_LOG_FILE", ""),
+		AuditLogMaxSize:  l.bytes("AUDIT_LOG_MAX_SIZE", 100<<20),
+		AuditLogMaxFiles: l.int("AUDIT_LOG_MAX_FILES", 10),
+
+		SavedSearchesFile:   l.string("SAVED_SEARCHES_FILE", ""),
+		AlertInterval:       l.duration("ALERT_INTERVAL", time.Minute),
+		AlertWebhookURL:     l.string("ALERT_WEBHOOK_URL", ""),
+		AlertRepeatInterval: l.duration("ALERT_REPEAT_INTERVAL", 0),
+		AlertTimeout:        l.duration("ALERT_TIMEOUT", 10*time.Second),
+		AlertAnomalies:      l.bool("ALERT_ANOMALIES", false),
+
+		AnomalyErrorPattern:  l.string("ANOMALY_ERROR_PATTERN", DefaultErrorPattern),
+		AnomalyBaseline:      l.duration("ANOMALY_BASELINE", time.Hour),
+		AnomalyThreshold:     l.float("ANOMALY_THRESHOLD", 4),
+		AnomalyMinErrorRatio: l.float("ANOMALY_MIN_ERROR_RATIO", 0.05),
+
+		PatternMax:        l.int("PATTERN_MAX", 1000),
+		PatternSimilarity: l.float("PATTERN_SIMILARITY", 0.4),
+	}
+	// An empty pattern would count every line as an error.
+	if cfg.AnomalyErrorPattern == "" {
+		cfg.AnomalyErrorPattern = DefaultErrorPattern
 	}
-	return defaultValue
+
+	errs := append(l.unknownKeys(), l.errs...)
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
 }
 
-func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
-	if value, exists := os.LookupEnv(key); exists {
-		duration, err := time.ParseDuration(value)
-		if err == nil {
-			return duration
+// DefaultErrorPattern counts lines with an error level word, and access
+// log lines with a 5xx status, as errors.
+const DefaultErrorPattern = `(?i)\b(?:error|fatal|panic|critical)\b|" 5\d\d `
+
+func (c *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
 		}
 	}
-	return defaultValue
+
+	check(c.LogDir != "", "LOG_DIR must not be empty")
+	port, err := strconv.Atoi(c.ServerPort)
+	check(err == nil && port > 0 && port < 65536, "SERVER_PORT must be a port number, got %q", c.ServerPort)
+
+	for key, value := range map[string]time.Duration{
+		"CACHE_TTL":             c.CacheTTL,
+		"NEGATIVE_CACHE_TTL":    c.NegativeTTL,
+		"FILE_CACHE_TTL":        c.FileCacheTTL,
+		"WATCH_DEBOUNCE":        c.WatchDebounce,
+		"QUERY_TIMEOUT":         c.QueryTimeout,
+		"AUTH_RELOAD_INTERVAL":  c.AuthReloadInterval,
+		"TLS_RELOAD_INTERVAL":   c.TLSReloadInterval,
+		"ALERT_REPEAT_INTERVAL": c.AlertRepeatInterval,
+	} {
+		check(value >= 0, "%s must not be negative", key)
+	}
+	check(c.RefreshInterval > 0, "REFRESH_INTERVAL must be positive")
+	check(c.AlertInterval > 0, "ALERT_INTERVAL must be positive")
+	check(c.AlertTimeout > 0, "ALERT_TIMEOUT must be positive")
+	check(c.AnomalyBaseline >= 10*time.Minute, "ANOMALY_BASELINE must be at least 10m")
+	check(c.AnomalyThreshold > 0, "ANOMALY_THRESHOLD must be positive")
+	check(c.AnomalyMinErrorRatio >= 0 && c.AnomalyMinErrorRatio <= 1, "ANOMALY_MIN_ERROR_RATIO must be between 0 and 1")
+	check(c.PatternMax >= 0, "PATTERN_MAX must not be negative")
+	check(c.PatternSimilarity > 0 && c.PatternSimilarity <= 1, "PATTERN_SIMILARITY must be above 0 and at most 1")
+	_, err = regexp.Compile(c.AnomalyErrorPattern)
+	check(err == nil, "ANOMALY_ERROR_PATTERN must be a valid regular expression: %v", err)
+	check(!c.AlertAnomalies || c.SavedSearchesFile != "", "ALERT_ANOMALIES requires SAVED_SEARCHES_FILE")
+	check(!c.AlertAnomalies || c.AlertWebhookURL != "", "ALERT_ANOMALIES requires ALERT_WEBHOOK_URL")
+
+	for key, value := range map[string]int64{
+		"CACHE_MAX_ENTRIES":   int64(c.CacheMaxEntries),
+		"CACHE_MAX_BYTES":     c.CacheMaxBytes,
+		"MAX_MAPPED_BYTES":    c.MaxMappedBytes,
+		"MAX_RESIDENT_BYTES":  c.MaxResidentBytes,
+		"RATE_LIMIT":          int64(c.RateLimit),
+		"RANGE_MAX_RESULTS":   int64(c.MaxRangeResults),
+		"AUDIT_LOG_MAX_SIZE":  c.AuditLogMaxSize,
+		"AUDIT_LOG_MAX_FILES": int64(c.AuditLogMaxFiles),
+	} {
+		check(value >= 0, "%s must not be negative", key)
+	}
+	for key, value := range map[string]int{
+		"MAX_OPEN_FILES":         c.MaxOpenFiles,
+		"RATE_LIMIT_MAX_CLIENTS": c.RateLimitClients,
+		"SEARCH_WORKERS":         c.SearchWorkers,
+		"QUERY_WORKERS":          c.QueryWorkers,
+	} {
+		check(value > 0, "%s must be positive", key)
+	}
+	check(c.AuthFailureLimit >= 0, "RATE_LIMIT_AUTH_FAILURES must not be negative")
+	for route, limit := range c.RateLimitRoutes {
+		check(limit >= 0, "RATE_LIMIT_ROUTES: limit of %s must not be negative", route)
+	}
+	for route, cost := range c.RateLimitCosts {
+		check(cost > 0, "RATE_LIMIT_COSTS: cost of %s must be positive", route)
+	}
+
+	_, err = reader.ParseMode(c.ReaderMode)
+	check(err == nil, "READER_MODE must be auto, mmap or pread, got %q", c.ReaderMode)
+	for source, mode := range c.ReaderModes {
+		_, err := reader.ParseMode(mode)
+		check(err == nil, "READER_MODE_SOURCES: mode of %s must be auto, mmap or pread, got %q", source, mode)
+	}
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel)
+	check(c.LogFormat == "text" || c.LogFormat == "json", "LOG_FORMAT must be text or json, got %q", c.LogFormat)
+
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.TLSClientCAFile == "" || c.TLSCertFile != "", "TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	check(c.TLSClientAuth == "require" || c.TLSClientAuth == "optional",
+		"TLS_CLIENT_AUTH must be require or optional, got %q", c.TLSClientAuth)
+
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errs
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value, exists := os.LookupEnv(key); exists {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// RestartRequired lists the settings that differ in next but cannot be
+// applied to a running server. Rate limits, cache sizes and TTLs, the
+// refresh interval, query limits, anomaly thresholds and the log level can.
+func (c *Config) RestartRequired(next *Config) []string {
+	var keys []string
+	check := func(key string, changed bool) {
+		if changed {
+			keys = append(keys, key)
 		}
 	}
-	return defaultValue
+
+	check("LOG_DIR", c.LogDir != next.LogDir)
+	check("SERVER_PORT", c.ServerPort != next.ServerPort)
+	check("MAX_OPEN_FILES", c.MaxOpenFiles != next.MaxOpenFiles)
+	check("MAX_MAPPED_BYTES", c.MaxMappedBytes != next.MaxMappedBytes)
+	check("MAX_RESIDENT_BYTES", c.MaxResidentBytes != next.MaxResidentBytes)
+	check("WATCH_DEBOUNCE", c.WatchDebounce != next.WatchDebounce)
+	check("READER_MODE", c.ReaderMode != next.ReaderMode)
+	check("READER_MODE_SOURCES", !maps.Equal(c.ReaderModes, next.ReaderModes))
+	check("SEARCH_WORKERS", c.SearchWorkers != next.SearchWorkers)
+	check("QUERY_WORKERS", c.QueryWorkers != next.QueryWorkers)
+	check("LOG_FORMAT", c.LogFormat != next.LogFormat)
+	check("TRACE_EXPORT", c.TraceExport != next.TraceExport)
+	check("ADMIN_TOKEN", c.AdminToken != next.AdminToken)
+	check("AUTH_API_KEYS_FILE", c.AuthAPIKeysFile != next.AuthAPIKeysFile)
+	check("AUTH_JWT_SECRET_FILE", c.AuthJWTSecretFile != next.AuthJWTSecretFile)
+	check("AUTH_JWT_ISSUER", c.AuthJWTIssuer != next.AuthJWTIssuer)
+	check("AUTH_JWT_AUDIENCE", c.AuthJWTAudience != next.AuthJWTAudience)
+	check("AUTH_HTPASSWD_FILE", c.AuthHtpasswdFile != next.AuthHtpasswdFile)
+	check("AUTH_POLICY_FILE", c.AuthPolicyFile != next.AuthPolicyFile)
+	check("AUTH_RELOAD_INTERVAL", c.AuthReloadInterval != next.AuthReloadInterval)
+	check("TLS_CERT_FILE", c.TLSCertFile != next.TLSCertFile)
+	check("TLS_KEY_FILE", c.TLSKeyFile != next.TLSKeyFile)
+	check("TLS_CLIENT_CA_FILE", c.TLSClientCAFile != next.TLSClientCAFile)
+	check("TLS_CLIENT_AUTH", c.TLSClientAuth != next.TLSClientAuth)
+	check("TLS_RELOAD_INTERVAL", c.TLSReloadInterval != next.TLSReloadInterval)
+	check("REDACT_RULES_FILE", c.RedactRulesFile != next.RedactRulesFile)
+	check("AUDIT_LOG_FILE", c.AuditLogFile != next.AuditLogFile)
+	check("AUDIT_LOG_MAX_SIZE", c.AuditLogMaxSize != next.AuditLogMaxSize)
+	check("AUDIT_LOG_MAX_FILES", c.AuditLogMaxFiles != next.AuditLogMaxFiles)
+	check("SAVED_SEARCHES_FILE", c.SavedSearchesFile != next.SavedSearchesFile)
+	check("ALERT_INTERVAL", c.AlertInterval != next.AlertInterval)
+	check("ALERT_WEBHOOK_URL", c.AlertWebhookURL != next.AlertWebhookURL)
+	check("ALERT_REPEAT_INTERVAL", c.AlertRepeatInterval != next.AlertRepeatInterval)
+	check("ALERT_TIMEOUT", c.AlertTimeout != next.AlertTimeout)
+	check("ALERT_ANOMALIES", c.AlertAnomalies != next.AlertAnomalies)
+	check("ANOMALY_ERROR_PATTERN", c.AnomalyErrorPattern != next.AnomalyErrorPattern)
+	check("PATTERN_MAX", c.PatternMax != next.PatternMax)
+	check("PATTERN_SIMILARITY", c.PatternSimilarity != next.PatternSimilarity)
+	return keys
 }
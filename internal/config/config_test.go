@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	t.Setenv("CONFIG_FILE", path)
+}
+
+func TestLoadLayersFileAndEnv(t *testing.T) {
+	writeConfig(t, `
+log_dir: /var/log/app
+refresh_interval: 15m
+cache_max_bytes: 32MB
+rate_limit: 50
+rate_limit_routes:
+  /logs/range: 10
+`)
+	t.Setenv("RATE_LIMIT", "75")
+
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, "/var/log/app", cfg.LogDir)
+	assert.Equal(t, 15*time.Minute, cfg.RefreshInterval)
+	assert.Equal(t, int64(32<<20), cfg.CacheMaxBytes)
+	assert.Equal(t, 75, cfg.RateLimit, "environment overrides the file")
+	assert.Equal(t, map[string]int{"/logs/range": 10}, cfg.RateLimitRoutes)
+	assert.Equal(t, "8080", cfg.ServerPort, "defaults fill the rest")
+}
+
+func TestLoadRereadsDotenv(t *testing.T) {
+	writeConfig(t, "rate_limit: 50\n")
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	load := func(dotenv string) *Config {
+		t.Helper()
+		require.NoError(t, os.WriteFile(".env", []byte(dotenv), 0600))
+		cfg, err := Load()
+		require.NoError(t, err)
+		return cfg
+	}
+
+	cfg := load("RATE_LIMIT=60\nQUERY_WORKERS=2\n")
+	assert.Equal(t, 60, cfg.RateLimit, ".env overrides the file")
+	assert.Equal(t, 2, cfg.QueryWorkers)
+
+	cfg = load("RATE_LIMIT=70\n")
+	assert.Equal(t, 70, cfg.RateLimit, "edits are picked up on reload")
+	assert.Equal(t, 4, cfg.QueryWorkers, "removed keys fall back to the default")
+
+	cfg = load("QUERY_WORKERS=3\n")
+	assert.Equal(t, 50, cfg.RateLimit, "removed keys fall back to the file")
+
+	t.Setenv("QUERY_WORKERS", "5")
+	cfg = load("QUERY_WORKERS=3\n")
+	assert.Equal(t, 5, cfg.QueryWorkers, "the environment overrides .env")
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	writeConfig(t, `
+refresh_inerval: 60m
+query_timeout: soon
+`)
+	t.Setenv("REFRESH_INTERVAL", "60")
+	t.Setenv("MAX_OPEN_FILES", "0")
+	t.Setenv("READER_MODE", "fast")
+	t.Setenv("READER_MODE_SOURCES", "nfs=pread,fuse=slow")
+	t.Setenv("RATE_LIMIT_COSTS", "/logs/range")
+	t.Setenv("PATTERN_SIMILARITY", "0")
+	t.Setenv("ALERT_TIMEOUT", "0s")
+
+	_, err := Load()
+	require.Error(t, err)
+
+	for _, message := range []string{
+		`REFRESH_INTERVAL: invalid duration "60"`,
+		`query_timeout in `,
+		`refresh_inerval in `,
+		`unknown setting`,
+		`MAX_OPEN_FILES must be positive`,
+		`READER_MODE must be auto, mmap or pread, got "fast"`,
+		`READER_MODE_SOURCES: mode of fuse must be auto, mmap or pread, got "slow"`,
+		`RATE_LIMIT_COSTS: invalid entry "/logs/range"`,
+		`PATTERN_SIMILARITY must be above 0 and at most 1`,
+		`ALERT_TIMEOUT must be positive`,
+	} {
+		assert.Contains(t, err.Error(), message)
+	}
+}
+
+func TestLoadValidatesTLS(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", "server.crt")
+	t.Setenv("TLS_CLIENT_AUTH", "maybe")
+
+	_, err := Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	assert.Contains(t, err.Error(), "TLS_CLIENT_AUTH must be require or optional")
+}
+
+func TestLoadValidatesAnomalies(t *testing.T) {
+	t.Setenv("ALERT_ANOMALIES", "yes")
+	t.Setenv("ANOMALY_ERROR_PATTERN", "(")
+	t.Setenv("ANOMALY_BASELINE", "5m")
+	t.Setenv("ANOMALY_MIN_ERROR_RATIO", "1.5")
+
+	_, err := Load()
+	require.Error(t, err)
+	for _, message := range []string{
+		`ALERT_ANOMALIES: invalid boolean "yes"`,
+		`ANOMALY_ERROR_PATTERN`,
+		`ANOMALY_BASELINE must be at least 10m`,
+		`ANOMALY_MIN_ERROR_RATIO must be between 0 and 1`,
+	} {
+		assert.Contains(t, err.Error(), message)
+	}
+
+	t.Setenv("ALERT_ANOMALIES", "true")
+	t.Setenv("ANOMALY_ERROR_PATTERN", "ERROR")
+	t.Setenv("ANOMALY_BASELINE", "2h")
+	t.Setenv("ANOMALY_MIN_ERROR_RATIO", "0.1")
+	_, err = Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ALERT_ANOMALIES requires SAVED_SEARCHES_FILE")
+
+	t.Setenv("SAVED_SEARCHES_FILE", "searches.json")
+	t.Setenv("ALERT_WEBHOOK_URL", "http://alerts.example/hook")
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.True(t, cfg.AlertAnomalies)
+	assert.Equal(t, 2*time.Hour, cfg.AnomalyBaseline)
+	assert.Equal(t, 0.1, cfg.AnomalyMinErrorRatio)
+}
+
+func TestRestartRequired(t *testing.T) {
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	next := *cfg
+	next.RateLimit = cfg.RateLimit + 1
+	next.CacheTTL = time.Hour
+	assert.Empty(t, cfg.RestartRequired(&next))
+
+	next.LogDir = "/elsewhere"
+	next.SearchWorkers = cfg.SearchWorkers + 1
+	assert.Equal(t, []string{"LOG_DIR", "SEARCH_WORKERS"}, cfg.RestartRequired(&next))
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loader looks settings up in the environment first, then in .env and then
+// in the config file, whose keys are the environment variable names in lower
+// case. Parse errors are collected instead of falling back to the default.
+type loader struct {
+	path   string
+	dotenv map[string]string
+	file   map[string]string
+	used   map[string]bool
+	errs   []error
+}
+
+func newLoader(path string, dotenv map[string]string) (*loader, error) {
+	l := &loader{path: path, dotenv: dotenv, file: make(map[string]string), used: make(map[string]bool)}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	for key, value := range raw {
+		text, err := scalarString(value)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s in %s: %w", key, path, err))
+			continue
+		}
+		l.file[key] = text
+	}
+	return l, nil
+}
+
+// scalarString turns a YAML value into the text an environment variable
+// would hold. Mappings of numbers become "key=value,key=value" lists.
+func scalarString(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string, int, float64, bool:
+		return fmt.Sprint(v), nil
+	case map[string]any:
+		entries := make([]string, 0, len(v))
+		for key, item := range v {
+			number, ok := item.(int)
+			if !ok {
+				return "", fmt.Errorf("value of %s must be an integer", key)
+			}
+			entries = append(entries, key+"="+strconv.Itoa(number))
+		}
+		slices.Sort(entries)
+		return strings.Join(entries, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// lookup returns the value of key and where it came from.
+func (l *loader) lookup(key string) (string, string, bool) {
+	l.used[strings.ToLower(key)] = true
+	if value, ok := os.LookupEnv(key); ok {
+		return value, key, true
+	}
+	if value, ok := l.dotenv[key]; ok {
+		return value, key + " in .env", true
+	}
+	if value, ok := l.file[strings.ToLower(key)]; ok {
+		return value, strings.ToLower(key) + " in " + l.path, true
+	}
+	return "", "", false
+}
+
+func (l *loader) fail(source, format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf("%s: %s", source, fmt.Sprintf(format, args...)))
+}
+
+// unknownKeys reports config file keys no setting asked for, which are
+// most likely typos.
+func (l *loader) unknownKeys() []error {
+	var errs []error
+	for key := range l.file {
+		if !l.used[key] {
+			errs = append(errs, fmt.Errorf("%s in %s: unknown setting", key, l.path))
+		}
+	}
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errs
+}
+
+func (l *loader) string(key, defaultValue string) string {
+	if value, _, ok := l.lookup(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func (l *loader) duration(key string, defaultValue time.Duration) time.Duration {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		l.fail(source, "invalid duration %q, expected a number with a unit such as 30s or 60m", value)
+		return defaultValue
+	}
+	return duration
+}
+
+func (l *loader) int(key string, defaultValue int) int {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		l.fail(source, "invalid integer %q", value)
+		return defaultValue
+	}
+	return intValue
+}
+
+func (l *loader) float(key string, defaultValue float64) float64 {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		l.fail(source, "invalid number %q", value)
+		return defaultValue
+	}
+	return floatValue
+}
+
+func (l *loader) bool(key string, defaultValue bool) bool {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		l.fail(source, "invalid boolean %q, expected true or false", value)
+		return defaultValue
+	}
+	return boolValue
+}
+
+var byteUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+func (l *loader) bytes(key string, defaultValue int64) int64 {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSuffix(number, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	intValue, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil {
+		l.fail(source, "invalid size %q, expected bytes or a number with KB, MB or GB", value)
+		return defaultValue
+	}
+	return intValue * multiplier
+}
+
+// stringMap parses "key=value,key=value" lists such as per-source modes.
+func (l *loader) stringMap(key string) map[string]string {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	result := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, text, ok := strings.Cut(entry, "=")
+		if !ok {
+			l.fail(source, "invalid entry %q, expected key=value", entry)
+			continue
+		}
+		result[strings.TrimSpace(name)] = strings.TrimSpace(text)
+	}
+	return result
+}
+
+// intMap parses "key=value,key=value" lists such as per-route limits.
+func (l *loader) intMap(key string, defaultValue map[string]int) map[string]int {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+
+	result := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, number, ok := strings.Cut(entry, "=")
+		intValue, err := strconv.Atoi(strings.TrimSpace(number))
+		if !ok || err != nil {
+			l.fail(source, "invalid entry %q, expected key=integer", entry)
+			continue
+		}
+		result[strings.TrimSpace(name)] = intValue
+	}
+	return result
+}
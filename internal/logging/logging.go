@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/Dor1ma/log-finder/internal/tracing"
+)
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+var level slog.LevelVar
+
+// Setup installs the default slog logger. Records logged with a context
+// that carries a request ID get a request_id attribute, and a trace_id when
+// the request is traced, so every line a search emits can be tied back to
+// its request.
+func Setup(w io.Writer, logLevel, format string) error {
+	if err := SetLevel(logLevel); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text", "":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// SetLevel changes the level of the logger installed by Setup.
+func SetLevel(logLevel string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q", logLevel)
+	}
+	level.Set(lvl)
+	return nil
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := tracing.FromContext(ctx); span != nil {
+		record.AddAttrs(slog.String("trace_id", span.Context().TraceID.String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
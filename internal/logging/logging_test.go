@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupAttachesRequestID(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	var buf bytes.Buffer
+	require.NoError(t, Setup(&buf, "info", "json"))
+
+	ctx := WithRequestID(context.Background(), "abc123")
+	slog.InfoContext(ctx, "Skipping file", "path", "/tmp/x.log")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Skipping file", record["msg"])
+	assert.Equal(t, "abc123", record["request_id"])
+	assert.Equal(t, "/tmp/x.log", record["path"])
+}
+
+func TestSetupLevel(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	var buf bytes.Buffer
+	require.NoError(t, Setup(&buf, "warn", "text"))
+
+	slog.Info("hidden")
+	slog.Warn("shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown")
+}
+
+func TestSetupRejectsInvalidValues(t *testing.T) {
+	assert.Error(t, Setup(&bytes.Buffer{}, "loud", "text"))
+	assert.Error(t, Setup(&bytes.Buffer{}, "info", "xml"))
+}
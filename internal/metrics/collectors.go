@@ -0,0 +1,24 @@
+package metrics
+
+var (
+	HTTPRequests = Default.NewCounterVec("logfinder_http_requests_total",
+		"HTTP requests served, by route, method and status.", "route", "method", "status")
+	HTTPDuration = Default.NewHistogramVec("logfinder_http_request_duration_seconds",
+		"HTTP request latency, by route and status.", DefaultBuckets, "route", "status")
+	RateLimited = Default.NewCounterVec("logfinder_rate_limited_total",
+		"Requests rejected by the rate limiter, by route.", "route")
+	AuthFailures = Default.NewCounterVec("logfinder_auth_failures_total",
+		"Requests rejected for missing or invalid credentials, by route.", "route")
+
+	AlertNotifications = Default.NewCounterVec("logfinder_alert_notifications_total",
+		"Alert webhook notifications, by alert status and delivery outcome.", "status", "outcome")
+	AlertEvaluationErrors = Default.NewCounter("logfinder_alert_evaluation_errors_total",
+		"Saved search evaluations that failed.")
+
+	IndexRefreshDuration = Default.NewHistogramVec("logfinder_index_refresh_duration_seconds",
+		"Time spent updating the file index, by kind of refresh.", DefaultBuckets, "kind")
+	IndexRefreshErrors = Default.NewCounter("logfinder_index_refresh_errors_total",
+		"Index refreshes that failed.")
+	IndexSkippedFiles = Default.NewCounter("logfinder_index_skipped_files_total",
+		"Files left out of the index because they could not be read or parsed.")
+)
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+)
+
+type CounterVec struct {
+	header
+	mutex  sync.Mutex
+	values map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		header: header{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]*series),
+	}
+	r.register(c)
+	return c
+}
+
+func (r *Registry) NewCounter(name, help string) *CounterVec {
+	return r.NewCounterVec(name, help)
+}
+
+// Add increases the counter identified by labelValues, given in the order
+// the label names were declared.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := labelKey(labelValues)
+	s, ok := c.values[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.value += delta
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.writeHeader(w)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		s := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labels), formatValue(s.value))
+	}
+}
+
+// funcFamily reports a single value read from elsewhere at scrape time,
+// for numbers other components already keep track of.
+type funcFamily struct {
+	header
+	fn func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcFamily{header: header{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcFamily{header: header{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (f *funcFamily) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.fn()))
+}
+
+// Sample is one value reported by a collector.
+type Sample struct {
+	Name  string
+	Help  string
+	Kind  string
+	Value float64
+}
+
+// collectorFamily reports several values that are cheaper to compute
+// together, such as the fields of one stats snapshot.
+type collectorFamily struct {
+	fn func() []Sample
+}
+
+func (r *Registry) NewCollector(fn func() []Sample) {
+	r.register(&collectorFamily{fn: fn})
+}
+
+func (c *collectorFamily) write(w *bufio.Writer) {
+	for _, s := range c.fn() {
+		header{name: s.Name, help: s.Help, kind: s.Kind}.writeHeader(w)
+		fmt.Fprintf(w, "%s %s\n", s.Name, formatValue(s.Value))
+	}
+}
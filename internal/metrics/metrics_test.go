@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func render(t *testing.T, r *Registry) string {
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+	return buf.String()
+}
+
+func TestCounterVecFormat(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests served.", "route", "status")
+	c.Inc("/logs", "200")
+	c.Add(2, "/logs", "200")
+	c.Inc(`/a"b`, "404")
+
+	out := render(t, r)
+	assert.Contains(t, out, "# HELP requests_total Requests served.\n# TYPE requests_total counter\n")
+	assert.Contains(t, out, `requests_total{route="/logs",status="200"} 3`+"\n")
+	assert.Contains(t, out, `requests_total{route="/a\"b",status="404"} 1`+"\n")
+}
+
+func TestUnlabeledCounterStartsAtZero(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("errors_total", "Errors.")
+
+	assert.Contains(t, render(t, r), "errors_total 0\n")
+}
+
+func TestHistogramFormat(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("duration_seconds", "Durations.", []float64{1, 0.1}, "route")
+	h.Observe(0.05, "/logs")
+	h.Observe(0.5, "/logs")
+	h.Observe(5, "/logs")
+
+	out := render(t, r)
+	assert.Contains(t, out, "# TYPE duration_seconds histogram\n")
+	assert.Contains(t, out, `duration_seconds_bucket{route="/logs",le="0.1"} 1`+"\n")
+	assert.Contains(t, out, `duration_seconds_bucket{route="/logs",le="1"} 2`+"\n")
+	assert.Contains(t, out, `duration_seconds_bucket{route="/logs",le="+Inf"} 3`+"\n")
+	assert.Contains(t, out, `duration_seconds_sum{route="/logs"} 5.55`+"\n")
+	assert.Contains(t, out, `duration_seconds_count{route="/logs"} 3`+"\n")
+}
+
+func TestFuncsAndCollectorsReadAtScrape(t *testing.T) {
+	r := NewRegistry()
+	files := 1
+	r.NewGaugeFunc("index_files", "Indexed files.", func() float64 { return float64(files) })
+	r.NewCollector(func() []Sample {
+		return []Sample{
+			{Name: "cache_hits_total", Help: "Hits.", Kind: "counter", Value: 7},
+			{Name: "cache_entries", Help: "Entries.", Kind: "gauge", Value: 2},
+		}
+	})
+
+	files = 3
+	out := render(t, r)
+	assert.Contains(t, out, "# TYPE index_files gauge\nindex_files 3\n")
+	assert.Contains(t, out, "# TYPE cache_hits_total counter\ncache_hits_total 7\n")
+	assert.Contains(t, out, "# TYPE cache_entries gauge\ncache_entries 2\n")
+}
+
+func TestHandlerContentType(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("up_total", "Up.")
+
+	rec := httptest.NewRecorder()
+	r.Handler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "up_total 0\n")
+}
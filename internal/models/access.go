@@ -0,0 +1,79 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// DefaultSource is the source of files directly in the log directory; files
+// in a first-level subdirectory belong to the source named after it.
+const DefaultSource = "default"
+
+// AllSources grants its windows on every source.
+const AllSources = "*"
+
+// SourceAccess maps each source a caller may read to the time windows it
+// may see there. A zero Start or End leaves that side of a window open.
+// A nil SourceAccess allows everything.
+type SourceAccess map[string][]TimeRange
+
+func (a SourceAccess) windows(source string) []TimeRange {
+	return slices.Concat(a[source], a[AllSources])
+}
+
+func (a SourceAccess) Allows(source string, t time.Time) bool {
+	if a == nil {
+		return true
+	}
+	for _, w := range a.windows(source) {
+		if (w.Start.IsZero() || !t.Before(w.Start)) && (w.End.IsZero() || !t.After(w.End)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Overlaps reports whether any window of source overlaps [from, to].
+func (a SourceAccess) Overlaps(source string, from, to time.Time) bool {
+	if a == nil {
+		return true
+	}
+	for _, w := range a.windows(source) {
+		if (w.Start.IsZero() || !to.Before(w.Start)) && (w.End.IsZero() || !from.After(w.End)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes reports whether the caller may see any part of source.
+func (a SourceAccess) Includes(source string) bool {
+	return a == nil || len(a.windows(source)) > 0
+}
+
+// SourcesAt lists, sorted, the sources whose windows contain t. Two callers
+// with the same list see the same answer for a lookup at t.
+func (a SourceAccess) SourcesAt(t time.Time) []string {
+	var sources []string
+	for source := range a {
+		if a.Allows(source, t) {
+			sources = append(sources, source)
+		}
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+type sourceAccessKey struct{}
+
+func WithSourceAccess(ctx context.Context, access SourceAccess) context.Context {
+	return context.WithValue(ctx, sourceAccessKey{}, access)
+}
+
+// SourceAccessFrom returns the access the request was granted, or nil when
+// it is not restricted.
+func SourceAccessFrom(ctx context.Context) SourceAccess {
+	access, _ := ctx.Value(sourceAccessKey{}).(SourceAccess)
+	return access
+}
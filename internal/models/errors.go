@@ -1,8 +1,19 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrNotFound      = errors.New("log entry not found")
 	ErrInvalidFormat = errors.New("invalid log format")
+	ErrNotReady      = errors.New("index is not built yet")
+	ErrNotSupported  = errors.New("operation is not supported")
+	ErrOutsideLogDir = errors.New("path is outside the log directory")
+	ErrFileSkipped   = errors.New("file skipped")
+	ErrNoSuchPattern = errors.New("unknown pattern")
+	// ErrPastIndexEnd is a miss after the end of the newest file of a
+	// source, which may still be written.
+	ErrPastIndexEnd = fmt.Errorf("%w: past the end of the index", ErrNotFound)
 )
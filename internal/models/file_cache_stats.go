@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+type FileCacheStats struct {
+	Entries          int                   `json:"entries"`
+	MaxOpenFiles     int                   `json:"max_open_files"`
+	CachedBytes      int64                 `json:"cached_bytes"`
+	MappedBytes      int64                 `json:"mapped_bytes"`
+	ResidentBytes    int64                 `json:"resident_bytes"`
+	MaxMappedBytes   int64                 `json:"max_mapped_bytes"`
+	MaxResidentBytes int64                 `json:"max_resident_bytes"`
+	Hits             uint64                `json:"hits"`
+	Misses           uint64                `json:"misses"`
+	Files            []FileCacheEntryStats `json:"files"`
+}
+
+type FileCacheEntryStats struct {
+	Path          string    `json:"path"`
+	Source        string    `json:"source"`
+	Size          int64     `json:"size"`
+	ResidentBytes int64     `json:"resident_bytes"`
+	Leases        int       `json:"leases"`
+	Hits          int64     `json:"hits"`
+	Reader        string    `json:"reader"`
+	Access        string    `json:"access"`
+	LastAccess    time.Time `json:"last_access"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+type FileCacheStatsProvider interface {
+	FileCacheStats() FileCacheStats
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r TimeRange) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && !t.After(r.End)
+}
+
+// IndexChange describes one update of the file index: the time ranges whose
+// answers may differ from what was returned before it.
+type IndexChange struct {
+	Generation uint64
+	Changed    []TimeRange
+}
+
+type IndexNotifier interface {
+	SubscribeIndexChanges(fn func(IndexChange))
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+type IndexStatus struct {
+	Ready            bool          `json:"ready"`
+	LogDir           string        `json:"log_dir"`
+	LastRefresh      time.Time     `json:"last_refresh"`
+	RefreshInterval  string        `json:"refresh_interval"`
+	LastRefreshError string        `json:"last_refresh_error,omitempty"`
+	WatcherActive    bool          `json:"watcher_active"`
+	Files            []IndexedFile `json:"files"`
+	Skipped          []SkippedFile `json:"skipped"`
+}
+
+type IndexedFile struct {
+	Path   string    `json:"path"`
+	Source string    `json:"source"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Size   int64     `json:"size"`
+	Lines  int64     `json:"lines"`
+}
+
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+type IndexStatusProvider interface {
+	IndexStatus() IndexStatus
+}
+
+// IndexAdmin is implemented by repositories whose index and file cache can
+// be managed at runtime. Paths may be absolute or relative to the log
+// directory.
+type IndexAdmin interface {
+	ReindexFile(ctx context.Context, path string) (IndexedFile, error)
+	EvictFile(ctx context.Context, path string) (bool, error)
+	SetRefreshInterval(ctx context.Context, interval time.Duration) error
+}
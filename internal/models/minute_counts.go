@@ -0,0 +1,28 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// MinuteCount is the number of lines logged in one minute and how many of
+// them matched the error pattern.
+type MinuteCount struct {
+	Lines  int `json:"lines"`
+	Errors int `json:"errors"`
+}
+
+// MinuteSeries holds the per-minute counts of each source, keyed by the
+// start of the minute. End is the time of the newest indexed line, so
+// minutes after a source's last line can be told from minutes not yet
+// written.
+type MinuteSeries struct {
+	Sources map[string]map[time.Time]MinuteCount
+	End     time.Time
+}
+
+// MinuteCounter is implemented by repositories that count lines per minute
+// while indexing.
+type MinuteCounter interface {
+	MinuteCounts(ctx context.Context, from, to time.Time) (MinuteSeries, error)
+}
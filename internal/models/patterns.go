@@ -0,0 +1,21 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// PatternCounts holds the lines of each message pattern per minute.
+// FirstSeen is the first minute each pattern was logged in, which may be
+// before the requested range.
+type PatternCounts struct {
+	Templates map[int]string
+	Minutes   map[int]map[time.Time]int
+	FirstSeen map[int]time.Time
+}
+
+// PatternCounter is implemented by repositories that group lines into
+// message patterns while indexing.
+type PatternCounter interface {
+	PatternCounts(ctx context.Context, from, to time.Time) (PatternCounts, error)
+}
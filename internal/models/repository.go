@@ -2,10 +2,60 @@ package models
 
 import (
 	"context"
+	"regexp"
 	"time"
 )
 
 type LogRepository interface {
-	FindByTimestamp(ctx context.Context, timestamp time.Time) (string, error)
-	RefreshMetadata() error
+	FindByTimestamp(ctx context.Context, timestamp time.Time) (line, source string, err error)
+	FindRange(ctx context.Context, query RangeQuery) (RangeResult, error)
+	CountRange(ctx context.Context, query RangeQuery) (CountResult, error)
+	RefreshMetadata(ctx context.Context) error
+}
+
+// RangeQuery selects the lines logged in [From, To]. Pattern, when set,
+// keeps only matching lines, and PatternID only lines of that mined message
+// pattern. Limit caps the number of returned lines and Interval sets the
+// bucket width of counts; both are ignored when zero. Redact, when set, is
+// applied to lines before Pattern, so callers only match what they are
+// shown.
+type RangeQuery struct {
+	From      time.Time
+	To        time.Time
+	Pattern   *regexp.Regexp
+	PatternID int
+	Limit     int
+	Interval  time.Duration
+	Redact    func(line string) string
+}
+
+func (q RangeQuery) Matches(line string) bool {
+	if q.Pattern == nil {
+		return true
+	}
+	if q.Redact != nil {
+		line = q.Redact(line)
+	}
+	return q.Pattern.MatchString(line)
+}
+
+// RangeResult holds the lines found in a time range. Truncated is set when
+// the search stopped early, at the result limit or the query deadline.
+// Sources lists the sources whose files were read.
+type RangeResult struct {
+	Lines     []string
+	Truncated bool
+	Sources   []string
+}
+
+type CountBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+type CountResult struct {
+	Total     int           `json:"total"`
+	Buckets   []CountBucket `json:"buckets,omitempty"`
+	Truncated bool          `json:"truncated"`
+	Sources   []string      `json:"-"`
 }
@@ -0,0 +1,15 @@
+package models
+
+type ResultCacheStats struct {
+	Entries       int    `json:"entries"`
+	Bytes         int64  `json:"bytes"`
+	MaxEntries    int    `json:"max_entries"`
+	MaxBytes      int64  `json:"max_bytes"`
+	Hits          uint64 `json:"hits"`
+	NegativeHits  uint64 `json:"negative_hits"`
+	Misses        uint64 `json:"misses"`
+	Coalesced     uint64 `json:"coalesced"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Generation    uint64 `json:"index_generation"`
+}
@@ -0,0 +1,210 @@
+package patterns
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+type Options struct {
+	// MaxPatterns caps the number of patterns kept. Once it is reached,
+	// lines unlike every known pattern are counted as Unclassified until
+	// Retain frees slots.
+	MaxPatterns int
+	// Similarity is the least share of tokens a line must have in common
+	// with a pattern to join it.
+	Similarity float64
+}
+
+// Unclassified is the ID of lines that fit no pattern after MaxPatterns
+// was reached.
+const Unclassified = 0
+
+const (
+	// Wildcard stands for tokens that differ between lines of a pattern.
+	Wildcard = "<*>"
+	// prefixTokens leading tokens route a line to the patterns it is
+	// compared with. Drain routes on two, which splits messages whose
+	// second word is a name, as in "user alice logged in".
+	prefixTokens = 1
+	// maxChildren caps the distinct tokens routed on at each level; further
+	// ones share the wildcard branch.
+	maxChildren = 100
+)
+
+type pattern struct {
+	id     int
+	source string
+	tokens []string
+	leaf   *node
+}
+
+// similarity is the share of tokens of the line equal to those of p.
+func (p *pattern) similarity(tokens []string) float64 {
+	same := 0
+	for i, token := range p.tokens {
+		if token == tokens[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens))
+}
+
+func (p *pattern) merge(tokens []string) {
+	for i, token := range p.tokens {
+		if token != tokens[i] {
+			p.tokens[i] = Wildcard
+		}
+	}
+}
+
+type node struct {
+	children map[string]*node
+	patterns []*pattern
+}
+
+func (n *node) child(token string) *node {
+	if next, ok := n.children[token]; ok {
+		return next
+	}
+	if len(n.children) >= maxChildren {
+		token = Wildcard
+		if next, ok := n.children[token]; ok {
+			return next
+		}
+	}
+	if n.children == nil {
+		n.children = make(map[string]*node)
+	}
+	next := &node{}
+	n.children[token] = next
+	return next
+}
+
+// Miner groups log messages into patterns the way Drain does: messages
+// are compared only with patterns of the same source, length and leading
+// tokens, join the most similar one and turn the tokens it differs in into
+// wildcards. Templates thus only hold words of their own source. Pattern
+// IDs are assigned in order of discovery and never reused.
+type Miner struct {
+	opts     Options
+	mutex    sync.RWMutex
+	trees    map[string]map[int]*node
+	patterns map[int]*pattern
+	lastID   int
+}
+
+func NewMiner(opts Options) *Miner {
+	return &Miner{opts: opts, trees: make(map[string]map[int]*node), patterns: make(map[int]*pattern)}
+}
+
+// Add assigns message of source to a pattern, creating one when none is
+// similar enough, and returns its ID.
+func (m *Miner) Add(source, message string) int {
+	tokens := Tokenize(message)
+	if len(tokens) == 0 {
+		return Unclassified
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	byLength := m.trees[source]
+	if byLength == nil {
+		byLength = make(map[int]*node)
+		m.trees[source] = byLength
+	}
+	leaf := byLength[len(tokens)]
+	if leaf == nil {
+		leaf = &node{}
+		byLength[len(tokens)] = leaf
+	}
+	for _, token := range tokens[:min(prefixTokens, len(tokens))] {
+		if hasDigit(token) {
+			token = Wildcard
+		}
+		leaf = leaf.child(token)
+	}
+
+	var best *pattern
+	bestSimilarity := 0.0
+	for _, p := range leaf.patterns {
+		if similarity := p.similarity(tokens); similarity > bestSimilarity {
+			best, bestSimilarity = p, similarity
+		}
+	}
+	if best != nil && bestSimilarity >= m.opts.Similarity {
+		best.merge(tokens)
+		return best.id
+	}
+
+	if len(m.patterns) >= m.opts.MaxPatterns {
+		return Unclassified
+	}
+	m.lastID++
+	p := &pattern{id: m.lastID, source: source, tokens: tokens, leaf: leaf}
+	m.patterns[p.id] = p
+	leaf.patterns = append(leaf.patterns, p)
+	return p.id
+}
+
+// Retain drops the patterns for which keep returns false, freeing their
+// slots for new ones, and returns how many it dropped.
+func (m *Miner) Retain(keep func(id int) bool) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	dropped := 0
+	for id, p := range m.patterns {
+		if keep(id) {
+			continue
+		}
+		delete(m.patterns, id)
+		p.leaf.patterns = slices.DeleteFunc(p.leaf.patterns, func(other *pattern) bool { return other == p })
+		dropped++
+	}
+	return dropped
+}
+
+// Template returns the current template of pattern id.
+func (m *Miner) Template(id int) (string, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	p, ok := m.patterns[id]
+	if !ok {
+		return "", false
+	}
+	return strings.Join(p.tokens, " "), true
+}
+
+// Fits reports whether message of source matches the template of pattern
+// id, which every message added to it does.
+func (m *Miner) Fits(id int, source, message string) bool {
+	tokens := Tokenize(message)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	p, ok := m.patterns[id]
+	if !ok || p.source != source {
+		return false
+	}
+	template := p.tokens
+	if len(template) != len(tokens) {
+		return false
+	}
+	for i, token := range template {
+		if token != Wildcard && token != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of patterns kept.
+func (m *Miner) Len() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.patterns)
+}
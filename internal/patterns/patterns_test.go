@@ -0,0 +1,111 @@
+package patterns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t,
+		[]string{"nginx", "<ip>", `"GET`, "/api/users", `HTTP/1.1"`, "<num>", "<num>"},
+		Tokenize(`nginx 10.0.0.1 "GET /api/users HTTP/1.1" 200 512`))
+	assert.Equal(t,
+		[]string{"user=<num>", "(<ip>),", "req=<uuid>", "<hex>", "addr=<ip>", "took", "<num>", "v2"},
+		Tokenize("user=42 (10.0.0.1:8080), req=123e4567-e89b-12d3-a456-426614174000 0xdeadbeef addr=::1 took 3.5 v2"))
+	assert.Equal(t, []string{"checksum", "<hex>", "deadbeef"}, Tokenize("checksum 9f86d081884c7d65 deadbeef"))
+}
+
+func TestMiner(t *testing.T) {
+	miner := NewMiner(Options{MaxPatterns: 10, Similarity: 0.4})
+
+	get := miner.Add("app", `nginx 10.0.0.1 "GET /api/users HTTP/1.1" 200 512`)
+	assert.Equal(t, get, miner.Add("app", `nginx 10.0.0.2 "GET /api/orders HTTP/1.1" 404 17`))
+	post := miner.Add("app", `nginx 10.0.0.2 "POST /api/orders HTTP/1.1" 201 17`)
+	assert.Equal(t, get, post, "lines that mostly agree share a pattern")
+
+	template, ok := miner.Template(get)
+	require.True(t, ok)
+	assert.Equal(t, `nginx <ip> <*> <*> HTTP/1.1" <num> <num>`, template)
+
+	login := miner.Add("app", "user alice logged in")
+	assert.NotEqual(t, get, login)
+	assert.Equal(t, login, miner.Add("app", "user bob logged in"))
+	assert.NotEqual(t, login, miner.Add("app", "user bob logged out after 5 minutes"), "lines of other lengths never share a pattern")
+	assert.NotEqual(t, login, miner.Add("app", "disk full on sda1"))
+
+	assert.True(t, miner.Fits(login, "app", "user carol logged in"))
+	assert.False(t, miner.Fits(login, "app", "user carol logged out"))
+	assert.False(t, miner.Fits(99, "app", "user carol logged in"))
+	assert.Equal(t, 4, miner.Len())
+
+	_, ok = miner.Template(Unclassified)
+	assert.False(t, ok)
+	assert.Equal(t, Unclassified, miner.Add("app", ""))
+}
+
+func TestMinerLimit(t *testing.T) {
+	miner := NewMiner(Options{MaxPatterns: 1, Similarity: 0.5})
+	first := miner.Add("app", "connection reset by peer")
+	assert.Equal(t, Unclassified, miner.Add("app", "cache warmed up"))
+	assert.Equal(t, first, miner.Add("app", "connection refused by peer"), "known patterns still grow")
+	assert.Equal(t, 1, miner.Len())
+
+	assert.Equal(t, 1, miner.Retain(func(id int) bool { return false }))
+	_, ok := miner.Template(first)
+	assert.False(t, ok, "dropped patterns are gone")
+	assert.False(t, miner.Fits(first, "app", "connection reset by peer"))
+
+	warmed := miner.Add("app", "cache warmed up")
+	assert.NotEqual(t, Unclassified, warmed, "dropping a pattern frees its slot")
+	assert.NotEqual(t, first, warmed, "IDs are not reused")
+	assert.Equal(t, 0, miner.Retain(func(id int) bool { return id == warmed }))
+	assert.Equal(t, warmed, miner.Add("app", "cache warmed down"))
+}
+
+func TestMinerSources(t *testing.T) {
+	miner := NewMiner(Options{MaxPatterns: 10, Similarity: 0.4})
+	app := miner.Add("app", "user alice logged in")
+	payments := miner.Add("payments", "user bob logged in")
+	assert.NotEqual(t, app, payments, "sources never share a pattern")
+
+	template, _ := miner.Template(app)
+	assert.Equal(t, "user alice logged in", template, "templates hold only words of their source")
+	assert.Equal(t, app, miner.Add("app", "user carol logged in"))
+	assert.False(t, miner.Fits(app, "payments", "user carol logged in"))
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2024, 6, 10, 13, 0, 0, 0, time.UTC)
+	minute := func(i int) time.Time { return start.Add(time.Duration(i) * time.Minute) }
+
+	counts := models.PatternCounts{
+		Templates: map[int]string{1: "user <*> logged in", 2: "disk full on <*>"},
+		Minutes: map[int]map[time.Time]int{
+			1: {minute(0): 5, minute(4): 5, minute(6): 2},
+			2: {minute(7): 20},
+		},
+		FirstSeen: map[int]time.Time{1: minute(-60), 2: minute(7)},
+	}
+
+	report := Summarize(counts, start.Add(30*time.Second), minute(9), 5*time.Minute, false)
+	assert.Equal(t, start, report.From)
+	assert.Equal(t, "5m0s", report.Window)
+	assert.Equal(t, []Pattern{
+		{ID: 2, Template: "disk full on <*>", Count: 20, FirstSeen: minute(7), New: true,
+			Windows: []models.CountBucket{{Start: minute(5), Count: 20}}},
+		{ID: 1, Template: "user <*> logged in", Count: 12, FirstSeen: minute(-60),
+			Windows: []models.CountBucket{{Start: minute(0), Count: 10}, {Start: minute(5), Count: 2}}},
+	}, report.Patterns)
+
+	report = Summarize(counts, start, minute(9), 0, true)
+	require.Len(t, report.Patterns, 1)
+	assert.Equal(t, 2, report.Patterns[0].ID)
+	assert.Empty(t, report.Patterns[0].Windows)
+
+	report = Summarize(models.PatternCounts{}, start, minute(9), 0, false)
+	assert.NotNil(t, report.Patterns)
+}
@@ -0,0 +1,69 @@
+package patterns
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+// Pattern is one message pattern seen in a report's range. New is set when
+// it was first logged within the range. Windows holds the lines per window
+// that had any.
+type Pattern struct {
+	ID        int                  `json:"id"`
+	Template  string               `json:"template"`
+	Count     int                  `json:"count"`
+	FirstSeen time.Time            `json:"first_seen"`
+	New       bool                 `json:"new"`
+	Windows   []models.CountBucket `json:"windows,omitempty"`
+}
+
+// Report holds the patterns of the lines logged in the minutes from From
+// to To, the most frequent first.
+type Report struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Window   string    `json:"window,omitempty"`
+	Patterns []Pattern `json:"patterns"`
+}
+
+// Summarize totals counts per pattern and, when window is set, per window
+// starting at from. With onlyNew, patterns logged before from are left out.
+func Summarize(counts models.PatternCounts, from, to time.Time, window time.Duration, onlyNew bool) Report {
+	from = from.Truncate(time.Minute)
+	report := Report{From: from, To: to, Patterns: []Pattern{}}
+	if window > 0 {
+		report.Window = window.String()
+	}
+
+	for id, minutes := range counts.Minutes {
+		p := Pattern{ID: id, Template: counts.Templates[id], FirstSeen: counts.FirstSeen[id]}
+		p.New = !p.FirstSeen.Before(from)
+		if onlyNew && !p.New {
+			continue
+		}
+
+		windows := make(map[time.Time]int)
+		for minute, count := range minutes {
+			p.Count += count
+			if window > 0 {
+				windows[from.Add(minute.Sub(from)/window*window)] += count
+			}
+		}
+		for start, count := range windows {
+			p.Windows = append(p.Windows, models.CountBucket{Start: start, Count: count})
+		}
+		slices.SortFunc(p.Windows, func(a, b models.CountBucket) int { return a.Start.Compare(b.Start) })
+		report.Patterns = append(report.Patterns, p)
+	}
+
+	slices.SortFunc(report.Patterns, func(a, b Pattern) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return report
+}
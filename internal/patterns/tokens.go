@@ -0,0 +1,143 @@
+package patterns
+
+import (
+	"net"
+	"strings"
+)
+
+// Placeholders for tokens that are variable whatever the pattern.
+const (
+	IP     = "<ip>"
+	UUID   = "<uuid>"
+	Number = "<num>"
+	Hex    = "<hex>"
+)
+
+// trimmed are the characters around a token kept apart from the value it
+// holds, as in "(10.0.0.1)," or `"GET`.
+const trimmed = `"'()[]{}<>,;`
+
+// Tokenize splits message at whitespace and replaces IP addresses, UUIDs,
+// numbers and long hex strings with placeholders. The value of a key=value
+// token is replaced the same way.
+func Tokenize(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		tokens[i] = mask(token)
+	}
+	return tokens
+}
+
+func mask(token string) string {
+	start := strings.IndexFunc(token, func(r rune) bool { return !strings.ContainsRune(trimmed, r) })
+	if start < 0 {
+		return token
+	}
+	end := strings.LastIndexFunc(token, func(r rune) bool { return !strings.ContainsRune(trimmed, r) }) + 1
+	value := token[start:end]
+	if key, v, ok := strings.Cut(value, "="); ok && v != "" {
+		start += len(key) + 1
+		value = v
+	}
+
+	if placeholder := classify(value); placeholder != "" {
+		return token[:start] + placeholder + token[end:]
+	}
+	return token
+}
+
+func classify(value string) string {
+	switch {
+	case !hasDigit(value):
+		return ""
+	case isNumber(value):
+		return Number
+	case isIPv4(value) || isIPv6(value):
+		return IP
+	case isUUID(value):
+		return UUID
+	case isHex(value):
+		return Hex
+	}
+	return ""
+}
+
+func hasDigit(s string) bool {
+	return strings.ContainsAny(s, "0123456789")
+}
+
+// isNumber accepts integers and decimals with an optional sign.
+func isNumber(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	integer, fraction, _ := strings.Cut(s, ".")
+	return integer != "" && allDigits(integer) && allDigits(fraction)
+}
+
+// isIPv4 accepts an address with an optional port.
+func isIPv4(s string) bool {
+	host, port, ok := strings.Cut(s, ":")
+	if ok && (port == "" || !allDigits(port)) {
+		return false
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) != 4 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || len(part) > 3 || !allDigits(part) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIPv6(s string) bool {
+	return strings.Count(s, ":") >= 2 && net.ParseIP(s) != nil
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if r != '-' {
+				return false
+			}
+		} else if !isHexDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isHex accepts 0x-prefixed values and bare hex strings long enough to be
+// ids or hashes rather than words.
+func isHex(s string) bool {
+	digits, prefixed := strings.CutPrefix(s, "0x")
+	if !prefixed && len(digits) < 8 {
+		return false
+	}
+	if digits == "" {
+		return false
+	}
+	for _, r := range digits {
+		if !isHexDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexDigit(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
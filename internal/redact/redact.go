@@ -0,0 +1,83 @@
+package redact
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Set is the rules that apply to one caller.
+type Set struct {
+	rules   []*Rule
+	hashKey []byte
+}
+
+// Line applies every rule of s to line in turn. A nil Set returns line as
+// it is.
+func (s *Set) Line(line string) string {
+	if s == nil {
+		return line
+	}
+	for _, rule := range s.rules {
+		for _, m := range rule.matchers {
+			line = s.apply(rule, m, line)
+		}
+	}
+	return line
+}
+
+func (s *Set) apply(rule *Rule, m matcher, line string) string {
+	matches := m.re.FindAllStringSubmatchIndex(line, -1)
+	if matches == nil {
+		return line
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		// The value is the first group that took part in the match, or the
+		// whole match for expressions without groups.
+		start, end := match[0], match[1]
+		for i := 2; i < len(match); i += 2 {
+			if match[i] >= 0 {
+				start, end = match[i], match[i+1]
+				break
+			}
+		}
+
+		value := line[start:end]
+		if value == "" || (m.valid != nil && !m.valid(value)) {
+			continue
+		}
+
+		b.WriteString(line[last:start])
+		b.WriteString(s.replace(rule, value))
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+func (s *Set) replace(rule *Rule, value string) string {
+	if rule.Action != "hash" {
+		return rule.Replacement
+	}
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write([]byte(value))
+	return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+type setKey struct{}
+
+func WithSet(ctx context.Context, s *Set) context.Context {
+	return context.WithValue(ctx, setKey{}, s)
+}
+
+// FromContext returns the rules for the request, or nil when its lines are
+// served raw.
+func FromContext(ctx context.Context) *Set {
+	s, _ := ctx.Value(setKey{}).(*Set)
+	return s
+}
@@ -0,0 +1,125 @@
+package redact
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `{
+	"hash_key": "secret",
+	"rules": [
+		{"name": "ip", "match": "ip", "action": "hash"},
+		{"name": "email", "match": "email"},
+		{"name": "token", "match": "token", "replacement": "***"},
+		{"name": "user", "field": "user"},
+		{"name": "card", "pattern": "card=(\\d{12,19})"}
+	],
+	"roles": {"security": [], "support": ["email", "token"], "oncall": ["token", "card"]}
+}`
+
+func loadRules(t *testing.T, rules string) *Redactor {
+	path := filepath.Join(t.TempDir(), "redact.json")
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0600))
+	r, err := Load(path)
+	require.NoError(t, err)
+	return r
+}
+
+func TestSetLine(t *testing.T) {
+	set := loadRules(t, testRules).For(nil)
+	require.NotNil(t, set)
+
+	line := `2024-06-10T13:41:12.100 127.0.0.1 - GET /login user=alice email=alice@example.com ` +
+		`Authorization: Bearer abcdefgh12345678 card=4111111111111111 from fe80::1`
+	redacted := set.Line(line)
+
+	assert.True(t, strings.HasPrefix(redacted, "2024-06-10T13:41:12.100 hash:"), redacted)
+	for _, value := range []string{"127.0.0.1", "alice", "example.com", "abcdefgh12345678", "4111111111111111", "fe80::1"} {
+		assert.NotContains(t, redacted, value)
+	}
+	assert.Contains(t, redacted, "user=[REDACTED]")
+	assert.Contains(t, redacted, "email=[REDACTED]")
+	assert.Contains(t, redacted, "Bearer ***")
+	assert.Contains(t, redacted, "card=[REDACTED]")
+
+	// Hashes are stable, so records of one client can still be correlated.
+	assert.Equal(t, redacted, set.Line(line))
+	assert.Equal(t, set.Line("ip 10.0.0.1"), set.Line("ip 10.0.0.1"))
+	assert.NotEqual(t, set.Line("ip 10.0.0.1"), set.Line("ip 10.0.0.2"))
+
+	assert.Equal(t, `{"user": "[REDACTED]", "id": 1}`, set.Line(`{"user": "Alice Smith", "id": 1}`))
+	assert.Equal(t, "no personal data here", set.Line("no personal data here"))
+
+	var nilSet *Set
+	assert.Equal(t, line, nilSet.Line(line))
+}
+
+func TestRedactorForRoles(t *testing.T) {
+	r := loadRules(t, testRules)
+	line := "10.0.0.1 bob@example.com token=abcdefgh12345678 card=4111111111111111"
+
+	assert.Nil(t, r.For([]string{"security"}))
+	assert.Nil(t, r.For([]string{"support", "security"}))
+
+	support := r.For([]string{"support", "viewer"}).Line(line)
+	assert.Contains(t, support, "10.0.0.1")
+	assert.NotContains(t, support, "bob@example.com")
+	assert.NotContains(t, support, "abcdefgh12345678")
+	assert.Contains(t, support, "4111111111111111")
+
+	// With several listed roles only the rules they share apply.
+	both := r.For([]string{"support", "oncall"}).Line(line)
+	assert.Contains(t, both, "bob@example.com")
+	assert.NotContains(t, both, "abcdefgh12345678")
+	assert.Contains(t, both, "4111111111111111")
+
+	anonymous := r.For(nil).Line(line)
+	assert.NotContains(t, anonymous, "10.0.0.1")
+
+	var nilRedactor *Redactor
+	assert.Nil(t, nilRedactor.For(nil))
+}
+
+func TestRedactorReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redact.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"name": "email", "match": "email"}]}`), 0600))
+	r, err := Load(path)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"name": "ip", "match": "ipv4"}]}`), 0600))
+	require.NoError(t, r.Reload())
+	assert.Equal(t, "[REDACTED] a@b.io", r.For(nil).Line("10.0.0.1 a@b.io"))
+
+	// A broken file keeps the rules in use.
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"name": "ip", "match": "phone"}]}`), 0600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, "[REDACTED] a@b.io", r.For(nil).Line("10.0.0.1 a@b.io"))
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for name, rules := range map[string]string{
+		"no name":      `{"rules": [{"match": "ip"}]}`,
+		"duplicate":    `{"rules": [{"name": "a", "match": "ip"}, {"name": "a", "match": "email"}]}`,
+		"no matcher":   `{"rules": [{"name": "a"}]}`,
+		"two matchers": `{"rules": [{"name": "a", "match": "ip", "field": "user"}]}`,
+		"bad action":   `{"rules": [{"name": "a", "match": "ip", "action": "drop"}]}`,
+		"bad pattern":  `{"rules": [{"name": "a", "pattern": "("}]}`,
+		"hash no key":  `{"rules": [{"name": "a", "match": "ip", "action": "hash"}]}`,
+		"unknown rule": `{"rules": [{"name": "a", "match": "ip"}], "roles": {"x": ["b"]}}`,
+	} {
+		_, err := parseRules([]byte(rules))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	assert.Nil(t, FromContext(context.Background()))
+	set := &Set{}
+	assert.Same(t, set, FromContext(WithSet(context.Background(), set)))
+}
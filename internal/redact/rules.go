@@ -0,0 +1,216 @@
+package redact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"sync/atomic"
+)
+
+// Redactor holds the redaction rules read from a JSON file:
+//
+//	{
+//	  "hash_key": "correlation secret",
+//	  "rules": [
+//	    {"name": "ip", "match": "ip", "action": "hash"},
+//	    {"name": "email", "match": "email"},
+//	    {"name": "user", "field": "user", "replacement": "***"},
+//	    {"name": "card", "pattern": "card=(\\d{12,19})"}
+//	  ],
+//	  "roles": {"security": [], "support": ["email"]}
+//	}
+//
+// A rule finds values by a built-in matcher (ip, email, token), a regular
+// expression whose first group, if any, is the value, or a key=value /
+// "key": "value" field. The value is masked, or with action hash replaced
+// by a keyed hash so equal values stay recognisable. Every rule applies
+// unless the caller has a role listed under roles, which names the only
+// rules that apply to it; an empty list shows raw lines.
+type Redactor struct {
+	path  string
+	rules atomic.Pointer[ruleFile]
+}
+
+type ruleFile struct {
+	HashKey string              `json:"hash_key"`
+	Rules   []*Rule             `json:"rules"`
+	Roles   map[string][]string `json:"roles"`
+}
+
+type Rule struct {
+	Name        string `json:"name"`
+	Match       string `json:"match"`
+	Pattern     string `json:"pattern"`
+	Field       string `json:"field"`
+	Action      string `json:"action"`
+	Replacement string `json:"replacement"`
+
+	matchers []matcher
+}
+
+// matcher finds values in a line. When valid is set, candidates it rejects
+// are left alone.
+type matcher struct {
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+const defaultReplacement = "[REDACTED]"
+
+var (
+	ipv4Matcher = matcher{re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)}
+	// Times like 13:41:12 look alike, so candidates must parse as addresses.
+	ipv6Matcher = matcher{
+		re:    regexp.MustCompile(`\b[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{0,4}){2,7}|::1\b`),
+		valid: func(s string) bool { return net.ParseIP(s) != nil },
+	}
+
+	builtins = map[string][]matcher{
+		"ip":    {ipv4Matcher, ipv6Matcher},
+		"ipv4":  {ipv4Matcher},
+		"ipv6":  {ipv6Matcher},
+		"email": {{re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)}},
+		"token": {
+			{re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+			{re: regexp.MustCompile(`(?i)\b(?:bearer|token|api[_-]?key|password|passwd|secret)["']?\s*[:=]?\s*["']?([A-Za-z0-9._~+/=-]{8,})`)},
+		},
+	}
+)
+
+func Load(path string) (*Redactor, error) {
+	r := &Redactor{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload rereads the rules file; on error the previous rules stay in use.
+func (r *Redactor) Reload() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	rules, err := parseRules(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.path, err)
+	}
+	r.rules.Store(rules)
+	return nil
+}
+
+func parseRules(data []byte) (*ruleFile, error) {
+	var file ruleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		// Unkeyed hashes of short values such as IPs are easy to reverse.
+		if rule.Action == "hash" && file.HashKey == "" {
+			return nil, fmt.Errorf("rule %s: action hash requires hash_key", rule.Name)
+		}
+	}
+
+	for role, rules := range file.Roles {
+		for _, name := range rules {
+			if !names[name] {
+				return nil, fmt.Errorf("role %s: unknown rule %s", role, name)
+			}
+		}
+	}
+	return &file, nil
+}
+
+func (r *Rule) compile() error {
+	set := 0
+	for _, s := range []string{r.Match, r.Pattern, r.Field} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of match, pattern and field is required")
+	}
+
+	switch r.Action {
+	case "":
+		r.Action = "mask"
+	case "mask", "hash":
+	default:
+		return fmt.Errorf("action must be mask or hash, got %q", r.Action)
+	}
+	if r.Replacement == "" {
+		r.Replacement = defaultReplacement
+	}
+
+	switch {
+	case r.Match != "":
+		matchers, ok := builtins[r.Match]
+		if !ok {
+			return fmt.Errorf("unknown match %q, expected ip, ipv4, ipv6, email or token", r.Match)
+		}
+		r.matchers = matchers
+	case r.Pattern != "":
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return err
+		}
+		r.matchers = []matcher{{re: re}}
+	default:
+		field := regexp.QuoteMeta(r.Field)
+		r.matchers = []matcher{{re: regexp.MustCompile(`(?:^|[\s,{;])"?` + field + `"?\s*[:=]\s*(?:"([^"]*)"|([^\s",;}]+))`)}}
+	}
+	return nil
+}
+
+// For returns the rules that apply to a caller with roles, or nil when
+// none do.
+func (r *Redactor) For(roles []string) *Set {
+	if r == nil {
+		return nil
+	}
+	file := r.rules.Load()
+
+	var allowed []string
+	listed := false
+	for _, role := range roles {
+		names, ok := file.Roles[role]
+		if !ok {
+			continue
+		}
+		// Each listed role lifts some rules; the caller gets the least
+		// redacted view any of its roles allows.
+		if !listed {
+			allowed = slices.Clone(names)
+			listed = true
+			continue
+		}
+		allowed = slices.DeleteFunc(allowed, func(name string) bool { return !slices.Contains(names, name) })
+	}
+
+	rules := file.Rules
+	if listed {
+		rules = slices.DeleteFunc(slices.Clone(rules), func(rule *Rule) bool { return !slices.Contains(allowed, rule.Name) })
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Set{rules: rules, hashKey: []byte(file.HashKey)}
+}
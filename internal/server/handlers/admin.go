@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/audit"
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+func (h *LogHandler) AdminRefresh(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.Refresh(r.Context()); err != nil {
+		http.Error(w, "refresh failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "refreshed",
+	}
+	writeJSON(w, response)
+}
+
+func (h *LogHandler) AdminReindexFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	file, err := h.service.ReindexFile(r.Context(), path)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	audit.FromContext(r.Context()).AddSources(file.Source)
+	writeJSON(w, file)
+}
+
+func (h *LogHandler) AdminFlushCache(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Flushed int `json:"flushed"`
+	}{
+		Flushed: h.service.FlushCache(),
+	}
+	writeJSON(w, response)
+}
+
+func (h *LogHandler) AdminEvictFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	evicted, err := h.service.EvictFile(r.Context(), path)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	response := struct {
+		Path    string `json:"path"`
+		Evicted bool   `json:"evicted"`
+	}{
+		Path:    path,
+		Evicted: evicted,
+	}
+	writeJSON(w, response)
+}
+
+func (h *LogHandler) AdminSetRefreshInterval(w http.ResponseWriter, r *http.Request) {
+	interval, err := time.ParseDuration(r.URL.Query().Get("interval"))
+	if err != nil || interval <= 0 {
+		http.Error(w, "invalid interval", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetRefreshInterval(r.Context(), interval); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	response := struct {
+		RefreshInterval string `json:"refresh_interval"`
+	}{
+		RefreshInterval: interval.String(),
+	}
+	writeJSON(w, response)
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrOutsideLogDir):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "file not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrFileSkipped):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, models.ErrNotSupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
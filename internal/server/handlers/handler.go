@@ -1,11 +1,20 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"time"
 
+	"github.com/Dor1ma/log-finder/internal/audit"
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/redact"
 	"github.com/Dor1ma/log-finder/internal/service"
+	"github.com/Dor1ma/log-finder/pkg/utils"
 )
 
 var timeFormat = "2006-01-02T15:04:05.000"
@@ -34,19 +43,342 @@ func (h *LogHandler) GetLogByTimestamp(w http.ResponseWriter, r *http.Request) {
 	result, err := h.service.FindLog(r.Context(), timestamp)
 	if err != nil {
 		if err == service.ErrNotFound {
+			audit.FromContext(r.Context()).SetResults(0)
 			http.Error(w, "log entry not found", http.StatusNotFound)
 			return
 		}
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeSearchError(w, err)
 		return
 	}
 
+	audit.FromContext(r.Context()).SetResults(1)
+
 	response := struct {
 		Timestamp time.Time `json:"timestamp"`
 		Message   string    `json:"message"`
 	}{
 		Timestamp: timestamp,
-		Message:   result,
+		Message:   redact.FromContext(r.Context()).Line(result),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type rangeEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+}
+
+const maxCountBuckets = 10000
+
+// parseTimeRange reads the from and to parameters. On failure it returns
+// the message for a 400 reply.
+func parseTimeRange(params url.Values) (from, to time.Time, errMsg string) {
+	if params.Get("from") == "" || params.Get("to") == "" {
+		return from, to, "from and to parameters are required"
+	}
+
+	from, err := time.Parse(timeFormat, params.Get("from"))
+	if err != nil {
+		return from, to, "invalid from format"
+	}
+
+	to, err = time.Parse(timeFormat, params.Get("to"))
+	if err != nil {
+		return from, to, "invalid to format"
+	}
+
+	if to.Before(from) {
+		return from, to, "to must not be before from"
+	}
+	return from, to, ""
+}
+
+// parseRangeQuery reads the from, to, pattern, pattern_id and limit
+// parameters shared by the range endpoints. On failure it returns the
+// message for a 400 reply.
+func parseRangeQuery(r *http.Request) (models.RangeQuery, string) {
+	params := r.URL.Query()
+	from, to, errMsg := parseTimeRange(params)
+	if errMsg != "" {
+		return models.RangeQuery{}, errMsg
+	}
+
+	query := models.RangeQuery{From: from, To: to}
+	var err error
+
+	if pattern := params.Get("pattern"); pattern != "" {
+		query.Pattern, err = regexp.Compile(pattern)
+		if err != nil {
+			return models.RangeQuery{}, "invalid pattern"
+		}
+		if set := redact.FromContext(r.Context()); set != nil {
+			query.Redact = set.Line
+		}
+	}
+
+	if idParam := params.Get("pattern_id"); idParam != "" {
+		query.PatternID, err = strconv.Atoi(idParam)
+		if err != nil || query.PatternID <= 0 {
+			return models.RangeQuery{}, "invalid pattern_id"
+		}
+	}
+
+	if limitParam := params.Get("limit"); limitParam != "" {
+		query.Limit, err = strconv.Atoi(limitParam)
+		if err != nil || query.Limit < 0 {
+			return models.RangeQuery{}, "invalid limit"
+		}
+	}
+
+	return query, ""
+}
+
+func (h *LogHandler) GetLogsInRange(w http.ResponseWriter, r *http.Request) {
+	query, errMsg := parseRangeQuery(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.FindRange(r.Context(), query)
+	if err != nil {
+		writeSearchError(w, err)
+		return
+	}
+
+	audit.FromContext(r.Context()).SetResults(len(result.Lines))
+
+	redactor := redact.FromContext(r.Context())
+	entries := make([]rangeEntry, 0, len(result.Lines))
+	for _, line := range result.Lines {
+		lineTime, _ := utils.ParseTimestamp(line)
+		entries = append(entries, rangeEntry{Timestamp: lineTime, Message: redactor.Line(line)})
+	}
+
+	response := struct {
+		From      time.Time    `json:"from"`
+		To        time.Time    `json:"to"`
+		Entries   []rangeEntry `json:"entries"`
+		Truncated bool         `json:"truncated"`
+	}{
+		From:      query.From,
+		To:        query.To,
+		Entries:   entries,
+		Truncated: result.Truncated,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *LogHandler) CountLogsInRange(w http.ResponseWriter, r *http.Request) {
+	query, errMsg := parseRangeQuery(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	if intervalParam := r.URL.Query().Get("interval"); intervalParam != "" {
+		interval, err := time.ParseDuration(intervalParam)
+		if err != nil || interval <= 0 {
+			http.Error(w, "invalid interval", http.StatusBadRequest)
+			return
+		}
+		if query.To.Sub(query.From)/interval >= maxCountBuckets {
+			http.Error(w, "interval too small for the requested range", http.StatusBadRequest)
+			return
+		}
+		query.Interval = interval
+	}
+
+	result, err := h.service.CountRange(r.Context(), query)
+	if err != nil {
+		writeSearchError(w, err)
+		return
+	}
+	audit.FromContext(r.Context()).SetResults(result.Total)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *LogHandler) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	from, to, errMsg := parseTimeRange(r.URL.Query())
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.service.Anomalies(r.Context(), from, to)
+	if errors.Is(err, models.ErrNotSupported) {
+		http.Error(w, "anomaly detection is not available", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		writeSearchError(w, err)
+		return
+	}
+	audit.FromContext(r.Context()).SetResults(len(report.Anomalies))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *LogHandler) GetPatterns(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	from, to, errMsg := parseTimeRange(params)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	var window time.Duration
+	if windowParam := params.Get("window"); windowParam != "" {
+		var err error
+		window, err = time.ParseDuration(windowParam)
+		if err != nil || window < time.Minute || window%time.Minute != 0 {
+			http.Error(w, "invalid window, expected whole minutes", http.StatusBadRequest)
+			return
+		}
+		if to.Sub(from)/window >= maxCountBuckets {
+			http.Error(w, "window too small for the requested range", http.StatusBadRequest)
+			return
+		}
+	}
+	onlyNew := params.Get("new") == "true"
+
+	report, err := h.service.Patterns(r.Context(), from, to, window, onlyNew)
+	if err != nil {
+		writeSearchError(w, err)
+		return
+	}
+	audit.FromContext(r.Context()).SetResults(len(report.Patterns))
+
+	// Templates keep the values of the first line of their pattern.
+	redactor := redact.FromContext(r.Context())
+	for i := range report.Patterns {
+		report.Patterns[i].Template = redactor.Line(report.Patterns[i].Template)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func writeSearchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "search deadline exceeded", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		http.Error(w, "search canceled", http.StatusServiceUnavailable)
+	case errors.Is(err, models.ErrNotReady):
+		http.Error(w, "index is not ready", http.StatusServiceUnavailable)
+	case errors.Is(err, models.ErrNoSuchPattern):
+		http.Error(w, "unknown pattern_id", http.StatusBadRequest)
+	case errors.Is(err, models.ErrNotSupported):
+		http.Error(w, "pattern mining is not enabled", http.StatusNotImplemented)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *LogHandler) GetResultCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.service.CacheStats())
+}
+
+func (h *LogHandler) GetFileCacheStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := h.service.FileCacheStats(r.Context())
+	if !ok {
+		http.Error(w, "file cache stats are not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+const (
+	stateReady       = "ready"
+	stateDegraded    = "degraded"
+	stateUnavailable = "unavailable"
+)
+
+// readiness reports unavailable until the initial index is built. Once it
+// is, problems that still leave searches answerable only degrade it.
+func readiness(status models.IndexStatus) (string, []string) {
+	if !status.Ready {
+		reasons := []string{"initial index is not built yet"}
+		if status.LastRefreshError != "" {
+			reasons = append(reasons, "refresh failed: "+status.LastRefreshError)
+		}
+		return stateUnavailable, reasons
+	}
+
+	var reasons []string
+	if status.LastRefreshError != "" {
+		reasons = append(reasons, "last refresh failed: "+status.LastRefreshError)
+	}
+	if len(status.Files) == 0 {
+		reasons = append(reasons, "index is empty")
+	}
+	if len(status.Skipped) > 0 {
+		reasons = append(reasons, strconv.Itoa(len(status.Skipped))+" files skipped")
+	}
+	if !status.WatcherActive {
+		reasons = append(reasons, "file watcher unavailable, relying on periodic refresh")
+	}
+
+	if len(reasons) > 0 {
+		return stateDegraded, reasons
+	}
+	return stateReady, nil
+}
+
+func (h *LogHandler) GetReady(w http.ResponseWriter, r *http.Request) {
+	state, reasons := stateReady, []string(nil)
+	if status, ok := h.service.IndexStatus(r.Context()); ok {
+		state, reasons = readiness(status)
+	}
+
+	response := struct {
+		Status  string   `json:"status"`
+		Reasons []string `json:"reasons,omitempty"`
+	}{
+		Status:  state,
+		Reasons: reasons,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if state == stateUnavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *LogHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	index, ok := h.service.IndexStatus(r.Context())
+	if !ok {
+		http.Error(w, "index status is not available", http.StatusNotFound)
+		return
+	}
+	state, reasons := readiness(index)
+
+	response := struct {
+		Status      string                  `json:"status"`
+		Reasons     []string                `json:"reasons,omitempty"`
+		Index       models.IndexStatus      `json:"index"`
+		ResultCache models.ResultCacheStats `json:"result_cache"`
+		FileCache   *models.FileCacheStats  `json:"file_cache,omitempty"`
+	}{
+		Status:      state,
+		Reasons:     reasons,
+		Index:       index,
+		ResultCache: h.service.CacheStats(),
+	}
+	if stats, ok := h.service.FileCacheStats(r.Context()); ok {
+		response.FileCache = &stats
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -4,27 +4,47 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Dor1ma/log-finder/internal/alerting"
+	"github.com/Dor1ma/log-finder/internal/anomaly"
 	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/redact"
 	"github.com/Dor1ma/log-finder/internal/service"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 type mockRepository struct {
-	result     string
-	err        error
-	refreshErr error
+	result      string
+	rangeResult models.RangeResult
+	countResult models.CountResult
+	err         error
+	refreshErr  error
+	query       models.RangeQuery
 }
 
-func (m *mockRepository) RefreshMetadata() error {
+func (m *mockRepository) RefreshMetadata(ctx context.Context) error {
 	return m.refreshErr
 }
 
-func (m *mockRepository) FindByTimestamp(ctx context.Context, t time.Time) (string, error) {
-	return m.result, m.err
+func (m *mockRepository) FindByTimestamp(ctx context.Context, t time.Time) (string, string, error) {
+	return m.result, models.DefaultSource, m.err
+}
+
+func (m *mockRepository) FindRange(ctx context.Context, query models.RangeQuery) (models.RangeResult, error) {
+	m.query = query
+	return m.rangeResult, m.err
+}
+
+func (m *mockRepository) CountRange(ctx context.Context, query models.RangeQuery) (models.CountResult, error) {
+	m.query = query
+	return m.countResult, m.err
 }
 
 func TestLogHandler_GetLogByTimestamp(t *testing.T) {
@@ -64,6 +84,13 @@ func TestLogHandler_GetLogByTimestamp(t *testing.T) {
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   "internal server error\n",
 		},
+		{
+			name:           "search deadline exceeded",
+			queryParam:     validTime,
+			repoError:      context.DeadlineExceeded,
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedBody:   "search deadline exceeded\n",
+		},
 		{
 			name:           "successful log retrieval",
 			queryParam:     validTime,
@@ -80,7 +107,7 @@ func TestLogHandler_GetLogByTimestamp(t *testing.T) {
 				err:    tt.repoError,
 			}
 
-			logService := service.NewLogService(mockRepo, time.Minute)
+			logService := service.NewLogService(mockRepo, service.Options{Cache: service.CacheOptions{TTL: time.Minute}})
 
 			handler := NewLogHandler(logService)
 
@@ -108,10 +135,451 @@ func TestLogHandler_GetLogByTimestamp(t *testing.T) {
 	}
 }
 
+func TestLogHandler_GetLogsInRange(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		rangeResult    models.RangeResult
+		repoError      error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "missing bounds",
+			query:          "from=2023-01-01T00:00:00.000",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "from and to parameters are required\n",
+		},
+		{
+			name:           "inverted range",
+			query:          "from=2023-01-01T00:00:01.000&to=2023-01-01T00:00:00.000",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "to must not be before from\n",
+		},
+		{
+			name:           "invalid limit",
+			query:          "from=2023-01-01T00:00:00.000&to=2023-01-01T00:00:01.000&limit=-1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid limit\n",
+		},
+		{
+			name:           "invalid pattern",
+			query:          "from=2023-01-01T00:00:00.000&to=2023-01-01T00:00:01.000&pattern=(",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid pattern\n",
+		},
+		{
+			name:  "truncated result",
+			query: "from=2023-01-01T00:00:00.000&to=2023-01-01T00:00:01.000",
+			rangeResult: models.RangeResult{
+				Lines:     []string{"2023-01-01T00:00:00.500 partial"},
+				Truncated: true,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"from":"2023-01-01T00:00:00Z","to":"2023-01-01T00:00:01Z","truncated":true,
+				"entries":[{"timestamp":"2023-01-01T00:00:00.5Z","message":"2023-01-01T00:00:00.500 partial"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &mockRepository{rangeResult: tt.rangeResult, err: tt.repoError}
+			handler := NewLogHandler(service.NewLogService(mockRepo, service.Options{Cache: service.CacheOptions{TTL: time.Minute}}))
+
+			req := httptest.NewRequest("GET", "/logs/range?"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			handler.GetLogsInRange(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			} else {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestLogHandler_RedactsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redact.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"name": "ip", "match": "ip"}]}`), 0600))
+	redactor, err := redact.Load(path)
+	require.NoError(t, err)
+
+	mockRepo := &mockRepository{rangeResult: models.RangeResult{Lines: []string{"2023-01-01T00:00:00.500 GET from 10.0.0.1"}}}
+	handler := NewLogHandler(service.NewLogService(mockRepo, service.Options{Cache: service.CacheOptions{TTL: time.Minute}}))
+
+	req := httptest.NewRequest("GET", "/logs/range?from=2023-01-01T00:00:00.000&to=2023-01-01T00:00:01.000", nil)
+	req = req.WithContext(redact.WithSet(req.Context(), redactor.For(nil)))
+	rr := httptest.NewRecorder()
+	handler.GetLogsInRange(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"from":"2023-01-01T00:00:00Z","to":"2023-01-01T00:00:01Z","truncated":false,
+		"entries":[{"timestamp":"2023-01-01T00:00:00.5Z","message":"2023-01-01T00:00:00.500 GET from [REDACTED]"}]}`, rr.Body.String())
+
+	t.Run("patterns match redacted lines", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/logs/count?from=2023-01-01T00:00:00.000&to=2023-01-01T00:00:01.000&pattern=10%5C.0%5C.0%5C.1", nil)
+		req = req.WithContext(redact.WithSet(req.Context(), redactor.For(nil)))
+		rr := httptest.NewRecorder()
+		handler.CountLogsInRange(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		line := "2023-01-01T00:00:00.500 GET from 10.0.0.1"
+		require.NotNil(t, mockRepo.query.Redact)
+		assert.Contains(t, mockRepo.query.Redact(line), "[REDACTED]")
+		assert.False(t, mockRepo.query.Matches(line), "hidden values cannot be probed")
+	})
+
+	t.Run("pattern templates", func(t *testing.T) {
+		start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		handler := NewLogHandler(service.NewLogService(&patternRepository{counts: models.PatternCounts{
+			Templates: map[int]string{1: "GET from 10.0.0.1"},
+			Minutes:   map[int]map[time.Time]int{1: {start: 1}},
+			FirstSeen: map[int]time.Time{1: start},
+		}}, service.Options{}))
+
+		req := httptest.NewRequest("GET", "/patterns?from=2023-01-01T00:00:00.000&to=2023-01-01T00:00:59.999", nil)
+		req = req.WithContext(redact.WithSet(req.Context(), redactor.For(nil)))
+		rr := httptest.NewRecorder()
+		handler.GetPatterns(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"template":"GET from [REDACTED]"`)
+	})
+}
+
+type minuteRepository struct {
+	mockRepository
+	series models.MinuteSeries
+}
+
+func (m *minuteRepository) MinuteCounts(ctx context.Context, from, to time.Time) (models.MinuteSeries, error) {
+	return m.series, nil
+}
+
+func TestLogHandler_GetAnomalies(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	counts := make(map[time.Time]models.MinuteCount)
+	for i := range 60 {
+		counts[start.Add(time.Duration(i)*time.Minute)] = models.MinuteCount{Lines: 100}
+	}
+	counts[start.Add(50*time.Minute)] = models.MinuteCount{Lines: 1000}
+
+	opts := service.Options{Anomaly: anomaly.Options{Baseline: 30 * time.Minute, Threshold: 4, MinErrorRatio: 0.05}}
+	handler := NewLogHandler(service.NewLogService(&minuteRepository{series: models.MinuteSeries{
+		Sources: map[string]map[time.Time]models.MinuteCount{"app": counts},
+		End:     start.Add(time.Hour),
+	}}, opts))
+
+	rr := httptest.NewRecorder()
+	handler.GetAnomalies(rr, httptest.NewRequest("GET", "/anomalies?from=2023-01-01T00:40:00.000&to=2023-01-01T00:59:00.000", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{"from":"2023-01-01T00:40:00Z","to":"2023-01-01T00:59:00Z","anomalies":[
+		{"source":"app","kind":"volume_spike","start":"2023-01-01T00:50:00Z","end":"2023-01-01T00:51:00Z",
+		"value":1000,"expected":100,"score":90}]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler.GetAnomalies(rr, httptest.NewRequest("GET", "/anomalies?from=2023-01-01T00:40:00.000", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	handler = NewLogHandler(service.NewLogService(&mockRepository{}, opts))
+	rr = httptest.NewRecorder()
+	handler.GetAnomalies(rr, httptest.NewRequest("GET", "/anomalies?from=2023-01-01T00:40:00.000&to=2023-01-01T00:59:00.000", nil))
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+type patternRepository struct {
+	mockRepository
+	counts models.PatternCounts
+}
+
+func (m *patternRepository) PatternCounts(ctx context.Context, from, to time.Time) (models.PatternCounts, error) {
+	return m.counts, nil
+}
+
+func TestLogHandler_GetPatterns(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := NewLogHandler(service.NewLogService(&patternRepository{counts: models.PatternCounts{
+		Templates: map[int]string{1: "user <*> logged in", 2: "disk full on <*>"},
+		Minutes: map[int]map[time.Time]int{
+			1: {start: 3, start.Add(10 * time.Minute): 1},
+			2: {start.Add(12 * time.Minute): 7},
+		},
+		FirstSeen: map[int]time.Time{1: start.Add(-time.Hour), 2: start.Add(12 * time.Minute)},
+	}}, service.Options{}))
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.GetPatterns(rr, httptest.NewRequest("GET", "/patterns?"+query, nil))
+		return rr
+	}
+
+	rr := serve("from=2023-01-01T00:00:00.000&to=2023-01-01T00:14:59.999&window=10m")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{"from":"2023-01-01T00:00:00Z","to":"2023-01-01T00:14:59.999Z","window":"10m0s","patterns":[
+		{"id":2,"template":"disk full on <*>","count":7,"first_seen":"2023-01-01T00:12:00Z","new":true,
+			"windows":[{"start":"2023-01-01T00:10:00Z","count":7}]},
+		{"id":1,"template":"user <*> logged in","count":4,"first_seen":"2022-12-31T23:00:00Z","new":false,
+			"windows":[{"start":"2023-01-01T00:00:00Z","count":3},{"start":"2023-01-01T00:10:00Z","count":1}]}]}`, rr.Body.String())
+
+	rr = serve("from=2023-01-01T00:00:00.000&to=2023-01-01T00:14:59.999&new=true")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"from":"2023-01-01T00:00:00Z","to":"2023-01-01T00:14:59.999Z","patterns":[
+		{"id":2,"template":"disk full on <*>","count":7,"first_seen":"2023-01-01T00:12:00Z","new":true}]}`, rr.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, serve("from=2023-01-01T00:00:00.000").Code)
+	assert.Equal(t, http.StatusBadRequest, serve("from=2023-01-01T00:00:00.000&to=2023-01-01T00:14:59.999&window=30s").Code)
+
+	handler = NewLogHandler(service.NewLogService(&mockRepository{}, service.Options{}))
+	assert.Equal(t, http.StatusNotImplemented, serve("from=2023-01-01T00:00:00.000&to=2023-01-01T00:14:59.999").Code)
+
+	rr = httptest.NewRecorder()
+	handler.GetLogsInRange(rr, httptest.NewRequest("GET", "/logs/range?from=2023-01-01T00:00:00.000&to=2023-01-01T00:00:01.000&pattern_id=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "invalid pattern_id\n", rr.Body.String())
+}
+
 func TestNewLogHandler(t *testing.T) {
 	mockRepo := &mockRepository{}
-	logService := service.NewLogService(mockRepo, time.Minute)
+	logService := service.NewLogService(mockRepo, service.Options{Cache: service.CacheOptions{TTL: time.Minute}})
 	handler := NewLogHandler(logService)
 
 	assert.NotNil(t, handler)
 }
+
+type statusRepository struct {
+	mockRepository
+	status models.IndexStatus
+}
+
+func (m *statusRepository) IndexStatus() models.IndexStatus {
+	return m.status
+}
+
+func TestLogHandler_GetReady(t *testing.T) {
+	healthy := models.IndexStatus{
+		Ready:         true,
+		WatcherActive: true,
+		Files:         []models.IndexedFile{{Path: "/var/log/app/app.log", Lines: 10}},
+	}
+
+	tests := []struct {
+		name           string
+		status         models.IndexStatus
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "initial index not built",
+			status:         models.IndexStatus{LastRefreshError: "permission denied"},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"status":"unavailable","reasons":["initial index is not built yet","refresh failed: permission denied"]}`,
+		},
+		{
+			name:           "ready",
+			status:         healthy,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"ready"}`,
+		},
+		{
+			name:           "empty index without watcher",
+			status:         models.IndexStatus{Ready: true},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"status":"degraded","reasons":["index is empty",
+				"file watcher unavailable, relying on periodic refresh"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &statusRepository{status: tt.status}
+			handler := NewLogHandler(service.NewLogService(repo, service.Options{}))
+
+			rr := httptest.NewRecorder()
+			handler.GetReady(rr, httptest.NewRequest("GET", "/ready", nil))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestLogHandler_GetStatus(t *testing.T) {
+	repo := &statusRepository{status: models.IndexStatus{
+		Ready:         true,
+		WatcherActive: true,
+		Files:         []models.IndexedFile{{Path: "/var/log/app/app.log", Size: 120, Lines: 3}},
+		Skipped:       []models.SkippedFile{{Path: "/var/log/app/notes.txt", Reason: "invalid log format"}},
+	}}
+	handler := NewLogHandler(service.NewLogService(repo, service.Options{}))
+
+	rr := httptest.NewRecorder()
+	handler.GetStatus(rr, httptest.NewRequest("GET", "/status", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `"status":"degraded"`)
+	assert.Contains(t, body, `"lines":3`)
+	assert.Contains(t, body, `{"path":"/var/log/app/notes.txt","source":"","reason":"invalid log format"}`)
+	assert.Contains(t, body, `"result_cache":{`)
+}
+
+func TestLogHandler_GetStatusFiltersSources(t *testing.T) {
+	repo := &statusRepository{status: models.IndexStatus{
+		Ready: true,
+		Files: []models.IndexedFile{
+			{Path: "/var/log/app/disk/disk.log", Source: "disk"},
+			{Path: "/var/log/app/payments/pay.log", Source: "payments"},
+		},
+		Skipped: []models.SkippedFile{{Path: "/var/log/app/payments/old.txt", Source: "payments"}},
+	}}
+	handler := NewLogHandler(service.NewLogService(repo, service.Options{}))
+
+	req := httptest.NewRequest("GET", "/status", nil)
+	req = req.WithContext(models.WithSourceAccess(req.Context(), models.SourceAccess{"disk": {{}}}))
+	rr := httptest.NewRecorder()
+	handler.GetStatus(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "disk.log")
+	assert.NotContains(t, body, "payments")
+}
+
+func TestLogHandler_NotReady(t *testing.T) {
+	handler := NewLogHandler(service.NewLogService(&mockRepository{err: models.ErrNotReady}, service.Options{}))
+
+	rr := httptest.NewRecorder()
+	handler.GetLogByTimestamp(rr, httptest.NewRequest("GET", "/logs?timestamp=2023-01-01T00:00:00.000", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "index is not ready\n", rr.Body.String())
+}
+
+type adminRepository struct {
+	mockRepository
+	reindexed models.IndexedFile
+	adminErr  error
+	interval  time.Duration
+}
+
+func (m *adminRepository) ReindexFile(ctx context.Context, path string) (models.IndexedFile, error) {
+	return m.reindexed, m.adminErr
+}
+
+func (m *adminRepository) EvictFile(ctx context.Context, path string) (bool, error) {
+	return m.adminErr == nil, m.adminErr
+}
+
+func (m *adminRepository) SetRefreshInterval(ctx context.Context, interval time.Duration) error {
+	m.interval = interval
+	return m.adminErr
+}
+
+func TestLogHandler_Admin(t *testing.T) {
+	t.Run("reindex", func(t *testing.T) {
+		repo := &adminRepository{reindexed: models.IndexedFile{Path: "/var/log/app/app.log", Lines: 2}}
+		handler := NewLogHandler(service.NewLogService(repo, service.Options{}))
+
+		rr := httptest.NewRecorder()
+		handler.AdminReindexFile(rr, httptest.NewRequest("POST", "/admin/reindex?path=app.log", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"lines":2`)
+
+		rr = httptest.NewRecorder()
+		handler.AdminReindexFile(rr, httptest.NewRequest("POST", "/admin/reindex", nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("admin errors", func(t *testing.T) {
+		for err, status := range map[error]int{
+			models.ErrOutsideLogDir: http.StatusBadRequest,
+			os.ErrNotExist:          http.StatusNotFound,
+			models.ErrFileSkipped:   http.StatusUnprocessableEntity,
+			assert.AnError:          http.StatusInternalServerError,
+		} {
+			handler := NewLogHandler(service.NewLogService(&adminRepository{adminErr: err}, service.Options{}))
+
+			rr := httptest.NewRecorder()
+			handler.AdminEvictFile(rr, httptest.NewRequest("POST", "/admin/file-cache/evict?path=x.log", nil))
+			assert.Equal(t, status, rr.Code, err.Error())
+		}
+	})
+
+	t.Run("refresh interval", func(t *testing.T) {
+		repo := &adminRepository{}
+		handler := NewLogHandler(service.NewLogService(repo, service.Options{}))
+
+		rr := httptest.NewRecorder()
+		handler.AdminSetRefreshInterval(rr, httptest.NewRequest("PUT", "/admin/refresh-interval?interval=5m", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"refresh_interval":"5m0s"}`, rr.Body.String())
+		assert.Equal(t, 5*time.Minute, repo.interval)
+
+		rr = httptest.NewRecorder()
+		handler.AdminSetRefreshInterval(rr, httptest.NewRequest("PUT", "/admin/refresh-interval?interval=60", nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("unsupported repository", func(t *testing.T) {
+		handler := NewLogHandler(service.NewLogService(&mockRepository{}, service.Options{}))
+
+		rr := httptest.NewRecorder()
+		handler.AdminReindexFile(rr, httptest.NewRequest("POST", "/admin/reindex?path=app.log", nil))
+		assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	})
+
+	t.Run("flush cache", func(t *testing.T) {
+		handler := NewLogHandler(service.NewLogService(&mockRepository{result: "line"}, service.Options{Cache: service.CacheOptions{TTL: time.Minute}}))
+		handler.GetLogByTimestamp(httptest.NewRecorder(), httptest.NewRequest("GET", "/logs?timestamp=2023-01-01T00:00:00.000", nil))
+
+		rr := httptest.NewRecorder()
+		handler.AdminFlushCache(rr, httptest.NewRequest("POST", "/admin/cache/flush", nil))
+		assert.JSONEq(t, `{"flushed":1}`, rr.Body.String())
+	})
+}
+
+func TestSearchHandler(t *testing.T) {
+	scheduler, err := alerting.NewScheduler(filepath.Join(t.TempDir(), "searches.json"), &mockRepository{},
+		alerting.Options{Webhook: "http://alerts.example/hook", Interval: time.Minute})
+	require.NoError(t, err)
+	handler := NewSearchHandler(scheduler)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/searches", handler.ListSearches).Methods("GET")
+	r.HandleFunc("/admin/searches/{name}", handler.GetSearch).Methods("GET")
+	r.HandleFunc("/admin/searches/{name}", handler.PutSearch).Methods("PUT")
+	r.HandleFunc("/admin/searches/{name}", handler.DeleteSearch).Methods("DELETE")
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := serve("GET", "/admin/searches", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+
+	rr = serve("PUT", "/admin/searches/errors", `{"pattern": "ERROR", "window": "5m", "threshold": 10}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{"name":"errors","pattern":"ERROR","window":"5m","threshold":10,"firing":false,"last_count":0}`, rr.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, serve("PUT", "/admin/searches/errors", `{"window": "soon", "threshold": 1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve("PUT", "/admin/searches/errors", `not json`).Code)
+
+	rr = serve("GET", "/admin/searches/errors", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"threshold":10`)
+
+	req := httptest.NewRequest("PUT", "/admin/searches/ips", strings.NewReader(`{"pattern": "10\\.0", "window": "5m", "threshold": 1}`))
+	req = req.WithContext(redact.WithSet(req.Context(), &redact.Set{}))
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "redacted callers cannot save patterns")
+
+	assert.Equal(t, http.StatusNoContent, serve("DELETE", "/admin/searches/errors", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/admin/searches/errors", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("DELETE", "/admin/searches/errors", "").Code)
+}
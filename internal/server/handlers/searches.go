@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Dor1ma/log-finder/internal/alerting"
+	"github.com/Dor1ma/log-finder/internal/redact"
+	"github.com/gorilla/mux"
+)
+
+const maxSearchBody = 64 << 10
+
+// SearchHandler manages the saved searches behind threshold alerts.
+type SearchHandler struct {
+	scheduler *alerting.Scheduler
+}
+
+func NewSearchHandler(scheduler *alerting.Scheduler) *SearchHandler {
+	return &SearchHandler{scheduler: scheduler}
+}
+
+func (h *SearchHandler) ListSearches(w http.ResponseWriter, r *http.Request) {
+	searches := h.scheduler.Searches()
+	if searches == nil {
+		searches = []alerting.Status{}
+	}
+	writeJSON(w, searches)
+}
+
+func (h *SearchHandler) GetSearch(w http.ResponseWriter, r *http.Request) {
+	status, err := h.scheduler.Search(mux.Vars(r)["name"])
+	if err != nil {
+		writeSearchAdminError(w, err)
+		return
+	}
+	writeJSON(w, status)
+}
+
+// PutSearch creates or replaces the search named in the path; a name in the
+// body is ignored.
+func (h *SearchHandler) PutSearch(w http.ResponseWriter, r *http.Request) {
+	var search alerting.SavedSearch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSearchBody)).Decode(&search); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	search.Name = mux.Vars(r)["name"]
+	// Searches are evaluated with no caller to redact for, so a pattern
+	// could probe values this caller is not shown.
+	if search.Pattern != "" && redact.FromContext(r.Context()) != nil {
+		http.Error(w, "pattern is not allowed for callers with redaction rules", http.StatusForbidden)
+		return
+	}
+
+	if err := h.scheduler.Put(&search); err != nil {
+		writeSearchAdminError(w, err)
+		return
+	}
+
+	status, err := h.scheduler.Search(search.Name)
+	if err != nil {
+		writeSearchAdminError(w, err)
+		return
+	}
+	writeJSON(w, status)
+}
+
+func (h *SearchHandler) DeleteSearch(w http.ResponseWriter, r *http.Request) {
+	if err := h.scheduler.Delete(mux.Vars(r)["name"]); err != nil {
+		writeSearchAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeSearchAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, alerting.ErrInvalidSearch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, alerting.ErrSearchNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		slog.Error("Failed to update saved searches", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
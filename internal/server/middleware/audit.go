@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/audit"
+	"github.com/Dor1ma/log-finder/internal/logging"
+	"github.com/gorilla/mux"
+)
+
+// Audit writes a record of every request to logger whose route action
+// names, under that name; routes it returns "" for are not audited. Rejected
+// requests are recorded too, so it goes before Authenticate, which fills in
+// the principal. Handlers and the service fill in the result count and
+// sources through the record in the context. A nil logger turns auditing
+// off.
+func Audit(logger *audit.Logger, action func(route string) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if logger == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeTemplate(r)
+			name := action(route)
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			record := &audit.Record{
+				Time:       start,
+				RequestID:  logging.RequestID(r.Context()),
+				RemoteAddr: remoteIP(r),
+				Action:     name,
+				Method:     r.Method,
+				Route:      route,
+				Params:     auditParams(r),
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(audit.WithRecord(r.Context(), record)))
+
+			record.Status = recorder.status
+			record.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+			logger.Write(record)
+		})
+	}
+}
+
+// auditParams flattens the query string; repeated parameters keep their
+// first value, as the handlers do.
+func auditParams(r *http.Request) map[string]string {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		return nil
+	}
+	params := make(map[string]string, len(query))
+	for key := range query {
+		params[key] = query.Get(key)
+	}
+	return params
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
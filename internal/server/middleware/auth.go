@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/Dor1ma/log-finder/internal/audit"
+	"github.com/Dor1ma/log-finder/internal/auth"
+	"github.com/Dor1ma/log-finder/internal/metrics"
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/redact"
+	"github.com/gorilla/mux"
+)
+
+const authChallenge = `Bearer realm="log-finder", Basic realm="log-finder"`
+
+// Authenticate puts the caller's principal into the request context.
+// Invalid credentials are always rejected; requests without any are let
+// through anonymously unless required is set. Routes whose template is in
+// exempt are not checked at all. An IP that sent more invalid credentials
+// than limiter allows gets 429 until its bucket refills, so passwords cannot
+// be guessed faster than that.
+func Authenticate(authn auth.Authenticator, required bool, limiter *RateLimiter, exempt ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeTemplate(r)
+			if slices.Contains(exempt, route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			failures, perMinute := limiter.failures(remoteIP(r))
+			if failures != nil {
+				if tokens := failures.Tokens(); tokens < 1 {
+					metrics.RateLimited.Inc(route)
+					wait := math.Ceil((1 - tokens) * 60 / float64(perMinute))
+					w.Header().Set("Retry-After", strconv.Itoa(max(int(wait), 1)))
+					http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			principal, err := authn.Authenticate(r)
+			if err != nil || (principal == nil && required) {
+				reason := "missing credentials"
+				if err != nil {
+					reason = err.Error()
+					if failures != nil {
+						failures.Allow()
+					}
+				}
+				slog.WarnContext(r.Context(), "Rejected unauthenticated request",
+					"path", r.URL.Path, "remote_addr", r.RemoteAddr, "reason", reason)
+				metrics.AuthFailures.Inc(route)
+				w.Header().Set("WWW-Authenticate", authChallenge)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if principal != nil {
+				audit.FromContext(r.Context()).SetPrincipal(principal.Name, principal.Method)
+				r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authorize lets a request through only when policy grants its principal
+// endpoint, and passes the sources it may read on in the context.
+func Authorize(policy *auth.Policy, endpoint string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := auth.FromContext(r.Context())
+			access, ok := policy.Access(principal, endpoint)
+			if !ok {
+				if principal == nil {
+					w.Header().Set("WWW-Authenticate", authChallenge)
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+				slog.WarnContext(r.Context(), "Rejected request to a forbidden endpoint",
+					"path", r.URL.Path, "principal", principal.Name, "endpoint", endpoint)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			if access != nil {
+				r = r.WithContext(models.WithSourceAccess(r.Context(), access))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AllSources rejects callers restricted to some sources. It guards endpoints
+// such as /metrics whose numbers cover every source and cannot be split.
+func AllSources(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if models.SourceAccessFrom(r.Context()) != nil {
+			http.Error(w, "forbidden: requires access to every source", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Redact passes the redaction rules for the caller's roles on in the
+// context, for handlers to apply to log lines before they are written out.
+func Redact(redactor *redact.Redactor, policy *auth.Policy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if redactor == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			roles := policy.Roles(auth.FromContext(r.Context()))
+			if set := redactor.For(roles); set != nil {
+				r = r.WithContext(redact.WithSet(r.Context(), set))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
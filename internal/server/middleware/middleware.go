@@ -1,30 +1,140 @@
 package middleware
 
 import (
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/Dor1ma/log-finder/internal/logging"
+	"github.com/Dor1ma/log-finder/internal/metrics"
+	"github.com/Dor1ma/log-finder/internal/tracing"
+	"github.com/gorilla/mux"
 )
 
-func RateLimit(next http.HandlerFunc, requestsPerSecond int) http.HandlerFunc {
-	limiter := rate.NewLimiter(rate.Limit(requestsPerSecond), requestsPerSecond)
-
+func LoggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
-			return
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		slog.InfoContext(r.Context(), "Request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"status", recorder.status,
+			"duration", time.Since(start))
+	}
+}
+
+const (
+	RequestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// RequestID takes the request ID from X-Request-ID, or generates one when
+// the header is missing or malformed, and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID only accepts short printable IDs, so client supplied values
+// cannot inject anything into log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
 		}
-		next(w, r)
 	}
+	return true
 }
 
-func LoggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Metrics records request counts and latencies per route template, so
+// query parameters never turn into separate series.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next(w, r)
-		duration := time.Since(start)
-		log.Printf("%s %s %s %v", r.Method, r.URL.Path, r.RemoteAddr, duration)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := routeTemplate(r)
+		status := strconv.Itoa(recorder.status)
+		metrics.HTTPRequests.Inc(route, r.Method, status)
+		metrics.HTTPDuration.ObserveDuration(start, route, status)
+	})
+}
+
+const traceparentHeader = "traceparent"
+
+// Tracing starts a server span for each request, continuing the trace of
+// an incoming traceparent header, and returns the span's traceparent so
+// clients can look the request up.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, ok := tracing.ParseTraceparent(r.Header.Get(traceparentHeader)); ok {
+			ctx = tracing.WithRemoteParent(ctx, parent)
+		}
+
+		route := routeTemplate(r)
+		ctx, span := tracing.StartKind(ctx, r.Method+" "+route, tracing.KindServer)
+		if span == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		defer span.End()
+
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("http.route", route)
+		span.SetAttr("url.query", r.URL.RawQuery)
+		if id := logging.RequestID(ctx); id != "" {
+			span.SetAttr("request_id", id)
+		}
+		w.Header().Set(traceparentHeader, span.Context().Traceparent())
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttr("http.response.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(recorder.status)))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
 	}
+	return "unmatched"
 }
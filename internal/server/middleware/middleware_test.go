@@ -0,0 +1,405 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/audit"
+	"github.com/Dor1ma/log-finder/internal/auth"
+	"github.com/Dor1ma/log-finder/internal/logging"
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/redact"
+	"github.com/Dor1ma/log-finder/internal/tracing"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	t.Run("accepts client ID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/logs", nil)
+		req.Header.Set(RequestIDHeader, "client-42")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "client-42", seen)
+		assert.Equal(t, "client-42", rec.Header().Get(RequestIDHeader))
+	})
+
+	t.Run("generates missing ID", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/logs", nil))
+
+		assert.Len(t, seen, 32)
+		assert.Equal(t, seen, rec.Header().Get(RequestIDHeader))
+	})
+
+	t.Run("replaces malformed ID", func(t *testing.T) {
+		for _, id := range []string{"with space", "line\nbreak", strings.Repeat("a", maxRequestIDLength+1)} {
+			req := httptest.NewRequest("GET", "/logs", nil)
+			req.Header.Set(RequestIDHeader, id)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.NotEqual(t, id, seen)
+			assert.Len(t, seen, 32)
+		}
+	})
+}
+
+func TestTracingPropagatesTraceparent(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := tracing.NewExporter(&buf, nil)
+	tracing.SetExporter(exporter)
+	defer tracing.SetExporter(nil)
+
+	var traceID string
+	handler := Tracing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = tracing.FromContext(r.Context()).Context().TraceID.String()
+	}))
+
+	req := httptest.NewRequest("GET", "/logs", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.NoError(t, exporter.Close())
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`, rec.Header().Get("traceparent"))
+	assert.Contains(t, buf.String(), `"parentSpanId":"00f067aa0ba902b7"`)
+	assert.Contains(t, buf.String(), `"kind":2`)
+}
+
+func TestAuthenticate(t *testing.T) {
+	admin := &auth.Principal{Name: "admin", Roles: []string{auth.RoleAdmin}}
+	user := &auth.Principal{Name: "user"}
+	authn := auth.Chain{auth.NewStaticToken("admin-token", admin), auth.NewStaticToken("user-token", user)}
+
+	newRouter := func(required bool) *mux.Router {
+		return newAuthRouter(authn, required, nil)
+	}
+
+	serve := func(r *mux.Router, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("optional", func(t *testing.T) {
+		r := newRouter(false)
+
+		rec := serve(r, "/logs", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Body.String())
+
+		rec = serve(r, "/logs", "user-token")
+		assert.Equal(t, "user", rec.Body.String())
+
+		rec = serve(r, "/logs", "forged")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("required", func(t *testing.T) {
+		r := newRouter(true)
+
+		assert.Equal(t, http.StatusUnauthorized, serve(r, "/logs", "").Code)
+		assert.Equal(t, http.StatusOK, serve(r, "/logs", "user-token").Code)
+		assert.Equal(t, http.StatusOK, serve(r, "/health", "").Code)
+		assert.Equal(t, http.StatusOK, serve(r, "/health", "forged").Code)
+	})
+
+	t.Run("admin role", func(t *testing.T) {
+		r := newRouter(false)
+
+		assert.Equal(t, http.StatusUnauthorized, serve(r, "/admin/refresh", "").Code)
+		assert.Equal(t, http.StatusForbidden, serve(r, "/admin/refresh", "user-token").Code)
+		assert.Equal(t, http.StatusOK, serve(r, "/admin/refresh", "admin-token").Code)
+	})
+
+	t.Run("failed attempts limited per IP", func(t *testing.T) {
+		r := newAuthRouter(authn, false, NewRateLimiter(RateLimitOptions{AuthFailures: 2}))
+
+		assert.Equal(t, http.StatusUnauthorized, serve(r, "/logs", "forged").Code)
+		assert.Equal(t, http.StatusOK, serve(r, "/logs", "user-token").Code)
+		assert.Equal(t, http.StatusOK, serve(r, "/logs", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(r, "/logs", "forged").Code)
+
+		rec := serve(r, "/logs", "forged")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusTooManyRequests, serve(r, "/logs", "user-token").Code)
+		assert.Equal(t, http.StatusOK, serve(r, "/health", "forged").Code)
+
+		req := httptest.NewRequest("GET", "/logs", nil)
+		req.RemoteAddr = "192.0.2.2:1234"
+		req.Header.Set("Authorization", "Bearer user-token")
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func newAuthRouter(authn auth.Authenticator, required bool, limiter *RateLimiter) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(Authenticate(authn, required, limiter, "/health"))
+	r.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+		if p := auth.FromContext(r.Context()); p != nil {
+			w.Write([]byte(p.Name))
+		}
+	})
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(Authorize(nil, auth.EndpointAdmin))
+	admin.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {})
+	return r
+}
+
+func TestAuthorize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"roles": {"disk": {"sources": ["disk"], "endpoints": ["search"]}},
+		"users": {"bob": ["disk"]}
+	}`), 0600))
+	policy, err := auth.NewPolicy(path, time.Minute)
+	require.NoError(t, err)
+
+	var access models.SourceAccess
+	handler := Authorize(policy, auth.EndpointSearch)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		access = models.SourceAccessFrom(r.Context())
+	}))
+
+	serve := func(p *auth.Principal) int {
+		req := httptest.NewRequest("GET", "/logs/range", nil)
+		if p != nil {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(nil))
+	assert.Equal(t, http.StatusForbidden, serve(&auth.Principal{Name: "eve"}))
+
+	require.Equal(t, http.StatusOK, serve(&auth.Principal{Name: "bob"}))
+	assert.Equal(t, models.SourceAccess{"disk": {{}}}, access)
+
+	access = nil
+	require.Equal(t, http.StatusOK, serve(&auth.Principal{Name: "root", Roles: []string{auth.RoleAdmin}}))
+	assert.Nil(t, access)
+
+	t.Run("all sources", func(t *testing.T) {
+		handler := Authorize(policy, auth.EndpointSearch)(AllSources(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		for principal, status := range map[*auth.Principal]int{
+			{Name: "bob"}: http.StatusForbidden,
+			{Name: "root", Roles: []string{auth.RoleAdmin}}: http.StatusOK,
+		} {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, status, rec.Code, principal.Name)
+		}
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitOptions{
+		Rate:       2,
+		Routes:     map[string]int{"/stats": 0},
+		Costs:      map[string]int{"/logs/range": 2},
+		MaxClients: 2,
+	})
+
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/logs", limiter.Limit(ok))
+	r.HandleFunc("/logs/range", limiter.Limit(ok))
+	r.HandleFunc("/stats", limiter.Limit(ok))
+
+	serve := func(path, addr string, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = addr
+		if principal != nil {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("per client with headers", func(t *testing.T) {
+		rec := serve("/logs", "10.0.0.1:1000", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "1", rec.Header().Get("RateLimit-Reset"))
+
+		assert.Equal(t, http.StatusOK, serve("/logs", "10.0.0.1:2000", nil).Code)
+		rec = serve("/logs", "10.0.0.1:3000", nil)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+		assert.Equal(t, http.StatusOK, serve("/logs", "10.0.0.2:1000", nil).Code, "other clients are not affected")
+	})
+
+	t.Run("principals share a bucket across addresses", func(t *testing.T) {
+		ci := &auth.Principal{Name: "ci", Method: "api_key"}
+		assert.Equal(t, http.StatusOK, serve("/logs", "10.0.1.1:1", ci).Code)
+		assert.Equal(t, http.StatusOK, serve("/logs", "10.0.1.2:1", ci).Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve("/logs", "10.0.1.3:1", ci).Code)
+	})
+
+	t.Run("costly routes use more tokens", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("/logs/range", "10.0.2.1:1", nil).Code)
+		rec := serve("/logs/range", "10.0.2.1:1", nil)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	})
+
+	t.Run("routes without a limit", func(t *testing.T) {
+		for range 5 {
+			assert.Equal(t, http.StatusOK, serve("/stats", "10.0.3.1:1", nil).Code)
+		}
+	})
+
+	t.Run("idle clients are evicted", func(t *testing.T) {
+		limiter.mutex.Lock()
+		defer limiter.mutex.Unlock()
+		assert.Equal(t, 2, limiter.lru.Len())
+		assert.Len(t, limiter.clients, 2)
+	})
+}
+
+func TestAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.Open(path, 0, 0)
+	require.NoError(t, err)
+
+	authn := auth.Chain{auth.NewStaticToken("bob-token", &auth.Principal{Name: "bob", Method: "api_key"})}
+	action := func(route string) string {
+		switch route {
+		case "/logs/range":
+			return "search"
+		case "/admin/flush":
+			return "admin"
+		}
+		return ""
+	}
+
+	r := mux.NewRouter()
+	r.Use(RequestID, Audit(logger, action), Authenticate(authn, false, nil))
+	r.HandleFunc("/logs/range", func(w http.ResponseWriter, r *http.Request) {
+		audit.FromContext(r.Context()).AddSources("nginx")
+		audit.FromContext(r.Context()).SetResults(2)
+	})
+	r.HandleFunc("/admin/flush", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	r.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/logs/range?from=a&to=b", nil)
+	req.RemoteAddr = "127.0.0.1:4242"
+	req.Header.Set("Authorization", "Bearer bob-token")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/admin/flush", nil)
+	req.RemoteAddr = "10.0.0.1:4242"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/logs/range", nil)
+	req.RemoteAddr = "10.0.0.2:4242"
+	req.Header.Set("Authorization", "Bearer forged")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/status", nil))
+	require.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3, "status requests are not audited")
+
+	var search map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &search))
+	assert.Equal(t, "search", search["action"])
+	assert.Equal(t, "bob", search["principal"])
+	assert.Equal(t, "api_key", search["auth_method"])
+	assert.Equal(t, "127.0.0.1", search["remote_addr"])
+	assert.Equal(t, "/logs/range", search["route"])
+	assert.Equal(t, map[string]any{"from": "a", "to": "b"}, search["params"])
+	assert.Equal(t, []any{"nginx"}, search["sources"])
+	assert.Equal(t, float64(2), search["results"])
+	assert.Equal(t, float64(http.StatusOK), search["status"])
+	assert.NotEmpty(t, search["request_id"])
+
+	var admin map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &admin))
+	assert.Equal(t, "admin", admin["action"])
+	assert.Equal(t, float64(http.StatusForbidden), admin["status"])
+	assert.NotContains(t, admin, "principal")
+	assert.NotContains(t, admin, "results")
+
+	var rejected map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &rejected))
+	assert.Equal(t, "search", rejected["action"])
+	assert.Equal(t, "10.0.0.2", rejected["remote_addr"])
+	assert.Equal(t, float64(http.StatusUnauthorized), rejected["status"])
+	assert.NotContains(t, rejected, "sources")
+}
+
+func TestRedact(t *testing.T) {
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "redact.json")
+	require.NoError(t, os.WriteFile(rulesPath, []byte(`{
+		"rules": [{"name": "ip", "match": "ip"}],
+		"roles": {"security": []}
+	}`), 0600))
+	redactor, err := redact.Load(rulesPath)
+	require.NoError(t, err)
+
+	policyPath := filepath.Join(dir, "policy.json")
+	require.NoError(t, os.WriteFile(policyPath, []byte(`{
+		"roles": {"security": {"sources": ["*"], "endpoints": ["search"]}},
+		"users": {"carol": ["security"]}
+	}`), 0600))
+	policy, err := auth.NewPolicy(policyPath, time.Minute)
+	require.NoError(t, err)
+
+	var line string
+	handler := Redact(redactor, policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		line = redact.FromContext(r.Context()).Line("GET from 127.0.0.1")
+	}))
+
+	serve := func(p *auth.Principal) string {
+		req := httptest.NewRequest("GET", "/logs", nil)
+		if p != nil {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return line
+	}
+
+	assert.Equal(t, "GET from [REDACTED]", serve(nil))
+	assert.Equal(t, "GET from [REDACTED]", serve(&auth.Principal{Name: "bob"}))
+	assert.Equal(t, "GET from 127.0.0.1", serve(&auth.Principal{Name: "alice", Roles: []string{"security"}}))
+	assert.Equal(t, "GET from 127.0.0.1", serve(&auth.Principal{Name: "carol"}))
+}
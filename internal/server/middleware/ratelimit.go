@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/auth"
+	"github.com/Dor1ma/log-finder/internal/metrics"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitOptions configures per-client limits. Rate is the number of
+// tokens a client gets per second on each route, overridden per route
+// template by Routes; a zero Rate disables limiting. A request costs one
+// token unless Costs says otherwise. MaxClients bounds the number of
+// limiters kept; the least recently used one is dropped first.
+// AuthFailures is the number of rejected credentials an IP may send per
+// minute before it is refused without checking them; zero disables it.
+type RateLimitOptions struct {
+	Rate         int
+	Routes       map[string]int
+	Costs        map[string]int
+	MaxClients   int
+	AuthFailures int
+}
+
+// RateLimiter keeps a token bucket per client and route. Clients are told
+// where they stand through the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, and how long to wait through Retry-After.
+type RateLimiter struct {
+	mutex   sync.Mutex
+	opts    RateLimitOptions
+	lru     *list.List
+	clients map[string]*list.Element
+}
+
+type clientLimiter struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+func NewRateLimiter(opts RateLimitOptions) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetOptions(opts)
+	return l
+}
+
+// SetOptions replaces the limits. Clients start over with full buckets.
+func (l *RateLimiter) SetOptions(opts RateLimitOptions) {
+	if opts.MaxClients <= 0 {
+		opts.MaxClients = 10000
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.opts = opts
+	l.lru = list.New()
+	l.clients = make(map[string]*list.Element)
+}
+
+func (l *RateLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		limiter, limit, cost := l.limiter(route, clientKey(r))
+		if limiter == nil {
+			next(w, r)
+			return
+		}
+		burst := limiter.Burst()
+
+		now := time.Now()
+		allowed := limiter.AllowN(now, cost)
+		tokens := limiter.TokensAt(now)
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(burst))
+		header.Set("RateLimit-Remaining", strconv.Itoa(max(int(tokens), 0)))
+		header.Set("RateLimit-Reset", strconv.Itoa(secondsUntil(float64(burst)-tokens, limit)))
+
+		if !allowed {
+			metrics.RateLimited.Inc(route)
+			header.Set("Retry-After", strconv.Itoa(max(secondsUntil(float64(cost)-tokens, limit), 1)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limiter returns the bucket of client on route, creating it if needed,
+// with the route's rate and the cost of one request. It returns nil when
+// the route is not limited.
+func (l *RateLimiter) limiter(route, client string) (*rate.Limiter, int, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limit := l.opts.Rate
+	if routeLimit, ok := l.opts.Routes[route]; ok {
+		limit = routeLimit
+	}
+	if limit <= 0 {
+		return nil, 0, 0
+	}
+	cost := max(l.opts.Costs[route], 1)
+	return l.bucket(route+" "+client, rate.Limit(limit), max(limit, cost)), limit, cost
+}
+
+// failures returns the bucket of rejected credentials from ip, or nil when
+// they are not limited.
+func (l *RateLimiter) failures(ip string) (*rate.Limiter, int) {
+	if l == nil {
+		return nil, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	perMinute := l.opts.AuthFailures
+	if perMinute <= 0 {
+		return nil, 0
+	}
+	return l.bucket("auth-failures "+ip, rate.Limit(perMinute)/60, perMinute), perMinute
+}
+
+// bucket returns the limiter stored under key, creating it if needed and
+// dropping the least recently used ones beyond MaxClients. The caller holds
+// the mutex.
+func (l *RateLimiter) bucket(key string, limit rate.Limit, burst int) *rate.Limiter {
+	if element, ok := l.clients[key]; ok {
+		l.lru.MoveToFront(element)
+		return element.Value.(*clientLimiter).limiter
+	}
+
+	entry := &clientLimiter{key: key, limiter: rate.NewLimiter(limit, burst)}
+	l.clients[key] = l.lru.PushFront(entry)
+	for l.lru.Len() > l.opts.MaxClients {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.clients, oldest.Value.(*clientLimiter).key)
+	}
+	return entry.limiter
+}
+
+// clientKey identifies the caller by its principal when it authenticated,
+// so one key is limited the same from every address, and by IP otherwise.
+func clientKey(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		return principal.Method + ":" + principal.Name
+	}
+	return "ip:" + remoteIP(r)
+}
+
+func secondsUntil(tokens float64, limit int) int {
+	if tokens <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / float64(limit)))
+}
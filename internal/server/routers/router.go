@@ -2,22 +2,129 @@ package routers
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/Dor1ma/log-finder/internal/audit"
+	"github.com/Dor1ma/log-finder/internal/auth"
+	"github.com/Dor1ma/log-finder/internal/metrics"
+	"github.com/Dor1ma/log-finder/internal/redact"
 	"github.com/Dor1ma/log-finder/internal/server/handlers"
 	"github.com/Dor1ma/log-finder/internal/server/middleware"
 	"github.com/gorilla/mux"
 )
 
-func NewRouter(handler *handlers.LogHandler, rateLimit int) *mux.Router {
+type Options struct {
+	RateLimiter   *middleware.RateLimiter
+	Authenticator auth.Authenticator
+	// RequireAuth rejects requests without credentials; otherwise they are
+	// served anonymously and only admin endpoints need a principal.
+	RequireAuth bool
+	// Policy restricts endpoints and sources by role; nil lets every caller
+	// search and only the admin role use /admin.
+	Policy *auth.Policy
+	// AuditLog records searches and admin actions; nil disables it.
+	AuditLog *audit.Logger
+	// Redactor masks personal data in returned lines; nil serves them raw.
+	Redactor *redact.Redactor
+	// Searches serves the saved searches under /admin/searches; nil leaves
+	// them out.
+	Searches *handlers.SearchHandler
+}
+
+func NewRouter(handler *handlers.LogHandler, opts Options) *mux.Router {
+	limit := opts.RateLimiter.Limit
+	search := middleware.Authorize(opts.Policy, auth.EndpointSearch)
+	status := middleware.Authorize(opts.Policy, auth.EndpointStatus)
+
 	r := mux.NewRouter()
+	// Middleware added with Use only runs for matched routes, so requests
+	// that match none are counted here under the "unmatched" route.
+	r.NotFoundHandler = middleware.RequestID(middleware.Metrics(http.NotFoundHandler()))
+	r.MethodNotAllowedHandler = middleware.RequestID(middleware.Metrics(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		})))
+	r.Use(middleware.RequestID, middleware.Tracing, middleware.Metrics,
+		middleware.Audit(opts.AuditLog, auditAction),
+		middleware.Authenticate(opts.Authenticator, opts.RequireAuth, opts.RateLimiter, "/health", "/ready"),
+		middleware.Redact(opts.Redactor, opts.Policy))
 
-	r.HandleFunc("/logs", middleware.RateLimit(middleware.LoggingMiddleware(handler.GetLogByTimestamp), rateLimit)).
+	r.Handle("/logs", search(limit(middleware.LoggingMiddleware(handler.GetLogByTimestamp)))).
 		Methods("GET").
 		Queries("timestamp", "{timestamp}")
 
+	r.Handle("/logs/range", search(limit(middleware.LoggingMiddleware(handler.GetLogsInRange)))).
+		Methods("GET")
+
+	r.Handle("/logs/count", search(limit(middleware.LoggingMiddleware(handler.CountLogsInRange)))).
+		Methods("GET")
+
+	r.Handle("/anomalies", search(limit(middleware.LoggingMiddleware(handler.GetAnomalies)))).
+		Methods("GET")
+
+	r.Handle("/patterns", search(limit(middleware.LoggingMiddleware(handler.GetPatterns)))).
+		Methods("GET")
+
+	r.Handle("/stats/result-cache", status(middleware.LoggingMiddleware(handler.GetResultCacheStats))).
+		Methods("GET")
+
+	r.Handle("/stats/file-cache", status(middleware.LoggingMiddleware(handler.GetFileCacheStats))).
+		Methods("GET")
+
+	r.Handle("/status", status(middleware.LoggingMiddleware(handler.GetStatus))).
+		Methods("GET")
+
+	r.Handle("/metrics", status(middleware.AllSources(metrics.Default.Handler()))).Methods("GET")
+
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}).Methods("GET")
 
+	r.HandleFunc("/ready", handler.GetReady).Methods("GET")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.Authorize(opts.Policy, auth.EndpointAdmin))
+
+	admin.HandleFunc("/refresh", middleware.LoggingMiddleware(handler.AdminRefresh)).
+		Methods("POST")
+
+	admin.HandleFunc("/reindex", middleware.LoggingMiddleware(handler.AdminReindexFile)).
+		Methods("POST")
+
+	admin.HandleFunc("/cache/flush", middleware.LoggingMiddleware(handler.AdminFlushCache)).
+		Methods("POST")
+
+	admin.HandleFunc("/file-cache/evict", middleware.LoggingMiddleware(handler.AdminEvictFile)).
+		Methods("POST")
+
+	admin.HandleFunc("/refresh-interval", middleware.LoggingMiddleware(handler.AdminSetRefreshInterval)).
+		Methods("PUT")
+
+	if opts.Searches != nil {
+		admin.HandleFunc("/searches", middleware.LoggingMiddleware(opts.Searches.ListSearches)).
+			Methods("GET")
+
+		admin.HandleFunc("/searches/{name}", middleware.LoggingMiddleware(opts.Searches.GetSearch)).
+			Methods("GET")
+
+		admin.HandleFunc("/searches/{name}", middleware.LoggingMiddleware(opts.Searches.PutSearch)).
+			Methods("PUT")
+
+		admin.HandleFunc("/searches/{name}", middleware.LoggingMiddleware(opts.Searches.DeleteSearch)).
+			Methods("DELETE")
+	}
+
 	return r
 }
+
+// auditAction tells what requests to route are audited as. Searches and
+// admin actions are; status endpoints and probes are not.
+func auditAction(route string) string {
+	switch {
+	case strings.HasPrefix(route, "/admin/"):
+		return "admin"
+	case strings.HasPrefix(route, "/logs"), route == "/anomalies", route == "/patterns":
+		return "search"
+	}
+	return ""
+}
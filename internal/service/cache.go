@@ -1,63 +1,292 @@
 package service
 
 import (
+	"container/list"
 	"sync"
 	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
 )
 
+// entryOverhead approximates the bookkeeping cost of one entry on top of its
+// key and value, so tiny entries still count against the byte budget.
+const entryOverhead = 64
+
+type CacheOptions struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	MaxEntries  int
+	MaxBytes    int64
+}
+
+// TTLCache is an LRU cache bounded by entry count and bytes. Besides found
+// log lines it remembers misses for a shorter negative TTL.
 type TTLCache struct {
-	cache map[string]cacheEntry
-	mutex sync.RWMutex
-	ttl   time.Duration
+	opts    CacheOptions
+	entries map[string]*list.Element
+	lruList *list.List
+	bytes   int64
+	mutex   sync.Mutex
+	done    chan struct{}
+	rearm   chan struct{}
+	once    sync.Once
+	// generation is the index generation of the last invalidation.
+	generation uint64
+
+	hits          uint64
+	negativeHits  uint64
+	misses        uint64
+	evictions     uint64
+	invalidations uint64
 }
 
 type cacheEntry struct {
+	key        string
+	at         time.Time
 	value      string
+	notFound   bool
 	expiration time.Time
 }
 
-func NewTTLCache(ttl time.Duration) *TTLCache {
+type cachedResult struct {
+	value    string
+	notFound bool
+}
+
+func NewTTLCache(opts CacheOptions) *TTLCache {
 	c := &TTLCache{
-		cache: make(map[string]cacheEntry),
-		ttl:   ttl,
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lruList: list.New(),
+		done:    make(chan struct{}),
+		rearm:   make(chan struct{}, 1),
 	}
 	go c.cleanup()
 	return c
 }
 
-func (c *TTLCache) Get(key string) (string, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+// Close stops the cleanup of expired entries.
+func (c *TTLCache) Close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+func (c *TTLCache) Get(key string) (cachedResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	entry, exists := c.cache[key]
-	if !exists || time.Now().After(entry.expiration) {
-		return "", false
+	element, exists := c.entries[key]
+	if !exists {
+		c.misses++
+		return cachedResult{}, false
 	}
-	return entry.value, true
+
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiration) {
+		c.removeElement(element)
+		c.misses++
+		return cachedResult{}, false
+	}
+
+	c.lruList.MoveToFront(element)
+	if entry.notFound {
+		c.negativeHits++
+	} else {
+		c.hits++
+	}
+	return cachedResult{value: entry.value, notFound: entry.notFound}, true
 }
 
-func (c *TTLCache) Set(key, value string) {
+// Generation returns the index generation of the last invalidation.
+func (c *TTLCache) Generation() uint64 {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	return c.generation
+}
 
-	c.cache[key] = cacheEntry{
+// Set stores the answer for key, which is the lookup of timestamp at made
+// at index generation. An answer older than the last invalidation is
+// dropped, as it may be the one the invalidation removed.
+func (c *TTLCache) Set(key string, at time.Time, value string, generation uint64) {
+	c.set(key, at, value, false, generation)
+}
+
+func (c *TTLCache) SetNotFound(key string, at time.Time, generation uint64) {
+	c.set(key, at, "", true, generation)
+}
+
+func (c *TTLCache) set(key string, at time.Time, value string, notFound bool, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	ttl := c.opts.TTL
+	if notFound {
+		ttl = c.opts.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	if element, exists := c.entries[key]; exists {
+		c.removeElement(element)
+	}
+
+	entry := &cacheEntry{
+		key:        key,
+		at:         at,
 		value:      value,
-		expiration: time.Now().Add(c.ttl),
+		notFound:   notFound,
+		expiration: time.Now().Add(ttl),
+	}
+	c.entries[key] = c.lruList.PushFront(entry)
+	c.bytes += entrySize(entry)
+	c.evictOverLimit()
+}
+
+func (c *TTLCache) evictOverLimit() {
+	for c.overLimit() {
+		oldest := c.lruList.Back()
+		if oldest == nil {
+			break
+		}
+		c.removeElement(oldest)
+		c.evictions++
+	}
+}
+
+// Invalidate drops every entry whose timestamp falls into one of ranges
+// after the index changed to generation.
+func (c *TTLCache) Invalidate(generation uint64, ranges []models.TimeRange) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation = generation
+	for element := c.lruList.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*cacheEntry)
+		for _, r := range ranges {
+			if r.Contains(entry.at) {
+				c.removeElement(element)
+				c.invalidations++
+				break
+			}
+		}
+		element = next
 	}
 }
 
+// Flush drops every entry and returns how many there were.
+func (c *TTLCache) Flush() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n := c.lruList.Len()
+	c.entries = make(map[string]*list.Element)
+	c.lruList.Init()
+	c.bytes = 0
+	return n
+}
+
+func (c *TTLCache) Stats() models.ResultCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return models.ResultCacheStats{
+		Entries:       c.lruList.Len(),
+		Bytes:         c.bytes,
+		MaxEntries:    c.opts.MaxEntries,
+		MaxBytes:      c.opts.MaxBytes,
+		Hits:          c.hits,
+		NegativeHits:  c.negativeHits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+	}
+}
+
+func (c *TTLCache) overLimit() bool {
+	if c.opts.MaxEntries > 0 && c.lruList.Len() > c.opts.MaxEntries {
+		return true
+	}
+	return c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes
+}
+
+func (c *TTLCache) removeElement(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.lruList.Remove(element)
+	c.bytes -= entrySize(entry)
+}
+
+// SetOptions applies new TTLs to entries stored from now on and trims the
+// cache to the new limits.
+func (c *TTLCache) SetOptions(opts CacheOptions) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if opts.TTL != c.opts.TTL || opts.NegativeTTL != c.opts.NegativeTTL {
+		select {
+		case c.rearm <- struct{}{}:
+		default:
+		}
+	}
+	c.opts = opts
+	c.evictOverLimit()
+}
+
+func entrySize(entry *cacheEntry) int64 {
+	return int64(len(entry.key) + len(entry.value) + entryOverhead)
+}
+
+// cleanup sweeps expired entries every shortest TTL until Close. It stays
+// idle while caching is off and picks up TTLs changed by SetOptions.
 func (c *TTLCache) cleanup() {
-	ticker := time.NewTicker(c.ttl)
+	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
+	arm := func() {
+		if interval := c.sweepInterval(); interval > 0 {
+			ticker.Reset(interval)
+		} else {
+			ticker.Stop()
+		}
+	}
+	arm()
 
-	for range ticker.C {
-		c.mutex.Lock()
-		now := time.Now()
-		for key, entry := range c.cache {
-			if now.After(entry.expiration) {
-				delete(c.cache, key)
-			}
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep()
+		case <-c.rearm:
+			arm()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *TTLCache) sweepInterval() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	interval := c.opts.TTL
+	if c.opts.NegativeTTL > 0 && (interval <= 0 || c.opts.NegativeTTL < interval) {
+		interval = c.opts.NegativeTTL
+	}
+	return interval
+}
+
+func (c *TTLCache) sweep() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for element := c.lruList.Back(); element != nil; {
+		prev := element.Prev()
+		if now.After(element.Value.(*cacheEntry).expiration) {
+			c.removeElement(element)
 		}
-		c.mutex.Unlock()
+		element = prev
 	}
 }
@@ -2,38 +2,297 @@ package service
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Dor1ma/log-finder/internal/anomaly"
+	"github.com/Dor1ma/log-finder/internal/audit"
 	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/patterns"
+	"github.com/Dor1ma/log-finder/internal/tracing"
 )
 
 var ErrNotFound = models.ErrNotFound
 var timeFormat = "2006-01-02T15:04:05.000"
 
+type Options struct {
+	Cache           CacheOptions
+	QueryTimeout    time.Duration
+	MaxRangeResults int
+	Anomaly         anomaly.Options
+}
+
 type LogService struct {
-	repo  models.LogRepository
-	cache *TTLCache
+	repo      models.LogRepository
+	cache     *TTLCache
+	opts      atomic.Pointer[Options]
+	flights   flightGroup
+	coalesced atomic.Uint64
 }
 
-func NewLogService(repo models.LogRepository, cacheTTL time.Duration) *LogService {
-	return &LogService{
+func NewLogService(repo models.LogRepository, opts Options) *LogService {
+	service := &LogService{
 		repo:  repo,
-		cache: NewTTLCache(cacheTTL),
+		cache: NewTTLCache(opts.Cache),
+	}
+	service.opts.Store(&opts)
+
+	if notifier, ok := repo.(models.IndexNotifier); ok {
+		notifier.SubscribeIndexChanges(service.onIndexChange)
+	}
+
+	return service
+}
+
+// Close stops the background work of the service.
+func (service *LogService) Close() {
+	service.cache.Close()
+}
+
+func (service *LogService) onIndexChange(change models.IndexChange) {
+	service.cache.Invalidate(change.Generation, change.Changed)
+}
+
+func (service *LogService) cacheLookup(ctx context.Context, key string) (cachedResult, bool) {
+	_, span := tracing.Start(ctx, "TTLCache.Get")
+	defer span.End()
+
+	entry, ok := service.cache.Get(key)
+	span.SetAttr("hit", ok)
+	if ok {
+		span.SetAttr("not_found", entry.notFound)
 	}
+	return entry, ok
 }
 
-func (service *LogService) FindLog(ctx context.Context, timestamp time.Time) (string, error) {
+func (service *LogService) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := service.opts.Load().QueryTimeout
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (service *LogService) FindLog(ctx context.Context, timestamp time.Time) (result string, err error) {
+	ctx, span := tracing.Start(ctx, "LogService.FindLog")
+	defer func() {
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
 	cacheKey := timestamp.Format(timeFormat)
+	span.SetAttr("timestamp", cacheKey)
+
+	// Callers that see different sources at this time get different answers,
+	// so the visible sources are part of the key.
+	if access := models.SourceAccessFrom(ctx); access != nil {
+		sources := access.SourcesAt(timestamp)
+		if len(sources) == 0 {
+			return "", ErrNotFound
+		}
+		cacheKey += "|" + strings.Join(sources, ",")
+	}
+
+	if entry, ok := service.cacheLookup(ctx, cacheKey); ok {
+		audit.FromContext(ctx).SetCached()
+		if entry.notFound {
+			return "", ErrNotFound
+		}
+		return entry.value, nil
+	}
+
+	ctx, cancel := service.withDeadline(ctx)
+	defer cancel()
+
+	for {
+		result, err, shared := service.flights.Do(ctx, cacheKey, func() (string, error) {
+			generation := service.cache.Generation()
+			result, source, err := service.repo.FindByTimestamp(ctx, timestamp)
+			if err == nil {
+				audit.FromContext(ctx).AddSources(source)
+			}
+			// A miss past the end of the index turns into a line as soon as
+			// it is written, so it is not remembered.
+			if errors.Is(err, models.ErrPastIndexEnd) {
+				return "", ErrNotFound
+			}
+
+			// An index change during the search may already have invalidated
+			// this key; the cache drops the answer then rather than resurrect
+			// it.
+			if errors.Is(err, ErrNotFound) {
+				service.cache.SetNotFound(cacheKey, timestamp, generation)
+			} else if err == nil {
+				service.cache.Set(cacheKey, timestamp, result, generation)
+			}
+			return result, err
+		})
+		if !shared {
+			return result, err
+		}
+
+		service.coalesced.Add(1)
+		span.SetAttr("coalesced", true)
+		audit.FromContext(ctx).SetCached()
+		// The search we joined was cancelled by its own caller; run it again
+		// unless this request has been cancelled too.
+		if isContextError(err) && ctx.Err() == nil {
+			continue
+		}
+		return result, err
+	}
+}
+
+// FindRange returns the lines of query; its limit is capped by
+// MaxRangeResults. Range results are not cached.
+func (service *LogService) FindRange(ctx context.Context, query models.RangeQuery) (models.RangeResult, error) {
+	if maxResults := service.opts.Load().MaxRangeResults; maxResults > 0 && (query.Limit <= 0 || query.Limit > maxResults) {
+		query.Limit = maxResults
+	}
+
+	ctx, cancel := service.withDeadline(ctx)
+	defer cancel()
+
+	result, err := service.repo.FindRange(ctx, query)
+	audit.FromContext(ctx).AddSources(result.Sources...)
+	return result, err
+}
+
+func (service *LogService) CountRange(ctx context.Context, query models.RangeQuery) (models.CountResult, error) {
+	ctx, cancel := service.withDeadline(ctx)
+	defer cancel()
+
+	result, err := service.repo.CountRange(ctx, query)
+	audit.FromContext(ctx).AddSources(result.Sources...)
+	return result, err
+}
 
-	if entry, ok := service.cache.Get(cacheKey); ok {
-		return entry, nil
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// anomalyWarmup is how many baselines of counts before the requested range
+// feed the moving averages, so the first minutes of the range are judged
+// against a settled baseline.
+const anomalyWarmup = 3
+
+// Anomalies flags the minutes in [from, to] where a source logged unusually
+// many or few lines, or an unusual share of errors.
+func (service *LogService) Anomalies(ctx context.Context, from, to time.Time) (anomaly.Report, error) {
+	counter, ok := service.repo.(models.MinuteCounter)
+	if !ok {
+		return anomaly.Report{}, models.ErrNotSupported
+	}
+
+	ctx, span := tracing.Start(ctx, "LogService.Anomalies")
+	defer span.End()
+
+	opts := service.opts.Load().Anomaly
+	series, err := counter.MinuteCounts(ctx, from.Add(-anomalyWarmup*opts.Baseline), to)
+	if err != nil {
+		span.RecordError(err)
+		return anomaly.Report{}, err
+	}
+	return anomaly.Detect(series, from, to, opts), nil
+}
+
+// Patterns reports the message patterns of the lines logged in [from, to],
+// counted per window when window is set.
+func (service *LogService) Patterns(ctx context.Context, from, to time.Time, window time.Duration, onlyNew bool) (patterns.Report, error) {
+	counter, ok := service.repo.(models.PatternCounter)
+	if !ok {
+		return patterns.Report{}, models.ErrNotSupported
 	}
 
-	result, err := service.repo.FindByTimestamp(ctx, timestamp)
+	ctx, span := tracing.Start(ctx, "LogService.Patterns")
+	defer span.End()
+
+	counts, err := counter.PatternCounts(ctx, from, to)
 	if err != nil {
-		return "", err
+		span.RecordError(err)
+		return patterns.Report{}, err
 	}
+	return patterns.Summarize(counts, from, to, window, onlyNew), nil
+}
+
+func (service *LogService) CacheStats() models.ResultCacheStats {
+	stats := service.cache.Stats()
+	stats.Coalesced = service.coalesced.Load()
+	stats.Generation = service.cache.Generation()
+	return stats
+}
+
+// Reconfigure applies new cache settings and query limits to later
+// requests.
+func (service *LogService) Reconfigure(opts Options) {
+	service.cache.SetOptions(opts.Cache)
+	service.opts.Store(&opts)
+}
+
+func (service *LogService) Refresh(ctx context.Context) error {
+	return service.repo.RefreshMetadata(ctx)
+}
 
-	service.cache.Set(cacheKey, result)
-	return result, nil
+// FlushCache empties the result cache and returns the number of dropped
+// entries.
+func (service *LogService) FlushCache() int {
+	return service.cache.Flush()
+}
+
+func (service *LogService) ReindexFile(ctx context.Context, path string) (models.IndexedFile, error) {
+	admin, ok := service.repo.(models.IndexAdmin)
+	if !ok {
+		return models.IndexedFile{}, models.ErrNotSupported
+	}
+	return admin.ReindexFile(ctx, path)
+}
+
+func (service *LogService) EvictFile(ctx context.Context, path string) (bool, error) {
+	admin, ok := service.repo.(models.IndexAdmin)
+	if !ok {
+		return false, models.ErrNotSupported
+	}
+	return admin.EvictFile(ctx, path)
+}
+
+func (service *LogService) SetRefreshInterval(ctx context.Context, interval time.Duration) error {
+	admin, ok := service.repo.(models.IndexAdmin)
+	if !ok {
+		return models.ErrNotSupported
+	}
+	return admin.SetRefreshInterval(ctx, interval)
+}
+
+// IndexStatus reports the index, leaving out the files of sources the
+// caller may not see.
+func (service *LogService) IndexStatus(ctx context.Context) (models.IndexStatus, bool) {
+	provider, ok := service.repo.(models.IndexStatusProvider)
+	if !ok {
+		return models.IndexStatus{}, false
+	}
+	status := provider.IndexStatus()
+	if access := models.SourceAccessFrom(ctx); access != nil {
+		status.Files = slices.DeleteFunc(status.Files, func(f models.IndexedFile) bool { return !access.Includes(f.Source) })
+		status.Skipped = slices.DeleteFunc(status.Skipped, func(f models.SkippedFile) bool { return !access.Includes(f.Source) })
+	}
+	return status, true
+}
+
+// FileCacheStats reports the file cache, leaving out the files of sources
+// the caller may not see. Totals still cover the whole cache.
+func (service *LogService) FileCacheStats(ctx context.Context) (models.FileCacheStats, bool) {
+	provider, ok := service.repo.(models.FileCacheStatsProvider)
+	if !ok {
+		return models.FileCacheStats{}, false
+	}
+	stats := provider.FileCacheStats()
+	if access := models.SourceAccessFrom(ctx); access != nil {
+		stats.Files = slices.DeleteFunc(stats.Files, func(f models.FileCacheEntryStats) bool { return !access.Includes(f.Source) })
+	}
+	return stats, true
 }
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingRepository struct {
+	calls  atomic.Int32
+	result string
+	err    error
+	delay  time.Duration
+}
+
+func (m *countingRepository) RefreshMetadata(ctx context.Context) error {
+	return nil
+}
+
+func (m *countingRepository) FindByTimestamp(ctx context.Context, t time.Time) (string, string, error) {
+	m.calls.Add(1)
+	select {
+	case <-time.After(m.delay):
+		return m.result, models.DefaultSource, m.err
+	case <-ctx.Done():
+		return "", "", ctx.Err()
+	}
+}
+
+func (m *countingRepository) FindRange(ctx context.Context, query models.RangeQuery) (models.RangeResult, error) {
+	return models.RangeResult{}, m.err
+}
+
+func (m *countingRepository) CountRange(ctx context.Context, query models.RangeQuery) (models.CountResult, error) {
+	return models.CountResult{}, m.err
+}
+
+func TestLogServiceNegativeCache(t *testing.T) {
+	repo := &countingRepository{err: models.ErrNotFound}
+	svc := NewLogService(repo, Options{Cache: CacheOptions{TTL: time.Minute, NegativeTTL: time.Minute}})
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.FindLog(context.Background(), time.Unix(0, 0))
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+
+	assert.Equal(t, int32(1), repo.calls.Load())
+	assert.Equal(t, uint64(2), svc.CacheStats().NegativeHits)
+
+	repo.err = models.ErrPastIndexEnd
+	for i := 0; i < 2; i++ {
+		_, err := svc.FindLog(context.Background(), time.Unix(60, 0))
+		assert.Equal(t, ErrNotFound, err)
+	}
+	assert.Equal(t, int32(3), repo.calls.Load(), "misses past the index end are not cached")
+}
+
+type notifyingRepository struct {
+	countingRepository
+	subscriber func(models.IndexChange)
+}
+
+func (m *notifyingRepository) SubscribeIndexChanges(fn func(models.IndexChange)) {
+	m.subscriber = fn
+}
+
+func TestLogServiceIndexInvalidation(t *testing.T) {
+	repo := &notifyingRepository{countingRepository: countingRepository{err: models.ErrNotFound}}
+	svc := NewLogService(repo, Options{Cache: CacheOptions{TTL: time.Minute, NegativeTTL: time.Minute}})
+	require.NotNil(t, repo.subscriber)
+
+	inside := time.Date(2023, 1, 1, 0, 0, 1, 0, time.UTC)
+	outside := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	for _, ts := range []time.Time{inside, outside} {
+		_, err := svc.FindLog(context.Background(), ts)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	require.Equal(t, int32(2), repo.calls.Load())
+
+	repo.err = nil
+	repo.result = "appeared"
+	repo.subscriber(models.IndexChange{
+		Generation: 1,
+		Changed: []models.TimeRange{{
+			Start: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2023, 1, 1, 0, 0, 2, 0, time.UTC),
+		}},
+	})
+
+	result, err := svc.FindLog(context.Background(), inside)
+	require.NoError(t, err)
+	assert.Equal(t, "appeared", result)
+
+	_, err = svc.FindLog(context.Background(), outside)
+	assert.ErrorIs(t, err, ErrNotFound, "keys outside the changed range stay cached")
+	assert.Equal(t, int32(3), repo.calls.Load())
+	assert.Equal(t, uint64(1), svc.CacheStats().Invalidations)
+
+	stale := svc.cache.Generation()
+	repo.subscriber(models.IndexChange{Generation: 2})
+	svc.cache.Set("late", inside, "stale", stale)
+	_, ok := svc.cache.Get("late")
+	assert.False(t, ok, "answers found before an index change are not stored after it")
+}
+
+func TestLogServiceCoalescing(t *testing.T) {
+	repo := &countingRepository{result: "line", delay: 50 * time.Millisecond}
+	svc := NewLogService(repo, Options{Cache: CacheOptions{TTL: time.Minute}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := svc.FindLog(context.Background(), time.Unix(0, 0))
+			assert.NoError(t, err)
+			assert.Equal(t, "line", result)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), repo.calls.Load())
+	stats := svc.CacheStats()
+	assert.Equal(t, uint64(9), stats.Coalesced+stats.Hits)
+}
+
+func TestLogServiceCoalescedWaiterDeadline(t *testing.T) {
+	repo := &countingRepository{result: "line", delay: time.Second}
+	svc := NewLogService(repo, Options{Cache: CacheOptions{TTL: time.Minute}})
+	defer svc.Close()
+
+	leader, cancelLeader := context.WithCancel(context.Background())
+	defer cancelLeader()
+	go svc.FindLog(leader, time.Unix(0, 0))
+	require.Eventually(t, func() bool { return repo.calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := svc.FindLog(ctx, time.Unix(0, 0))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "a waiter stops at its own deadline")
+	assert.Equal(t, int32(1), repo.calls.Load())
+}
+
+func TestTTLCacheBounds(t *testing.T) {
+	cache := NewTTLCache(CacheOptions{TTL: time.Minute, MaxEntries: 3})
+
+	for i := 0; i < 5; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), time.Unix(int64(i), 0), "value", 0)
+	}
+
+	_, ok := cache.Get("key0")
+	assert.False(t, ok, "least recently used entry should be evicted")
+	_, ok = cache.Get("key4")
+	assert.True(t, ok)
+
+	stats := cache.Stats()
+	assert.Equal(t, 3, stats.Entries)
+	assert.Equal(t, uint64(2), stats.Evictions)
+
+	bytesCache := NewTTLCache(CacheOptions{TTL: time.Minute, MaxBytes: 2 * (entryOverhead + 10)})
+	bytesCache.Set("k1", time.Unix(1, 0), "12345678", 0)
+	bytesCache.Set("k2", time.Unix(2, 0), "12345678", 0)
+	bytesCache.Set("k3", time.Unix(3, 0), "12345678", 0)
+	require.Equal(t, 2, bytesCache.Stats().Entries)
+	assert.LessOrEqual(t, bytesCache.Stats().Bytes, int64(2*(entryOverhead+10)))
+}
+
+func TestTTLCacheCleanupFollowsOptions(t *testing.T) {
+	cache := NewTTLCache(CacheOptions{})
+	defer cache.Close()
+
+	cache.SetOptions(CacheOptions{TTL: 10 * time.Millisecond})
+	cache.Set("key", time.Unix(0, 0), "value", 0)
+	require.Equal(t, 1, cache.Stats().Entries)
+	assert.Eventually(t, func() bool { return cache.Stats().Entries == 0 }, time.Second, 5*time.Millisecond,
+		"expired entries are swept once caching is turned on")
+}
+
+func TestLogServiceCacheKeyedBySourceAccess(t *testing.T) {
+	repo := &countingRepository{result: "line"}
+	service := NewLogService(repo, Options{Cache: CacheOptions{TTL: time.Minute, MaxEntries: 10}})
+	timestamp := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	disk := models.WithSourceAccess(context.Background(), models.SourceAccess{"disk": {{}}})
+	payments := models.WithSourceAccess(context.Background(), models.SourceAccess{"payments": {{}}})
+	expired := models.WithSourceAccess(context.Background(), models.SourceAccess{
+		"payments": {{End: timestamp.Add(-time.Hour)}},
+	})
+
+	for _, ctx := range []context.Context{disk, disk, payments, context.Background()} {
+		_, err := service.FindLog(ctx, timestamp)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), repo.calls.Load())
+
+	_, err := service.FindLog(expired, timestamp)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int32(3), repo.calls.Load(), "nothing visible means no search")
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+type call struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// flightGroup runs at most one search per key; concurrent callers with the
+// same key wait for it and share its result.
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*call
+}
+
+// Do runs fn for key unless a call for it is in flight. A caller waiting on
+// another call gives up with its own context error when ctx ends first.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (string, error)) (string, error, bool) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		select {
+		case <-c.done:
+			return c.value, c.err, true
+		case <-ctx.Done():
+			return "", ctx.Err(), true
+		}
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mutex.Unlock()
+
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(c.done)
+	}()
+
+	c.value, c.err = fn()
+	return c.value, c.err, false
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+// ReindexFile indexes one file again right away, or drops it from the index
+// when it no longer exists.
+func (r *LogRepository) ReindexFile(ctx context.Context, path string) (models.IndexedFile, error) {
+	path, err := r.resolvePath(path)
+	if err != nil {
+		return models.IndexedFile{}, err
+	}
+
+	info, statErr := os.Stat(path)
+	if statErr == nil && info.IsDir() {
+		return models.IndexedFile{}, fmt.Errorf("%w: %v", models.ErrFileSkipped, errNotRegular)
+	}
+
+	rel, err := filepath.Rel(r.logDir, path)
+	if err != nil {
+		return models.IndexedFile{}, err
+	}
+	r.refreshFiles(ctx, []string{rel})
+	slog.InfoContext(ctx, "File reindexed on request", "path", path)
+
+	r.indexMutex.RLock()
+	defer r.indexMutex.RUnlock()
+
+	if skipped, ok := r.skipped[path]; ok {
+		return models.IndexedFile{}, fmt.Errorf("%w: %s", models.ErrFileSkipped, skipped.Reason)
+	}
+	for _, meta := range r.fileIndex {
+		if meta.path == path {
+			return meta.indexedFile(), nil
+		}
+	}
+	if statErr != nil {
+		return models.IndexedFile{}, statErr
+	}
+	return models.IndexedFile{}, os.ErrNotExist
+}
+
+// EvictFile closes the cached reader of path; searches still using it keep
+// it until they finish.
+func (r *LogRepository) EvictFile(ctx context.Context, path string) (bool, error) {
+	path, err := r.resolvePath(path)
+	if err != nil {
+		return false, err
+	}
+
+	evicted := r.fileCache.Evict(path)
+	slog.InfoContext(ctx, "File evicted from cache on request", "path", path, "evicted", evicted)
+	return evicted, nil
+}
+
+func (r *LogRepository) SetRefreshInterval(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("refresh interval must be positive")
+	}
+
+	select {
+	case <-r.done:
+		return errors.New("repository is closed")
+	default:
+	}
+	// The periodic loop may be busy refreshing; it reads the latest interval
+	// once it gets to the pending signal.
+	r.refreshInterval.Store(int64(interval))
+	select {
+	case r.intervalChanged <- struct{}{}:
+	default:
+	}
+	slog.InfoContext(ctx, "Refresh interval changed", "interval", interval)
+	return nil
+}
+
+func (r *LogRepository) SetFileCacheTTL(ttl time.Duration) {
+	r.fileCache.SetTTL(ttl)
+}
+
+// resolvePath maps an absolute path or a name relative to the log directory
+// to the path used in the index, rejecting anything that is not a file of
+// the directory or of one of its source subdirectories.
+func (r *LogRepository) resolvePath(path string) (string, error) {
+	if path == "" {
+		return "", models.ErrOutsideLogDir
+	}
+
+	dir, err := filepath.Abs(r.logDir)
+	if err != nil {
+		return "", err
+	}
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(dir, abs)
+	}
+	abs = filepath.Clean(abs)
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) ||
+		strings.Count(rel, string(filepath.Separator)) > 1 {
+		return "", models.ErrOutsideLogDir
+	}
+
+	return filepath.Join(r.logDir, rel), nil
+}
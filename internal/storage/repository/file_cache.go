@@ -2,80 +2,444 @@ package repository
 
 import (
 	"container/list"
+	"context"
+	"log/slog"
 	"os"
+	"runtime/debug"
 	"sync"
 	"time"
+	"unsafe"
 
+	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/tracing"
 	"github.com/Dor1ma/log-finder/pkg/mmap"
+	"github.com/Dor1ma/log-finder/pkg/reader"
 )
 
+const (
+	evictionSample        = 4
+	residentCheckInterval = time.Second
+)
+
+type accessPattern int
+
+const (
+	accessRandom accessPattern = iota
+	accessSequential
+)
+
+func (a accessPattern) String() string {
+	if a == accessSequential {
+		return "sequential"
+	}
+	return "random"
+}
+
 type fileCache struct {
-	maxSize int
-	ttl     time.Duration
-	cache   map[string]*cacheEntry
-	lruList *list.List
-	mutex   sync.Mutex
+	maxSize          int
+	maxMappedBytes   int64
+	maxResidentBytes int64
+	ttl              time.Duration
+	mode             reader.Mode
+	// modeOf, when set, picks the reader mode per path instead of mode.
+	modeOf            func(path string) reader.Mode
+	cache             map[fileID]*cacheEntry
+	lruList           *list.List
+	cachedBytes       int64
+	mappedBytes       int64
+	lastResidentCheck time.Time
+	hits              uint64
+	misses            uint64
+	mutex             sync.Mutex
 }
 
+// cacheEntry owns one open reader. Entries removed from the cache while
+// leases are outstanding are only marked evicted; the last Release closes
+// them. mapped is the reader's mapping, or nil when it uses pread.
 type cacheEntry struct {
-	path      string
-	data      []byte
-	expiresAt time.Time
-	element   *list.Element
+	id         fileID
+	path       string
+	size       int64
+	reader     reader.FileReader
+	mapped     []byte
+	access     accessPattern
+	hits       int64
+	lastAccess time.Time
+	expiresAt  time.Time
+	element    *list.Element
+	refs       int
+	evicted    bool
+}
+
+type fileLease struct {
+	cache *fileCache
+	entry *cacheEntry
+	once  sync.Once
 }
 
-func NewFileCache(maxSize int, ttl time.Duration) *fileCache {
+func (l *fileLease) Reader() reader.FileReader {
+	return l.entry.reader
+}
+
+// readMapped runs fn, which reads the leased reader. The lease keeps the
+// mapping in place, so a fault inside it can only come from the file being
+// truncated under it; that fault is returned as errMappingInvalidated. Any
+// other fault is a bug and still crashes the process.
+func (l *fileLease) readMapped(fn func() error) (err error) {
+	mapped := l.entry.mapped
+	if len(mapped) == 0 {
+		return fn()
+	}
+	start := uintptr(unsafe.Pointer(&mapped[0]))
+	end := start + uintptr(len(mapped))
+
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		fault, ok := rec.(interface{ Addr() uintptr })
+		if !ok || fault.Addr() < start || fault.Addr() >= end {
+			panic(rec)
+		}
+		err = errMappingInvalidated
+	}()
+
+	return fn()
+}
+
+func (l *fileLease) Release() {
+	l.once.Do(func() {
+		l.cache.release(l.entry)
+	})
+}
+
+// NewFileCache limits the cache by number of open files and, when
+// maxMappedBytes or maxResidentBytes are positive, by mapped address space
+// and resident memory.
+func NewFileCache(maxSize int, maxMappedBytes, maxResidentBytes int64, ttl time.Duration, mode reader.Mode) *fileCache {
 	return &fileCache{
-		maxSize: maxSize,
-		ttl:     ttl,
-		cache:   make(map[string]*cacheEntry),
-		lruList: list.New(),
+		maxSize:          maxSize,
+		maxMappedBytes:   maxMappedBytes,
+		maxResidentBytes: maxResidentBytes,
+		ttl:              ttl,
+		mode:             mode,
+		cache:            make(map[fileID]*cacheEntry),
+		lruList:          list.New(),
 	}
 }
 
-func (c *fileCache) Get(path string) ([]byte, error) {
+// Get returns a lease on the reader of path. The reader stays valid until
+// the lease is released, even if the entry is evicted in the meantime.
+func (c *fileCache) Get(ctx context.Context, path string, access accessPattern) (lease *fileLease, err error) {
+	ctx, span := tracing.Start(ctx, "fileCache.Get")
+	span.SetAttr("path", path)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	id := statFileID(info)
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if entry, exists := c.cache[path]; exists {
-		if time.Now().After(entry.expiresAt) {
+	if entry, exists := c.cache[id]; exists {
+		switch {
+		case time.Now().After(entry.expiresAt):
+			// An expired mapping is dropped and the file mapped again below.
+			c.removeEntry(entry)
+		case entry.size == info.Size():
+			file.Close()
+			c.hits++
+			span.SetAttr("hit", true)
+			entry.path = path
+			entry.hits++
+			entry.lastAccess = time.Now()
+			if entry.access != access {
+				c.advise(ctx, entry, access)
+			}
+			c.lruList.MoveToFront(entry.element)
+			return c.lease(entry), nil
+		default:
+			// The file grew or was truncated: map it again at its current
+			// size so appended lines become visible immediately. The old
+			// mapping stays until its last lease is released.
+			if info.Size() < entry.size {
+				slog.InfoContext(ctx, "File was truncated, dropping its mapping", "path", path)
+			}
 			c.removeEntry(entry)
-			return nil, os.ErrNotExist
 		}
-		c.lruList.MoveToFront(entry.element)
-		return entry.data, nil
 	}
 
-	data, err := mmap.MapFile(path)
+	c.misses++
+	span.SetAttr("hit", false)
+	mode := c.mode
+	if c.modeOf != nil {
+		mode = c.modeOf(path)
+	}
+	r, err := reader.Open(file, info.Size(), mode)
 	if err != nil {
 		return nil, err
 	}
 
 	entry := &cacheEntry{
-		path:      path,
-		data:      data,
-		expiresAt: time.Now().Add(c.ttl),
-		element:   c.lruList.PushFront(path),
+		id:         id,
+		path:       path,
+		size:       info.Size(),
+		reader:     r,
+		lastAccess: time.Now(),
+		expiresAt:  time.Now().Add(c.ttl),
+	}
+	if m, ok := r.(*reader.MmapReader); ok {
+		entry.mapped = m.Bytes()
+	} else if mode != reader.ModePread {
+		slog.InfoContext(ctx, "File cannot be memory mapped, reading it with pread", "path", path)
+	}
+	c.advise(ctx, entry, access)
+	entry.element = c.lruList.PushFront(entry)
+
+	c.cache[id] = entry
+	c.cachedBytes += int64(len(entry.mapped))
+	c.mappedBytes += int64(len(entry.mapped))
+	lease = c.lease(entry)
+	span.SetAttr("reader", r.Kind())
+
+	c.enforceLimits()
+	if leases := c.residentLeases(); leases != nil {
+		go c.trimResident(context.WithoutCancel(ctx), leases)
+	}
+
+	return lease, nil
+}
+
+func (c *fileCache) advise(ctx context.Context, entry *cacheEntry, access accessPattern) {
+	var err error
+	if access == accessSequential {
+		err = mmap.AdviseSequential(entry.mapped)
+	} else {
+		err = mmap.AdviseRandom(entry.mapped)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "madvise failed", "path", entry.path, "access", access.String(), "error", err)
+	}
+	entry.access = access
+}
+
+func (c *fileCache) lease(entry *cacheEntry) *fileLease {
+	entry.refs++
+	return &fileLease{cache: c, entry: entry}
+}
+
+func (c *fileCache) release(entry *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry.refs--
+	if entry.refs == 0 && entry.evicted {
+		c.close(entry)
+	}
+}
+
+// Retain drops mappings of files that are no longer part of the index.
+func (c *fileCache) Retain(live map[fileID]struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for id, entry := range c.cache {
+		if _, ok := live[id]; !ok {
+			c.removeEntry(entry)
+		}
+	}
+}
+
+// SetTTL changes how long files opened from now on stay cached.
+func (c *fileCache) SetTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ttl = ttl
+}
+
+// Evict drops the entries of path and reports whether there were any.
+// Leased readers stay valid until released.
+func (c *fileCache) Evict(path string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	evicted := false
+	for _, entry := range c.cache {
+		if entry.path == path {
+			c.removeEntry(entry)
+			evicted = true
+		}
+	}
+	return evicted
+}
+
+func (c *fileCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, entry := range c.cache {
+		c.removeEntry(entry)
+	}
+
+	c.cache = make(map[fileID]*cacheEntry)
+	c.lruList.Init()
+}
+
+// Stats leases every entry while it holds the mutex and asks the kernel
+// which pages are resident only after releasing it, so a scrape does not
+// stall searches on mincore.
+func (c *fileCache) Stats() models.FileCacheStats {
+	c.mutex.Lock()
+	stats := models.FileCacheStats{
+		Entries:          len(c.cache),
+		MaxOpenFiles:     c.maxSize,
+		CachedBytes:      c.cachedBytes,
+		MappedBytes:      c.mappedBytes,
+		MaxMappedBytes:   c.maxMappedBytes,
+		MaxResidentBytes: c.maxResidentBytes,
+		Hits:             c.hits,
+		Misses:           c.misses,
+		Files:            make([]models.FileCacheEntryStats, 0, len(c.cache)),
+	}
+	leases := make([]*fileLease, 0, len(c.cache))
+	for e := c.lruList.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*cacheEntry)
+		stats.Files = append(stats.Files, models.FileCacheEntryStats{
+			Path:       entry.path,
+			Size:       entry.size,
+			Leases:     entry.refs,
+			Hits:       entry.hits,
+			Reader:     entry.reader.Kind(),
+			Access:     entry.access.String(),
+			LastAccess: entry.lastAccess,
+			ExpiresAt:  entry.expiresAt,
+		})
+		leases = append(leases, c.lease(entry))
+	}
+	c.mutex.Unlock()
+
+	for i, lease := range leases {
+		resident, _ := mmap.Resident(lease.entry.mapped)
+		stats.ResidentBytes += resident
+		stats.Files[i].ResidentBytes = resident
+		lease.Release()
+	}
+
+	return stats
+}
+
+func (c *fileCache) enforceLimits() {
+	for len(c.cache) > c.maxSize || (c.maxMappedBytes > 0 && c.cachedBytes > c.maxMappedBytes) {
+		victim := c.pickVictim()
+		if victim == nil {
+			return
+		}
+		c.removeEntry(victim)
+	}
+}
+
+// pickVictim looks at the least recently used entries and evicts the one
+// holding the most bytes for the longest time, so one idle huge file goes
+// before several small ones that are still in use. The most recently used
+// entry is only chosen when it alone exceeds the budget.
+func (c *fileCache) pickVictim() *cacheEntry {
+	if c.lruList.Len() == 1 {
+		return c.lruList.Front().Value.(*cacheEntry)
 	}
 
-	c.cache[path] = entry
+	var victim *cacheEntry
+	var victimScore float64
 
-	if len(c.cache) > c.maxSize {
-		c.evictOldest()
+	now := time.Now()
+	e := c.lruList.Back()
+	for i := 0; e != c.lruList.Front() && i < evictionSample; i++ {
+		entry := e.Value.(*cacheEntry)
+		score := float64(entry.size) * (now.Sub(entry.lastAccess).Seconds() + 1)
+		if victim == nil || score > victimScore {
+			victim, victimScore = entry, score
+		}
+		e = e.Prev()
 	}
 
-	return data, nil
+	return victim
 }
 
-func (c *fileCache) evictOldest() {
-	oldest := c.lruList.Back()
-	if oldest != nil {
-		c.removeEntry(c.cache[oldest.Value.(string)])
+// residentLeases leases every entry, least recently used first, when the
+// cache may hold more resident memory than allowed and was not checked
+// lately. The caller holds the mutex.
+func (c *fileCache) residentLeases() []*fileLease {
+	if c.maxResidentBytes <= 0 || c.cachedBytes <= c.maxResidentBytes ||
+		time.Since(c.lastResidentCheck) < residentCheckInterval {
+		return nil
+	}
+	c.lastResidentCheck = time.Now()
+
+	leases := make([]*fileLease, 0, len(c.cache))
+	for e := c.lruList.Back(); e != nil; e = e.Prev() {
+		leases = append(leases, c.lease(e.Value.(*cacheEntry)))
+	}
+	return leases
+}
+
+// trimResident drops the pages of the least recently used mappings among
+// leases when they hold more resident memory than allowed, and releases
+// the leases. It runs without the mutex, as mincore is slow on large
+// mappings; mappings stay valid, so dropping pages is safe while leased.
+func (c *fileCache) trimResident(ctx context.Context, leases []*fileLease) {
+	resident := make([]int64, len(leases))
+	var total int64
+	for i, lease := range leases {
+		resident[i], _ = mmap.Resident(lease.entry.mapped)
+		total += resident[i]
+	}
+
+	for i, lease := range leases {
+		if total <= c.maxResidentBytes {
+			break
+		}
+		if resident[i] == 0 {
+			continue
+		}
+		if err := mmap.Drop(lease.entry.mapped); err != nil {
+			c.mutex.Lock()
+			path := lease.entry.path
+			c.mutex.Unlock()
+			slog.WarnContext(ctx, "Failed to drop resident pages", "path", path, "error", err)
+			continue
+		}
+		total -= resident[i]
+	}
+
+	for _, lease := range leases {
+		lease.Release()
 	}
 }
 
 func (c *fileCache) removeEntry(entry *cacheEntry) {
-	delete(c.cache, entry.path)
+	delete(c.cache, entry.id)
 	c.lruList.Remove(entry.element)
-	mmap.Unmap(entry.data)
+	c.cachedBytes -= int64(len(entry.mapped))
+	entry.evicted = true
+	if entry.refs == 0 {
+		c.close(entry)
+	}
+}
+
+func (c *fileCache) close(entry *cacheEntry) {
+	entry.reader.Close()
+	c.mappedBytes -= int64(len(entry.mapped))
 }
@@ -1,11 +1,17 @@
 package repository
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Dor1ma/log-finder/pkg/reader"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,31 +25,215 @@ func TestFileCache(t *testing.T) {
 		}
 		filePath := createTestLogFileForCache(t, tmpDir, "test_cache.log", lines)
 
-		cache := NewFileCache(2, time.Minute)
+		cache := NewFileCache(2, 0, 0, time.Minute, reader.ModeAuto)
 
-		data, err := cache.Get(filePath)
+		lease, err := cache.Get(context.Background(), filePath, accessRandom)
 		require.NoError(t, err)
-		assert.Contains(t, string(data), "line1")
-		assert.Contains(t, string(data), "line2")
+		defer lease.Release()
+		assert.Contains(t, readAll(t, lease), "line1")
+		assert.Contains(t, readAll(t, lease), "line2")
 
-		cachedData, err := cache.Get(filePath)
+		cached, err := cache.Get(context.Background(), filePath, accessRandom)
 		assert.NoError(t, err)
-		assert.Equal(t, data, cachedData, "Cached data should match original")
+		defer cached.Release()
+		assert.Equal(t, readAll(t, lease), readAll(t, cached), "Cached data should match original")
 	})
 
 	t.Run("TTL expiration", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		filePath := createTestLogFileForCache(t, tmpDir, "test_ttl.log", []string{"2023-01-01T00:00:00.000 line1"})
 
-		cache := NewFileCache(2, time.Microsecond)
-		_, err := cache.Get(filePath)
+		cache := NewFileCache(2, 0, 0, time.Microsecond, reader.ModeAuto)
+		lease, err := cache.Get(context.Background(), filePath, accessRandom)
 		require.NoError(t, err)
+		lease.Release()
 
 		time.Sleep(time.Millisecond)
 
-		_, err = cache.Get(filePath)
-		assert.ErrorIs(t, err, os.ErrNotExist, "Cache entry should expire")
+		lease, err = cache.Get(context.Background(), filePath, accessRandom)
+		require.NoError(t, err, "an expired entry is mapped again")
+		defer lease.Release()
+		assert.Contains(t, readAll(t, lease), "line1")
+		assert.Equal(t, uint64(2), cache.Stats().Misses)
 	})
+
+	t.Run("evicted mapping stays valid while leased", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		first := createTestLogFileForCache(t, tmpDir, "first.log", []string{"2023-01-01T00:00:00.000 first"})
+		second := createTestLogFileForCache(t, tmpDir, "second.log", []string{"2023-01-01T00:00:01.000 second"})
+
+		cache := NewFileCache(1, 0, 0, time.Minute, reader.ModeAuto)
+
+		lease, err := cache.Get(context.Background(), first, accessRandom)
+		require.NoError(t, err)
+
+		other, err := cache.Get(context.Background(), second, accessRandom)
+		require.NoError(t, err)
+		other.Release()
+
+		assert.Len(t, cache.cache, 1)
+		assert.Contains(t, readAll(t, lease), "first")
+		lease.Release()
+	})
+
+	t.Run("remapped file stays valid while leased", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		filePath := createTestLogFileForCache(t, tmpDir, "grow.log", []string{"2023-01-01T00:00:00.000 first"})
+
+		cache := NewFileCache(2, 0, 0, time.Minute, reader.ModeAuto)
+
+		lease, err := cache.Get(context.Background(), filePath, accessRandom)
+		require.NoError(t, err)
+
+		f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString("2023-01-01T00:00:01.000 second\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		grown, err := cache.Get(context.Background(), filePath, accessRandom)
+		require.NoError(t, err)
+		defer grown.Release()
+
+		assert.Contains(t, readAll(t, grown), "second")
+		err = lease.readMapped(func() error {
+			assert.Equal(t, "2023-01-01T00:00:00.000 first\n", readAll(t, lease))
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, cache.Stats().Entries)
+		lease.Release()
+	})
+
+	t.Run("truncated mapping fails the read", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		filePath := createTestLogFileForCache(t, tmpDir, "truncated.log", []string{"2023-01-01T00:00:00.000 first"})
+
+		cache := NewFileCache(2, 0, 0, time.Minute, reader.ModeMmap)
+		lease, err := cache.Get(context.Background(), filePath, accessRandom)
+		require.NoError(t, err)
+		defer lease.Release()
+
+		require.NoError(t, os.Truncate(filePath, 0))
+		err = lease.readMapped(func() error {
+			readAll(t, lease)
+			return nil
+		})
+		assert.ErrorIs(t, err, errMappingInvalidated)
+	})
+}
+
+func TestFileCacheByteBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+	small := createTestLogFileForCache(t, tmpDir, "small.log", []string{"2023-01-01T00:00:00.000 small"})
+	other := createTestLogFileForCache(t, tmpDir, "other.log", []string{"2023-01-01T00:00:01.000 other"})
+	large := createTestLogFileForCache(t, tmpDir, "large.log", []string{
+		"2023-01-01T00:00:02.000 large",
+		"2023-01-01T00:00:03.000 large",
+		"2023-01-01T00:00:04.000 large",
+	})
+
+	cache := NewFileCache(10, 100, 0, time.Minute, reader.ModeAuto)
+
+	for _, path := range []string{small, other} {
+		lease, err := cache.Get(context.Background(), path, accessRandom)
+		require.NoError(t, err)
+		lease.Release()
+	}
+	assert.Len(t, cache.cache, 2)
+
+	lease, err := cache.Get(context.Background(), large, accessSequential)
+	require.NoError(t, err)
+	lease.Release()
+
+	stats := cache.Stats()
+	assert.LessOrEqual(t, stats.CachedBytes, int64(100))
+	assert.Equal(t, stats.CachedBytes, stats.MappedBytes, "released mappings should be unmapped")
+	require.NotEmpty(t, stats.Files)
+	assert.Equal(t, large, stats.Files[0].Path)
+	assert.Equal(t, "sequential", stats.Files[0].Access)
+
+	huge := createTestLogFileForCache(t, tmpDir, "huge.log", []string{
+		"2023-01-01T00:00:05.000 a line long enough to exceed the whole budget on its own",
+		"2023-01-01T00:00:06.000 a line long enough to exceed the whole budget on its own",
+	})
+	lease, err = cache.Get(context.Background(), huge, accessRandom)
+	require.NoError(t, err)
+	assert.Contains(t, readAll(t, lease), "budget")
+	lease.Release()
+
+	stats = cache.Stats()
+	assert.Zero(t, stats.Entries)
+	assert.Zero(t, stats.MappedBytes)
+}
+
+func TestFileCacheConcurrentEviction(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var paths []string
+	expected := make(map[string]string)
+	for i := 0; i < 8; i++ {
+		line := fmt.Sprintf("2023-01-01T00:00:%02d.000 file%d", i, i)
+		path := createTestLogFileForCache(t, tmpDir, fmt.Sprintf("file%d.log", i), []string{line})
+		paths = append(paths, path)
+		expected[path] = line + "\n"
+	}
+
+	cache := NewFileCache(2, 0, 0, time.Minute, reader.ModeAuto)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				path := paths[(g+i)%len(paths)]
+				lease, err := cache.Get(context.Background(), path, accessRandom)
+				if !assert.NoError(t, err) {
+					return
+				}
+				runtime.Gosched()
+				assert.Equal(t, expected[path], readAll(t, lease))
+				lease.Release()
+
+				if i%10 == 0 {
+					cache.Clear()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	cache.Clear()
+	assert.Empty(t, cache.cache)
+}
+
+func TestFileCachePreadMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := createTestLogFileForCache(t, tmpDir, "pread.log", []string{"2023-01-01T00:00:00.000 pread"})
+
+	cache := NewFileCache(2, 0, 0, time.Minute, reader.ModePread)
+	lease, err := cache.Get(context.Background(), path, accessRandom)
+	require.NoError(t, err)
+	defer lease.Release()
+
+	assert.Equal(t, "pread", lease.Reader().Kind())
+	assert.Contains(t, readAll(t, lease), "pread")
+
+	stats := cache.Stats()
+	assert.Zero(t, stats.MappedBytes, "pread readers do not map address space")
+	require.Len(t, stats.Files, 1)
+	assert.Equal(t, "pread", stats.Files[0].Reader)
+}
+
+func readAll(t *testing.T, lease *fileLease) string {
+	r := lease.Reader()
+	buf := make([]byte, r.Size())
+	_, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		require.NoError(t, err)
+	}
+	return string(buf)
 }
 
 func createTestLogFileForCache(t *testing.T, dir, name string, lines []string) string {
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file independently of its name, so a rotated file
+// keeps its index entry and mapping after being renamed.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func statFileID(info os.FileInfo) fileID {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+	}
+	return fileID{}
+}
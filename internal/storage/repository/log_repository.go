@@ -2,125 +2,1010 @@ package repository
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Dor1ma/log-finder/internal/metrics"
 	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/patterns"
+	"github.com/Dor1ma/log-finder/internal/tracing"
+	"github.com/Dor1ma/log-finder/pkg/reader"
 	"github.com/Dor1ma/log-finder/pkg/utils"
 )
 
+var timeFormat = "2006-01-02T15:04:05.000"
+
+var (
+	errMappingInvalidated = errors.New("file mapping invalidated during search")
+	errNotRegular         = errors.New("not a regular file")
+	errRewritten          = errors.New("file starts with another line")
+)
+
+// logFileMetadata describes one indexed file. complete is false while the
+// last line has no newline yet, so appended bytes continue that line.
 type logFileMetadata struct {
-	path  string
-	start time.Time
-	end   time.Time
+	path     string
+	source   string
+	id       fileID
+	size     int64
+	modTime  time.Time
+	start    time.Time
+	end      time.Time
+	lines    int64
+	complete bool
+	// minutes and patterns count the finished lines up to countedTo per
+	// minute. Older copies of the metadata share them, so grown files copy
+	// the hours they change.
+	minutes   minuteCounts
+	patterns  patternCounts
+	countedTo int64
+}
+
+type Options struct {
+	MaxOpenFiles     int
+	MaxMappedBytes   int64
+	MaxResidentBytes int64
+	FileCacheTTL     time.Duration
+	RefreshInterval  time.Duration
+	WatchDebounce    time.Duration
+	ReaderMode       reader.Mode
+	// ReaderModes overrides ReaderMode for the sources it names.
+	ReaderModes      map[string]reader.Mode
+	SearchWorkers    int
+	QueryParallelism int
+	// ErrorPattern marks the lines counted as errors in the per-minute
+	// counts; nil counts none.
+	ErrorPattern *regexp.Regexp
+	// Patterns, when set, groups indexed lines into message patterns.
+	Patterns *patterns.Miner
 }
 
 type LogRepository struct {
-	logDir          string
-	fileIndex       []logFileMetadata
-	indexMutex      sync.RWMutex
+	logDir     string
+	fileIndex  []logFileMetadata
+	indexMutex sync.RWMutex
+	// refreshMutex serializes index rebuilds. Files are read while only it
+	// is held; indexMutex is taken just to snapshot and swap the index, so
+	// searches and status requests never wait for a scan.
+	refreshMutex    sync.Mutex
 	fileCache       *fileCache
-	refreshInterval time.Duration
+	searchPool      *searchPool
+	refreshInterval atomic.Int64
+	intervalChanged chan struct{}
+	watchDebounce   time.Duration
+	watcher         *dirWatcher
+	counter         lineCounter
+	generation      uint64
+	subscribers     []func(models.IndexChange)
+	skipped         map[string]models.SkippedFile
+	lastRefresh     time.Time
+	refreshErr      error
+	ready           chan struct{}
+	readyOnce       sync.Once
 	done            chan struct{}
 	wg              sync.WaitGroup
 }
 
-func NewLogRepository(logDir string, maxOpenFiles int, fileCacheTTL, refreshInterval time.Duration) (*LogRepository, error) {
+func NewLogRepository(logDir string, opts Options) (*LogRepository, error) {
 	repo := &LogRepository{
 		logDir:          logDir,
-		fileCache:       NewFileCache(maxOpenFiles, fileCacheTTL),
-		refreshInterval: refreshInterval,
+		fileCache:       NewFileCache(opts.MaxOpenFiles, opts.MaxMappedBytes, opts.MaxResidentBytes, opts.FileCacheTTL, opts.ReaderMode),
+		searchPool:      newSearchPool(opts.SearchWorkers, opts.QueryParallelism),
+		intervalChanged: make(chan struct{}, 1),
+		watchDebounce:   opts.WatchDebounce,
+		counter:         lineCounter{errorPattern: opts.ErrorPattern, patterns: opts.Patterns},
+		skipped:         make(map[string]models.SkippedFile),
+		ready:           make(chan struct{}),
 		done:            make(chan struct{}),
 	}
 
-	if err := repo.RefreshMetadata(); err != nil {
+	repo.refreshInterval.Store(int64(opts.RefreshInterval))
+	if len(opts.ReaderModes) > 0 {
+		repo.fileCache.modeOf = func(path string) reader.Mode {
+			if mode, ok := opts.ReaderModes[repo.sourceOf(path)]; ok {
+				return mode
+			}
+			return opts.ReaderMode
+		}
+	}
+
+	if _, err := os.ReadDir(logDir); err != nil {
 		return nil, err
 	}
 
+	// The initial index is built in the background so the server can answer
+	// health and readiness probes while large directories are scanned.
+	repo.startWatcher()
+	repo.wg.Add(1)
+	go func() {
+		defer repo.wg.Done()
+		if err := repo.RefreshMetadata(context.Background()); err != nil {
+			slog.Error("Initial indexing failed, retrying on the next refresh", "error", err)
+		}
+	}()
 	repo.startPeriodicRefresh()
 	return repo, nil
 }
 
-func (r *LogRepository) RefreshMetadata() error {
-	r.indexMutex.Lock()
-	defer r.indexMutex.Unlock()
+// Ready is closed once the first full index has been built.
+func (r *LogRepository) Ready() <-chan struct{} {
+	return r.ready
+}
+
+func (r *LogRepository) isReady() bool {
+	select {
+	case <-r.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// RefreshMetadata rescans the log directory. ctx only carries request
+// attributes into the log lines; a refresh is never cancelled half way.
+func (r *LogRepository) RefreshMetadata(ctx context.Context) error {
+	defer metrics.IndexRefreshDuration.ObserveDuration(time.Now(), "full")
 
-	files, err := os.ReadDir(r.logDir)
+	r.refreshMutex.Lock()
+	defer r.refreshMutex.Unlock()
+
+	skipped := make(map[string]models.SkippedFile)
+	paths, err := r.listLogFiles(ctx, skipped)
 	if err != nil {
+		metrics.IndexRefreshErrors.Inc()
+		r.indexMutex.Lock()
+		r.refreshErr = err
+		r.indexMutex.Unlock()
 		return err
 	}
 
+	r.indexMutex.RLock()
+	known := r.indexByID()
+	r.indexMutex.RUnlock()
+
 	var newIndex []logFileMetadata
-	for _, f := range files {
-		if f.IsDir() {
+	for _, path := range paths {
+		meta, err := indexFile(ctx, path, r.sourceOf(path), known, r.counter)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping file", "path", path, "error", err)
+			metrics.IndexSkippedFiles.Inc()
+			skipped[path] = models.SkippedFile{Path: path, Source: r.sourceOf(path), Reason: err.Error()}
 			continue
 		}
+		newIndex = append(newIndex, meta)
+	}
 
-		path := filepath.Join(r.logDir, f.Name())
-		start, end, err := utils.GetFileTimeBounds(path)
+	r.indexMutex.Lock()
+	r.skipped = skipped
+	r.setIndex(newIndex)
+	r.lastRefresh = time.Now()
+	r.refreshErr = nil
+	r.indexMutex.Unlock()
+
+	r.readyOnce.Do(func() { close(r.ready) })
+	slog.InfoContext(ctx, "Metadata refreshed", "files", len(newIndex))
+	return nil
+}
+
+// listLogFiles returns the files directly in the log directory and in its
+// first-level subdirectories, and makes sure those subdirectories are
+// watched. Subdirectories that cannot be read are recorded in skipped.
+func (r *LogRepository) listLogFiles(ctx context.Context, skipped map[string]models.SkippedFile) ([]string, error) {
+	entries, err := os.ReadDir(r.logDir)
+	if err != nil {
+		return nil, err
+	}
+	if r.watcher != nil {
+		if err := r.watcher.watchRoot(); err != nil {
+			slog.WarnContext(ctx, "Failed to watch log directory", "path", r.logDir, "error", err)
+		}
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		path := filepath.Join(r.logDir, entry.Name())
+		if !entry.IsDir() {
+			paths = append(paths, path)
+			continue
+		}
+
+		if r.watcher != nil {
+			if err := r.watcher.watch(entry.Name()); err != nil {
+				slog.WarnContext(ctx, "Failed to watch source directory", "path", path, "error", err)
+			}
+		}
+
+		subEntries, err := os.ReadDir(path)
 		if err != nil {
-			log.Printf("Skipping file %s: %v", path, err)
+			slog.WarnContext(ctx, "Skipping source directory", "path", path, "error", err)
+			skipped[path] = models.SkippedFile{Path: path, Source: entry.Name(), Reason: err.Error()}
 			continue
 		}
+		for _, sub := range subEntries {
+			if !sub.IsDir() {
+				paths = append(paths, filepath.Join(path, sub.Name()))
+			}
+		}
+	}
+	return paths, nil
+}
 
-		newIndex = append(newIndex, logFileMetadata{
-			path:  path,
-			start: start,
-			end:   end,
-		})
+// sourceOf names the source of an indexed path: the first-level
+// subdirectory it is in, or DefaultSource.
+func (r *LogRepository) sourceOf(path string) string {
+	rel, err := filepath.Rel(r.logDir, path)
+	if err != nil || filepath.Dir(rel) == "." {
+		return models.DefaultSource
+	}
+	return filepath.Dir(rel)
+}
+
+func (r *LogRepository) indexByID() map[fileID]logFileMetadata {
+	known := make(map[fileID]logFileMetadata, len(r.fileIndex))
+	for _, meta := range r.fileIndex {
+		known[meta.id] = meta
+	}
+	return known
+}
+
+// indexFile reuses the bounds of an already indexed file when only its name
+// changed, extends them when the file was appended to, and rescans it
+// otherwise. A file that shrank under the same inode was rotated with
+// copy-truncate.
+func indexFile(ctx context.Context, path, source string, known map[fileID]logFileMetadata, counter lineCounter) (logFileMetadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return logFileMetadata{}, err
+	}
+	// Reading a FIFO or device to find its bounds would block or never end.
+	if !info.Mode().IsRegular() {
+		return logFileMetadata{}, errNotRegular
+	}
+
+	// A file moved to another source is counted again, as its patterns
+	// belong to the old one.
+	id := statFileID(info)
+	if prev, ok := known[id]; ok && prev.source == source {
+		// A write that did not change the size, such as a touch, leaves the
+		// bounds as they are; only a copy-truncate or growth needs a read.
+		if prev.size == info.Size() {
+			if prev.path != path {
+				slog.InfoContext(ctx, "Detected rename", "from", prev.path, "to", path)
+				prev.path = path
+			}
+			prev.modTime = info.ModTime()
+			return prev, nil
+		}
+		if info.Size() > prev.size {
+			prev.path = path
+			grown, err := prev.grow(info, counter)
+			if err == nil {
+				return grown, nil
+			}
+			if errors.Is(err, errRewritten) {
+				slog.InfoContext(ctx, "Detected copy-truncate rotation", "path", path)
+			}
+		} else {
+			slog.InfoContext(ctx, "Detected copy-truncate rotation", "path", path)
+		}
+	}
+
+	start, end, err := utils.GetFileTimeBounds(path)
+	if err != nil {
+		return logFileMetadata{}, err
+	}
+	meta := logFileMetadata{
+		path:    path,
+		source:  source,
+		id:      id,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		start:   start,
+		end:     end,
+	}
+	stats, err := counter.scan(&meta, info.Size())
+	if err != nil {
+		return logFileMetadata{}, err
+	}
+	meta.lines = stats.Lines
+	meta.complete = stats.Complete
+	return meta, nil
+}
+
+// grow extends meta over the bytes appended since it was indexed, reading
+// only the new tail. The tail is read from the start of an unfinished last
+// line, which was counted as a line but not yet per minute. A file that no
+// longer starts at meta.start was truncated and written past its old size
+// again, so it cannot be grown.
+func (meta logFileMetadata) grow(info os.FileInfo, counter lineCounter) (logFileMetadata, error) {
+	start, err := utils.GetFileStartTime(meta.path)
+	if err != nil {
+		return logFileMetadata{}, err
+	}
+	if !start.Equal(meta.start) {
+		return logFileMetadata{}, errRewritten
+	}
+
+	end, err := utils.GetFileEndTime(meta.path)
+	if err != nil {
+		return logFileMetadata{}, err
+	}
+	stats, err := counter.scan(&meta, info.Size())
+	if err != nil {
+		return logFileMetadata{}, err
+	}
+	lines := stats.Lines
+	if !meta.complete && lines > 0 {
+		lines--
+	}
+
+	meta.size = info.Size()
+	meta.modTime = info.ModTime()
+	meta.end = end
+	meta.lines += lines
+	meta.complete = stats.Complete
+	return meta, nil
+}
+
+// lineCounter counts the lines of files per minute while they are indexed.
+type lineCounter struct {
+	errorPattern *regexp.Regexp
+	patterns     *patterns.Miner
+}
+
+// scan counts the lines of meta from countedTo up to size into copies of
+// its per-minute counts.
+func (c lineCounter) scan(meta *logFileMetadata, size int64) (utils.LineStats, error) {
+	minutes := meta.minutes.writer()
+	var patternCounts *patternWriter
+	if c.patterns != nil {
+		patternCounts = meta.patterns.writer()
 	}
 
+	stats, err := utils.ScanLines(meta.path, meta.countedTo, size, func(minute time.Time, line []byte) {
+		count := minutes.at(minute)
+		count.Lines++
+		if c.errorPattern != nil && c.errorPattern.Match(line) {
+			count.Errors++
+		}
+
+		if c.patterns != nil {
+			patternCounts.add(c.patterns.Add(meta.source, utils.Message(string(line))), minute)
+		}
+	})
+	if err != nil {
+		return stats, err
+	}
+	meta.minutes = minutes.counts
+	if patternCounts != nil {
+		meta.patterns = patternCounts.counts
+	}
+	meta.countedTo = stats.CountedTo
+	return stats, nil
+}
+
+func (meta logFileMetadata) indexedFile() models.IndexedFile {
+	return models.IndexedFile{
+		Path:   meta.path,
+		Source: meta.source,
+		Start:  meta.start,
+		End:    meta.end,
+		Size:   meta.size,
+		Lines:  meta.lines,
+	}
+}
+
+func (r *LogRepository) setIndex(newIndex []logFileMetadata) {
 	sort.Slice(newIndex, func(i, j int) bool {
 		return newIndex[i].start.Before(newIndex[j].start)
 	})
 
+	live := make(map[fileID]struct{}, len(newIndex))
+	for _, meta := range newIndex {
+		live[meta.id] = struct{}{}
+	}
+	r.fileCache.Retain(live)
+	if dropsPatterns(r.fileIndex, newIndex) {
+		r.retainPatterns(newIndex)
+	}
+
+	changed := indexChanges(r.fileIndex, newIndex)
 	r.fileIndex = newIndex
-	log.Printf("Metadata refreshed. Files in index: %d", len(r.fileIndex))
-	return nil
+	r.publish(changed)
+}
+
+// dropsPatterns reports whether newIndex lost counts of the old index: a
+// file was removed or counted again from the start.
+func dropsPatterns(oldIndex, newIndex []logFileMetadata) bool {
+	counted := make(map[fileID]int64, len(newIndex))
+	for _, meta := range newIndex {
+		counted[meta.id] = meta.countedTo
+	}
+	for _, meta := range oldIndex {
+		if to, ok := counted[meta.id]; !ok || to < meta.countedTo {
+			return true
+		}
+	}
+	return false
 }
 
-func (r *LogRepository) FindByTimestamp(ctx context.Context, t time.Time) (string, error) {
+// retainPatterns frees the miner of patterns no file in index counts any
+// more, so new message types get their slots. The caller holds refreshMutex,
+// so no scan adds patterns meanwhile.
+func (r *LogRepository) retainPatterns(index []logFileMetadata) {
+	if r.counter.patterns == nil {
+		return
+	}
+
+	used := make(map[int]struct{})
+	for _, meta := range index {
+		meta.patterns.each(func(key patternMinute, _ int) {
+			used[key.id] = struct{}{}
+		})
+	}
+	dropped := r.counter.patterns.Retain(func(id int) bool {
+		_, ok := used[id]
+		return ok
+	})
+	if dropped > 0 {
+		slog.Info("Dropped unused patterns", "count", dropped)
+	}
+}
+
+// SubscribeIndexChanges registers fn to be called after every index update
+// that can change search results. fn runs with the index locked and must not
+// call back into the repository.
+func (r *LogRepository) SubscribeIndexChanges(fn func(models.IndexChange)) {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+func (r *LogRepository) publish(changed []models.TimeRange) {
+	if len(changed) == 0 {
+		return
+	}
+
+	r.generation++
+	change := models.IndexChange{Generation: r.generation, Changed: changed}
+	for _, fn := range r.subscribers {
+		fn(change)
+	}
+}
+
+// indexChanges returns the time ranges covered by files that were added,
+// removed or modified. For a file that was only appended to, just the newly
+// covered tail is reported; a pure rename changes nothing.
+func indexChanges(oldIndex, newIndex []logFileMetadata) []models.TimeRange {
+	previous := make(map[fileID]logFileMetadata, len(oldIndex))
+	for _, meta := range oldIndex {
+		previous[meta.id] = meta
+	}
+
+	var changed []models.TimeRange
+	for _, meta := range newIndex {
+		prev, ok := previous[meta.id]
+		delete(previous, meta.id)
+
+		switch {
+		case !ok:
+			changed = append(changed, models.TimeRange{Start: meta.start, End: meta.end})
+		case prev.size == meta.size:
+		case meta.size > prev.size && prev.start.Equal(meta.start):
+			changed = append(changed, models.TimeRange{Start: prev.end, End: meta.end})
+		default:
+			changed = append(changed,
+				models.TimeRange{Start: prev.start, End: prev.end},
+				models.TimeRange{Start: meta.start, End: meta.end})
+		}
+	}
+
+	for _, prev := range previous {
+		changed = append(changed, models.TimeRange{Start: prev.start, End: prev.end})
+	}
+
+	return changed
+}
+
+func (r *LogRepository) FindByTimestamp(ctx context.Context, t time.Time) (string, string, error) {
+	if !r.isReady() {
+		return "", "", models.ErrNotReady
+	}
+
+	ctx, span := tracing.Start(ctx, "LogRepository.FindByTimestamp")
+	defer span.End()
+
+	result, source, err := r.findInIndex(ctx, t)
+	if errors.Is(err, models.ErrNotFound) && r.extendActiveFile(ctx, t) {
+		span.SetAttr("extended_active_file", true)
+		result, source, err = r.findInIndex(ctx, t)
+	}
+	if errors.Is(err, models.ErrNotFound) && r.pastSourceEnd(ctx, t) {
+		err = models.ErrPastIndexEnd
+	}
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		span.RecordError(err)
+	}
+	return result, source, err
+}
+
+func (r *LogRepository) findInIndex(ctx context.Context, t time.Time) (string, string, error) {
+	access := models.SourceAccessFrom(ctx)
+
 	r.indexMutex.RLock()
 	defer r.indexMutex.RUnlock()
 
+	// Files of different sources can cover the same time, so a miss in one
+	// file moves on to the next.
 	for _, meta := range r.fileIndex {
+		if err := ctx.Err(); err != nil {
+			return "", "", err
+		}
+
 		if (t.Equal(meta.start) || t.After(meta.start)) &&
-			(t.Equal(meta.end) || t.Before(meta.end)) {
+			(t.Equal(meta.end) || t.Before(meta.end)) &&
+			access.Allows(meta.source, t) {
 
-			data, err := r.fileCache.Get(meta.path)
+			result, err := r.searchFile(ctx, meta.path, t)
+			if errors.Is(err, errMappingInvalidated) {
+				result, err = r.searchFile(ctx, meta.path, t)
+			}
+			if errors.Is(err, models.ErrNotFound) {
+				continue
+			}
 			if err != nil {
-				return "", err
+				return "", "", err
 			}
+			return result, meta.source, nil
+		}
+	}
 
-			result, err := utils.BinarySearchInData(data, t)
-			if err != nil {
-				return "", models.ErrNotFound
+	return "", "", models.ErrNotFound
+}
+
+// pastSourceEnd reports whether t is after the end of the newest file of a
+// source visible to the caller.
+func (r *LogRepository) pastSourceEnd(ctx context.Context, t time.Time) bool {
+	access := models.SourceAccessFrom(ctx)
+
+	r.indexMutex.RLock()
+	defer r.indexMutex.RUnlock()
+
+	ends := make(map[string]time.Time)
+	for _, meta := range r.fileIndex {
+		if access.Allows(meta.source, t) && meta.end.After(ends[meta.source]) {
+			ends[meta.source] = meta.end
+		}
+	}
+	for _, end := range ends {
+		if t.After(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// extendActiveFile handles lookups past the end of the newest file of a
+// source: if that file has grown since it was indexed, its end bound is
+// moved forward from the new tail without waiting for the watcher or the
+// periodic refresh. A refresh already running picks up the growth itself.
+func (r *LogRepository) extendActiveFile(ctx context.Context, t time.Time) bool {
+	if !r.refreshMutex.TryLock() {
+		return false
+	}
+	defer r.refreshMutex.Unlock()
+
+	access := models.SourceAccessFrom(ctx)
+	r.indexMutex.RLock()
+	latest := make(map[string]logFileMetadata)
+	for _, meta := range r.fileIndex {
+		if newest, ok := latest[meta.source]; !ok || meta.end.After(newest.end) {
+			latest[meta.source] = meta
+		}
+	}
+	r.indexMutex.RUnlock()
+
+	extended := false
+	for source, meta := range latest {
+		if !t.After(meta.end) || !access.Allows(source, t) {
+			continue
+		}
+		grown, ok := r.growActiveFile(ctx, meta)
+		if ok && !t.After(grown.end) {
+			extended = true
+		}
+	}
+	return extended
+}
+
+// growActiveFile indexes the new tail of latest, if any, and puts the grown
+// metadata in its place.
+func (r *LogRepository) growActiveFile(ctx context.Context, latest logFileMetadata) (logFileMetadata, bool) {
+	info, err := os.Stat(latest.path)
+	if err != nil || statFileID(info) != latest.id || info.Size() <= latest.size {
+		return latest, false
+	}
+
+	grown, err := latest.grow(info, r.counter)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read new tail", "path", latest.path, "error", err)
+		return latest, false
+	}
+
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	for i, meta := range r.fileIndex {
+		if meta.id == latest.id && meta.size == latest.size {
+			r.fileIndex[i] = grown
+			r.publish([]models.TimeRange{{Start: meta.end, End: grown.end}})
+			slog.InfoContext(ctx, "Extended active file", "path", meta.path, "end", grown.end)
+		}
+	}
+	return grown, true
+}
+
+func (r *LogRepository) searchFile(ctx context.Context, path string, t time.Time) (string, error) {
+	lease, err := r.fileCache.Get(ctx, path, accessRandom)
+	if err != nil {
+		return "", err
+	}
+	defer lease.Release()
+
+	_, span := tracing.Start(ctx, "BinarySearchInReader")
+	span.SetAttr("path", path)
+	span.SetAttr("size", lease.Reader().Size())
+	span.SetAttr("reader", lease.Reader().Kind())
+
+	var result string
+	err = lease.readMapped(func() error {
+		var err error
+		result, err = utils.BinarySearchInReader(ctx, lease.Reader(), lease.Reader().Size(), t)
+		return err
+	})
+	span.SetAttr("found", err == nil)
+	span.End()
+	if errors.Is(err, errMappingInvalidated) || ctx.Err() != nil {
+		return "", err
+	}
+	if err != nil {
+		return "", models.ErrNotFound
+	}
+
+	return result, nil
+}
+
+// FindRange returns the lines of query in time order, scanning the files
+// that overlap the range in parallel. When the context deadline passes
+// mid-scan, the lines found so far are returned with Truncated set; any
+// other cancellation is reported as an error.
+func (r *LogRepository) FindRange(ctx context.Context, query models.RangeQuery) (models.RangeResult, error) {
+	if !r.isReady() {
+		return models.RangeResult{}, models.ErrNotReady
+	}
+	matches, err := r.lineFilter(query)
+	if err != nil {
+		return models.RangeResult{}, err
+	}
+
+	access := models.SourceAccessFrom(ctx)
+	files := r.filesInRange(query.From, query.To, access)
+	result := models.RangeResult{Sources: sourcesOf(files)}
+	perFile := make([][]string, len(files))
+
+	errs := r.searchPool.run(ctx, len(files), func(i int) error {
+		return r.scanFile(ctx, files[i].path, query.From, query.To, func(line string) bool {
+			if !matches(files[i].source, line) || !lineVisible(access, files[i].source, line) {
+				return true
 			}
+			perFile[i] = append(perFile[i], line)
+			// One line past the limit is enough to know the result is cut.
+			return query.Limit <= 0 || len(perFile[i]) <= query.Limit
+		})
+	})
+
+	truncated, err := r.scanOutcome(ctx, files, errs)
+	if err != nil {
+		return result, err
+	}
 
-			return result, nil
+	result.Lines = mergeByTimestamp(perFile)
+	result.Truncated = truncated
+	if query.Limit > 0 && len(result.Lines) > query.Limit {
+		result.Lines = result.Lines[:query.Limit]
+		result.Truncated = true
+	}
+
+	return result, nil
+}
+
+// CountRange counts the lines of query, split into Interval wide buckets
+// when Interval is set.
+func (r *LogRepository) CountRange(ctx context.Context, query models.RangeQuery) (models.CountResult, error) {
+	if !r.isReady() {
+		return models.CountResult{}, models.ErrNotReady
+	}
+	matches, err := r.lineFilter(query)
+	if err != nil {
+		return models.CountResult{}, err
+	}
+
+	access := models.SourceAccessFrom(ctx)
+	files := r.filesInRange(query.From, query.To, access)
+	result := models.CountResult{Sources: sourcesOf(files)}
+	perFile := make([]map[time.Time]int, len(files))
+	totals := make([]int, len(files))
+
+	errs := r.searchPool.run(ctx, len(files), func(i int) error {
+		perFile[i] = make(map[time.Time]int)
+		return r.scanFile(ctx, files[i].path, query.From, query.To, func(line string) bool {
+			if !matches(files[i].source, line) || !lineVisible(access, files[i].source, line) {
+				return true
+			}
+			totals[i]++
+			if query.Interval > 0 {
+				lineTime, _ := utils.ParseTimestamp(line)
+				perFile[i][bucketStart(query.From, lineTime, query.Interval)]++
+			}
+			return true
+		})
+	})
+
+	truncated, err := r.scanOutcome(ctx, files, errs)
+	if err != nil {
+		return result, err
+	}
+	result.Truncated = truncated
+
+	buckets := make(map[time.Time]int)
+	for i := range files {
+		result.Total += totals[i]
+		for start, count := range perFile[i] {
+			buckets[start] += count
 		}
 	}
 
-	return "", models.ErrNotFound
+	if query.Interval > 0 {
+		for start := query.From; !start.After(query.To); start = start.Add(query.Interval) {
+			result.Buckets = append(result.Buckets, models.CountBucket{Start: start, Count: buckets[start]})
+		}
+	}
+
+	return result, nil
+}
+
+func bucketStart(from, t time.Time, interval time.Duration) time.Time {
+	return from.Add(t.Sub(from) / interval * interval)
+}
+
+// filesInRange copies the files overlapping [from, to] out of the index so
+// long scans never hold the lock that refreshes and point lookups need.
+// Files of sources the caller may not see in that range are left out.
+func (r *LogRepository) filesInRange(from, to time.Time, access models.SourceAccess) []logFileMetadata {
+	r.indexMutex.RLock()
+	defer r.indexMutex.RUnlock()
+
+	var files []logFileMetadata
+	for _, meta := range r.fileIndex {
+		if !meta.end.Before(from) && !meta.start.After(to) &&
+			access.Overlaps(meta.source, from, to) {
+			files = append(files, meta)
+		}
+	}
+	return files
+}
+
+// MinuteCounts sums the per-minute counts of each source over the minutes
+// starting in [from, to] that the caller may see.
+func (r *LogRepository) MinuteCounts(ctx context.Context, from, to time.Time) (models.MinuteSeries, error) {
+	if !r.isReady() {
+		return models.MinuteSeries{}, models.ErrNotReady
+	}
+
+	access := models.SourceAccessFrom(ctx)
+	from = from.Truncate(time.Minute)
+	// Lines up to the end of the minute starting at to are counted in it.
+	through := to.Truncate(time.Minute).Add(time.Minute - time.Nanosecond)
+
+	r.indexMutex.RLock()
+	defer r.indexMutex.RUnlock()
+
+	series := models.MinuteSeries{Sources: make(map[string]map[time.Time]models.MinuteCount)}
+	for _, meta := range r.fileIndex {
+		// The newest line of a source the caller cannot see would tell
+		// when that source last logged.
+		if meta.end.After(series.End) && access.Allows(meta.source, meta.end) {
+			series.End = meta.end
+		}
+		if meta.end.Before(from) || meta.start.After(through) || !access.Overlaps(meta.source, from, through) {
+			continue
+		}
+
+		counts := series.Sources[meta.source]
+		if counts == nil {
+			counts = make(map[time.Time]models.MinuteCount)
+			series.Sources[meta.source] = counts
+		}
+		meta.minutes.each(func(minute time.Time, count models.MinuteCount) {
+			if minute.Before(from) || minute.After(to) || !access.Allows(meta.source, minute) {
+				return
+			}
+			total := counts[minute]
+			total.Lines += count.Lines
+			total.Errors += count.Errors
+			counts[minute] = total
+		})
+	}
+	return series, nil
+}
+
+// PatternCounts sums the per-minute counts of each message pattern over
+// the minutes starting in [from, to] that the caller may see.
+func (r *LogRepository) PatternCounts(ctx context.Context, from, to time.Time) (models.PatternCounts, error) {
+	miner := r.counter.patterns
+	if miner == nil {
+		return models.PatternCounts{}, models.ErrNotSupported
+	}
+	if !r.isReady() {
+		return models.PatternCounts{}, models.ErrNotReady
+	}
+
+	access := models.SourceAccessFrom(ctx)
+	from = from.Truncate(time.Minute)
+	through := to.Truncate(time.Minute).Add(time.Minute - time.Nanosecond)
+
+	r.indexMutex.RLock()
+	defer r.indexMutex.RUnlock()
+
+	counts := models.PatternCounts{
+		Templates: make(map[int]string),
+		Minutes:   make(map[int]map[time.Time]int),
+		FirstSeen: make(map[int]time.Time),
+	}
+	// Files before the range are read too, to tell when each pattern was
+	// first seen.
+	for _, meta := range r.fileIndex {
+		if meta.start.After(through) || !access.Overlaps(meta.source, time.Time{}, through) {
+			continue
+		}
+		meta.patterns.each(func(key patternMinute, count int) {
+			if key.id == patterns.Unclassified || key.minute.After(to) || !access.Allows(meta.source, key.minute) {
+				return
+			}
+			if first, ok := counts.FirstSeen[key.id]; !ok || key.minute.Before(first) {
+				counts.FirstSeen[key.id] = key.minute
+			}
+			if key.minute.Before(from) {
+				return
+			}
+			minutes := counts.Minutes[key.id]
+			if minutes == nil {
+				minutes = make(map[time.Time]int)
+				counts.Minutes[key.id] = minutes
+			}
+			minutes[key.minute] += count
+		})
+	}
+
+	for id := range counts.Minutes {
+		counts.Templates[id], _ = miner.Template(id)
+	}
+	return counts, nil
+}
+
+// lineFilter returns the function that keeps the lines of query read from
+// a file of source.
+func (r *LogRepository) lineFilter(query models.RangeQuery) (func(source, line string) bool, error) {
+	if query.PatternID == 0 {
+		return func(_, line string) bool { return query.Matches(line) }, nil
+	}
+	miner := r.counter.patterns
+	if miner == nil {
+		return nil, models.ErrNotSupported
+	}
+	if _, ok := miner.Template(query.PatternID); !ok {
+		return nil, models.ErrNoSuchPattern
+	}
+	return func(source, line string) bool {
+		return query.Matches(line) && miner.Fits(query.PatternID, source, utils.Message(line))
+	}, nil
+}
+
+// sourcesOf lists the sources of files in order, once each.
+func sourcesOf(files []logFileMetadata) []string {
+	var sources []string
+	for _, meta := range files {
+		sources = append(sources, meta.source)
+	}
+	slices.Sort(sources)
+	return slices.Compact(sources)
+}
+
+// lineVisible applies the time windows of access to one line of source.
+func lineVisible(access models.SourceAccess, source, line string) bool {
+	if access == nil {
+		return true
+	}
+	lineTime, err := utils.ParseTimestamp(line)
+	return err == nil && access.Allows(source, lineTime)
+}
+
+// scanOutcome folds per-file scan errors into the query result: a passed
+// deadline truncates it, cancellation fails it, and files that could not
+// be read are skipped.
+func (r *LogRepository) scanOutcome(ctx context.Context, files []logFileMetadata, errs []error) (bool, error) {
+	truncated := false
+	for i, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, context.DeadlineExceeded):
+			truncated = true
+		case ctx.Err() != nil:
+			return false, ctx.Err()
+		default:
+			slog.WarnContext(ctx, "Skipping file in range scan", "path", files[i].path, "error", err)
+		}
+	}
+	return truncated, nil
+}
+
+// mergeByTimestamp merges per-file results, each already in time order,
+// into one time ordered slice. Lines with equal timestamps keep file order.
+func mergeByTimestamp(perFile [][]string) []string {
+	var lines []string
+	for _, fileLines := range perFile {
+		lines = append(lines, fileLines...)
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return timestampPrefix(lines[i]) < timestampPrefix(lines[j])
+	})
+	return lines
+}
+
+// timestampPrefix relies on the fixed width timestamp format sorting
+// lexicographically in time order.
+func timestampPrefix(line string) string {
+	if len(line) < len(timeFormat) {
+		return line
+	}
+	return line[:len(timeFormat)]
+}
+
+func (r *LogRepository) scanFile(ctx context.Context, path string, from, to time.Time, fn func(line string) bool) error {
+	lease, err := r.fileCache.Get(ctx, path, accessSequential)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+
+	return lease.readMapped(func() error {
+		return utils.ScanRange(ctx, lease.Reader(), lease.Reader().Size(), from, to, fn)
+	})
 }
 
 func (r *LogRepository) startPeriodicRefresh() {
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		ticker := time.NewTicker(r.refreshInterval)
+		ticker := time.NewTicker(time.Duration(r.refreshInterval.Load()))
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				if err := r.RefreshMetadata(); err != nil {
-					log.Printf("Metadata refresh error: %v", err)
+				if err := r.RefreshMetadata(context.Background()); err != nil {
+					slog.Error("Metadata refresh failed", "error", err)
 				}
+			case <-r.intervalChanged:
+				ticker.Reset(time.Duration(r.refreshInterval.Load()))
 			case <-r.done:
 				return
 			}
@@ -134,21 +1019,49 @@ func (r *LogRepository) FileCount() int {
 	return len(r.fileIndex)
 }
 
-func (r *LogRepository) Close() {
-	close(r.done)
-	r.wg.Wait()
-	r.fileCache.Clear()
-}
+func (r *LogRepository) IndexStatus() models.IndexStatus {
+	r.indexMutex.RLock()
+	defer r.indexMutex.RUnlock()
+
+	status := models.IndexStatus{
+		Ready:           r.isReady(),
+		LogDir:          r.logDir,
+		LastRefresh:     r.lastRefresh,
+		RefreshInterval: time.Duration(r.refreshInterval.Load()).String(),
+		WatcherActive:   r.watcher != nil,
+		Files:           make([]models.IndexedFile, 0, len(r.fileIndex)),
+		Skipped:         make([]models.SkippedFile, 0, len(r.skipped)),
+	}
+	if r.refreshErr != nil {
+		status.LastRefreshError = r.refreshErr.Error()
+	}
+
+	for _, meta := range r.fileIndex {
+		status.Files = append(status.Files, meta.indexedFile())
+	}
+	for _, file := range r.skipped {
+		status.Skipped = append(status.Skipped, file)
+	}
+	sort.Slice(status.Skipped, func(i, j int) bool {
+		return status.Skipped[i].Path < status.Skipped[j].Path
+	})
 
-func (c *fileCache) Clear() {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	return status
+}
 
-	for k, entry := range c.cache {
-		delete(c.cache, k)
-		c.lruList.Remove(entry.element)
+func (r *LogRepository) FileCacheStats() models.FileCacheStats {
+	stats := r.fileCache.Stats()
+	for i := range stats.Files {
+		stats.Files[i].Source = r.sourceOf(stats.Files[i].Path)
 	}
+	return stats
+}
 
-	c.cache = make(map[string]*cacheEntry)
-	c.lruList.Init()
+func (r *LogRepository) Close() {
+	close(r.done)
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+	r.wg.Wait()
+	r.fileCache.Clear()
 }
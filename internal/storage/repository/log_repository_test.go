@@ -4,16 +4,19 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Dor1ma/log-finder/internal/models"
+	"github.com/Dor1ma/log-finder/internal/patterns"
+	"github.com/Dor1ma/log-finder/pkg/reader"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-var timeFormat = "2006-01-02T15:04:05.000"
-
 func TestLogRepository(t *testing.T) {
 	tmpDir := t.TempDir()
 	createTestLogFile(t, tmpDir, "test1.log", []string{
@@ -26,37 +29,919 @@ func TestLogRepository(t *testing.T) {
 	testTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:01.000")
 
 	t.Run("successful creation and basic operations", func(t *testing.T) {
-		repo, err := NewLogRepository(tmpDir, 10, time.Minute, time.Hour)
+		repo, err := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
 		require.NoError(t, err)
 		defer repo.Close()
 
 		assert.Equal(t, 1, repo.FileCount())
 
-		result, err := repo.FindByTimestamp(ctx, testTime)
+		result, _, err := repo.FindByTimestamp(ctx, testTime)
 		require.NoError(t, err)
 		assert.Contains(t, result, "line2")
 	})
 
 	t.Run("file rotation handling", func(t *testing.T) {
-		repo, _ := NewLogRepository(tmpDir, 10, time.Minute, time.Hour)
+		repo, _ := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
 		defer repo.Close()
 
 		createTestLogFile(t, tmpDir, "test2.log", []string{
 			"2023-01-01T00:00:03.000 line4",
 		})
 
-		require.NoError(t, repo.RefreshMetadata())
+		require.NoError(t, repo.RefreshMetadata(context.Background()))
 		assert.Equal(t, 2, repo.FileCount())
 	})
 
 	t.Run("not found handling", func(t *testing.T) {
-		repo, _ := NewLogRepository(tmpDir, 10, time.Minute, time.Hour)
+		repo, _ := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
 		defer repo.Close()
 
 		invalidTime, _ := time.Parse(timeFormat, "2024-01-01T00:00:00.000")
-		_, err := repo.FindByTimestamp(ctx, invalidTime)
+		_, _, err := repo.FindByTimestamp(ctx, invalidTime)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+func TestLogRepositoryWatcher(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "test1.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+	})
+
+	repo, err := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	createTestLogFile(t, tmpDir, "test2.log", []string{
+		"2023-01-01T00:00:01.000 line2",
+	})
+	assert.Eventually(t, func() bool { return repo.FileCount() == 2 }, 2*time.Second, 5*time.Millisecond)
+
+	testTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:01.000")
+	result, _, err := repo.FindByTimestamp(context.Background(), testTime)
+	require.NoError(t, err)
+	assert.Contains(t, result, "line2")
+
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, "test2.log")))
+	assert.Eventually(t, func() bool { return repo.FileCount() == 1 }, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestLogRepositoryWatcherRecreatedDir(t *testing.T) {
+	logDir := filepath.Join(t.TempDir(), "logs")
+	require.NoError(t, os.Mkdir(logDir, 0o755))
+	createTestLogFile(t, logDir, "test1.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+	})
+
+	repo, err := openTestRepository(logDir, testOptions(10*time.Millisecond))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.NoError(t, os.RemoveAll(logDir))
+	require.NoError(t, os.Mkdir(logDir, 0o755))
+	require.NoError(t, repo.RefreshMetadata(context.Background()))
+	assert.Equal(t, 0, repo.FileCount())
+
+	createTestLogFile(t, logDir, "test2.log", []string{
+		"2023-01-01T00:00:01.000 line2",
+	})
+	assert.Eventually(t, func() bool { return repo.FileCount() == 1 }, 2*time.Second, 5*time.Millisecond,
+		"the directory is watched again after it was recreated")
+}
+
+func TestLogRepositoryRotation(t *testing.T) {
+	ctx := context.Background()
+	firstTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:01.000")
+
+	t.Run("rename keeps index entry and mapping", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		createTestLogFile(t, tmpDir, "app.log", []string{
+			"2023-01-01T00:00:00.000 line1",
+			"2023-01-01T00:00:01.000 line2",
+		})
+
+		repo, err := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
+		require.NoError(t, err)
+		defer repo.Close()
+
+		_, _, err = repo.FindByTimestamp(ctx, firstTime)
+		require.NoError(t, err)
+
+		require.NoError(t, os.Rename(filepath.Join(tmpDir, "app.log"), filepath.Join(tmpDir, "app.log.1")))
+		require.NoError(t, repo.RefreshMetadata(context.Background()))
+		assert.Equal(t, 1, repo.FileCount())
+
+		result, _, err := repo.FindByTimestamp(ctx, firstTime)
+		require.NoError(t, err)
+		assert.Contains(t, result, "line2")
+
+		repo.fileCache.mutex.Lock()
+		defer repo.fileCache.mutex.Unlock()
+		require.Len(t, repo.fileCache.cache, 1)
+		for _, entry := range repo.fileCache.cache {
+			assert.Equal(t, filepath.Join(tmpDir, "app.log.1"), entry.path)
+		}
+	})
+
+	t.Run("copy-truncate is detected", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		createTestLogFile(t, tmpDir, "app.log", []string{
+			"2023-01-01T00:00:00.000 line1",
+			"2023-01-01T00:00:01.000 line2",
+		})
+
+		repo, err := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
+		require.NoError(t, err)
+		defer repo.Close()
+
+		_, _, err = repo.FindByTimestamp(ctx, firstTime)
+		require.NoError(t, err)
+
+		path := filepath.Join(tmpDir, "app.log")
+		require.NoError(t, os.Truncate(path, 0))
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString("2023-01-01T00:01:00.000 fresh\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		require.NoError(t, repo.RefreshMetadata(context.Background()))
+
+		_, _, err = repo.FindByTimestamp(ctx, firstTime)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+
+		freshTime, _ := time.Parse(timeFormat, "2023-01-01T00:01:00.000")
+		result, _, err := repo.FindByTimestamp(ctx, freshTime)
+		require.NoError(t, err)
+		assert.Contains(t, result, "fresh")
+	})
+
+	t.Run("copy-truncate past the old size is detected", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		createTestLogFile(t, tmpDir, "app.log", []string{
+			"2023-01-01T00:00:00.000 line1",
+			"2023-01-01T00:00:01.000 line2",
+		})
+
+		repo, err := openTestRepository(tmpDir, testOptions(time.Hour))
+		require.NoError(t, err)
+		defer repo.Close()
+
+		createTestLogFile(t, tmpDir, "app.log", []string{
+			"2023-01-01T00:01:00.000 fresh1",
+			"2023-01-01T00:01:01.000 fresh2",
+			"2023-01-01T00:01:02.000 fresh3",
+		})
+		require.NoError(t, repo.RefreshMetadata(context.Background()))
+
+		freshTime, _ := time.Parse(timeFormat, "2023-01-01T00:01:00.000")
+		repo.indexMutex.RLock()
+		defer repo.indexMutex.RUnlock()
+		require.Len(t, repo.fileIndex, 1)
+		assert.Equal(t, freshTime, repo.fileIndex[0].start)
+		assert.Equal(t, int64(3), repo.fileIndex[0].lines, "the file is counted from its start")
+	})
+}
+
+func TestLogRepositoryGrowingFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "app.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+	})
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "payments"), 0755))
+	createTestLogFile(t, tmpDir, "payments/pay.log", []string{
+		"2023-01-01T00:00:10.000 payment",
+	})
+
+	repo, err := openTestRepository(tmpDir, testOptions(time.Hour))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	ctx := context.Background()
+	firstTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+	_, _, err = repo.FindByTimestamp(ctx, firstTime)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(filepath.Join(tmpDir, "app.log"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("2023-01-01T00:00:05.000 appended\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	appendedTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:05.000")
+	result, _, err := repo.FindByTimestamp(ctx, appendedTime)
+	require.NoError(t, err)
+	assert.Contains(t, result, "appended")
+
+	result, _, err = repo.FindByTimestamp(ctx, firstTime)
+	require.NoError(t, err)
+	assert.Contains(t, result, "line1")
+
+	_, _, err = repo.FindByTimestamp(ctx, appendedTime.Add(time.Second))
+	assert.ErrorIs(t, err, models.ErrPastIndexEnd, "the active file of app may still get the line")
+	_, _, err = repo.FindByTimestamp(ctx, firstTime.Add(time.Second))
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.NotErrorIs(t, err, models.ErrPastIndexEnd)
+}
+
+func TestLogRepositoryIndexChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "test1.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+	})
+
+	repo, err := openTestRepository(tmpDir, testOptions(time.Hour))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	var changes []models.IndexChange
+	repo.SubscribeIndexChanges(func(change models.IndexChange) {
+		changes = append(changes, change)
+	})
+
+	require.NoError(t, repo.RefreshMetadata(context.Background()))
+	assert.Empty(t, changes, "unchanged files should not publish a change")
+
+	createTestLogFile(t, tmpDir, "test2.log", []string{
+		"2023-01-01T00:00:05.000 line2",
+		"2023-01-01T00:00:06.000 line3",
+	})
+	require.NoError(t, repo.RefreshMetadata(context.Background()))
+
+	require.Len(t, changes, 1)
+	require.Len(t, changes[0].Changed, 1)
+	newTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:05.000")
+	oldTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+	assert.True(t, changes[0].Changed[0].Contains(newTime))
+	assert.False(t, changes[0].Changed[0].Contains(oldTime))
+}
+
+func TestLogRepositoryFindRange(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "test1.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+		"2023-01-01T00:00:01.000 line2",
+	})
+	createTestLogFile(t, tmpDir, "test2.log", []string{
+		"2023-01-01T00:00:02.000 line3",
+		"2023-01-01T00:00:03.000 line4",
+	})
+
+	repo, err := openTestRepository(tmpDir, testOptions(time.Hour))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	from, _ := time.Parse(timeFormat, "2023-01-01T00:00:01.000")
+	to, _ := time.Parse(timeFormat, "2023-01-01T00:00:02.500")
+
+	t.Run("lines across files in order", func(t *testing.T) {
+		result, err := repo.FindRange(context.Background(), models.RangeQuery{From: from, To: to})
+		require.NoError(t, err)
+		require.Len(t, result.Lines, 2)
+		assert.Contains(t, result.Lines[0], "line2")
+		assert.Contains(t, result.Lines[1], "line3")
+		assert.False(t, result.Truncated)
+	})
+
+	t.Run("overlapping files are merged in time order", func(t *testing.T) {
+		createTestLogFile(t, tmpDir, "test3.log", []string{
+			"2023-01-01T00:00:01.500 other1",
+			"2023-01-01T00:00:02.200 other2",
+		})
+		require.NoError(t, repo.RefreshMetadata(context.Background()))
+		defer func() {
+			require.NoError(t, os.Remove(filepath.Join(tmpDir, "test3.log")))
+			require.NoError(t, repo.RefreshMetadata(context.Background()))
+		}()
+
+		result, err := repo.FindRange(context.Background(), models.RangeQuery{From: from, To: to})
+		require.NoError(t, err)
+		require.Len(t, result.Lines, 4)
+		for i, want := range []string{"line2", "other1", "line3", "other2"} {
+			assert.Contains(t, result.Lines[i], want)
+		}
+	})
+
+	t.Run("pattern filters lines", func(t *testing.T) {
+		result, err := repo.FindRange(context.Background(), models.RangeQuery{
+			From:    from,
+			To:      to,
+			Pattern: regexp.MustCompile(`line3$`),
+		})
+		require.NoError(t, err)
+		require.Len(t, result.Lines, 1)
+		assert.Contains(t, result.Lines[0], "line3")
+	})
+
+	t.Run("counts per interval", func(t *testing.T) {
+		start, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+		end, _ := time.Parse(timeFormat, "2023-01-01T00:00:03.999")
+
+		result, err := repo.CountRange(context.Background(), models.RangeQuery{
+			From:     start,
+			To:       end,
+			Interval: 2 * time.Second,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 4, result.Total)
+		require.Len(t, result.Buckets, 2)
+		assert.Equal(t, 2, result.Buckets[0].Count)
+		assert.Equal(t, 2, result.Buckets[1].Count)
+	})
+
+	t.Run("limit truncates", func(t *testing.T) {
+		result, err := repo.FindRange(context.Background(), models.RangeQuery{From: from, To: to, Limit: 1})
+		require.NoError(t, err)
+		assert.Len(t, result.Lines, 1)
+		assert.True(t, result.Truncated)
+	})
+
+	t.Run("deadline returns partial result", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		result, err := repo.FindRange(ctx, models.RangeQuery{From: from, To: to})
+		require.NoError(t, err)
+		assert.True(t, result.Truncated)
+	})
+
+	t.Run("cancellation aborts", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := repo.FindRange(ctx, models.RangeQuery{From: from, To: to})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, _, err = repo.FindByTimestamp(ctx, from)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestLogRepositoryCacheExpiry(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "app.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+		"2023-01-01T00:00:01.000 line2",
+	})
+
+	opts := testOptions(time.Hour)
+	opts.FileCacheTTL = 10 * time.Millisecond
+	repo, err := openTestRepository(tmpDir, opts)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	from, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+	query := models.RangeQuery{From: from, To: from.Add(time.Second)}
+	for range 2 {
+		result, err := repo.FindRange(context.Background(), query)
+		require.NoError(t, err)
+		assert.Len(t, result.Lines, 2, "lines are found after the mapping expired")
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	line, _, err := repo.FindByTimestamp(context.Background(), from)
+	require.NoError(t, err)
+	assert.Contains(t, line, "line1")
+}
+
+func TestLogRepositoryReaderModes(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "test1.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+		"2023-01-01T00:00:01.000 line2",
+	})
+	testTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:01.000")
+
+	for _, mode := range []reader.Mode{reader.ModeAuto, reader.ModeMmap, reader.ModePread} {
+		t.Run(mode.String(), func(t *testing.T) {
+			opts := testOptions(10 * time.Millisecond)
+			opts.ReaderMode = mode
+
+			repo, err := openTestRepository(tmpDir, opts)
+			require.NoError(t, err)
+			defer repo.Close()
+
+			result, _, err := repo.FindByTimestamp(context.Background(), testTime)
+			require.NoError(t, err)
+			assert.Contains(t, result, "line2")
+		})
+	}
+}
+
+func TestLogRepositoryReaderModePerSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "nfs"), 0o755))
+	createTestLogFile(t, tmpDir, "local.log", []string{"2023-01-01T00:00:00.000 local"})
+	createTestLogFile(t, filepath.Join(tmpDir, "nfs"), "remote.log", []string{"2023-01-01T00:00:01.000 remote"})
+
+	opts := testOptions(10 * time.Millisecond)
+	opts.ReaderMode = reader.ModeMmap
+	opts.ReaderModes = map[string]reader.Mode{"nfs": reader.ModePread}
+	repo, err := openTestRepository(tmpDir, opts)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	for _, at := range []string{"2023-01-01T00:00:00.000", "2023-01-01T00:00:01.000"} {
+		testTime, _ := time.Parse(timeFormat, at)
+		_, _, err := repo.FindByTimestamp(context.Background(), testTime)
+		require.NoError(t, err)
+	}
+
+	readers := make(map[string]string)
+	for _, file := range repo.FileCacheStats().Files {
+		readers[filepath.Base(file.Path)] = file.Reader
+	}
+	assert.Equal(t, map[string]string{"local.log": "mmap", "remote.log": "pread"}, readers)
+}
+
+func TestLogRepositoryStatus(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "app.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+		"2023-01-01T00:00:01.000 line2",
+	})
+	createTestLogFile(t, tmpDir, "broken.log", []string{"no timestamp here"})
+
+	repo, err := openTestRepository(tmpDir, testOptions(time.Hour))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	status := repo.IndexStatus()
+	assert.True(t, status.Ready)
+	assert.Empty(t, status.LastRefreshError)
+	assert.False(t, status.LastRefresh.IsZero())
+	require.Len(t, status.Files, 1)
+	assert.Equal(t, int64(2), status.Files[0].Lines)
+	require.Len(t, status.Skipped, 1)
+	assert.Equal(t, filepath.Join(tmpDir, "broken.log"), status.Skipped[0].Path)
+	assert.NotEmpty(t, status.Skipped[0].Reason)
+
+	// An unfinished line that is completed later must be counted once.
+	path := filepath.Join(tmpDir, "app.log")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("2023-01-01T00:00:02.000 li")
+	require.NoError(t, err)
+	require.NoError(t, repo.RefreshMetadata(context.Background()))
+	assert.Equal(t, int64(3), repo.IndexStatus().Files[0].Lines)
+
+	_, err = f.WriteString("ne3\n2023-01-01T00:00:03.000 line4\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, repo.RefreshMetadata(context.Background()))
+	assert.Equal(t, int64(4), repo.IndexStatus().Files[0].Lines)
+}
+
+func TestLogRepositoryNotReady(t *testing.T) {
+	repo := &LogRepository{ready: make(chan struct{})}
+
+	_, _, err := repo.FindByTimestamp(context.Background(), time.Now())
+	assert.ErrorIs(t, err, models.ErrNotReady)
+
+	_, err = repo.FindRange(context.Background(), models.RangeQuery{})
+	assert.ErrorIs(t, err, models.ErrNotReady)
+
+	assert.False(t, repo.IndexStatus().Ready)
+}
+
+func TestLogRepositoryStatusDuringRefresh(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "test1.log", []string{"2023-01-01T00:00:00.000 line1"})
+
+	repo, err := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	// A rebuild in progress holds refreshMutex for as long as it reads files.
+	repo.refreshMutex.Lock()
+	refreshed := make(chan error)
+	go func() { refreshed <- repo.RefreshMetadata(context.Background()) }()
+
+	status := make(chan models.IndexStatus)
+	go func() { status <- repo.IndexStatus() }()
+	select {
+	case s := <-status:
+		assert.True(t, s.Ready)
+		assert.Len(t, s.Files, 1)
+	case <-time.After(time.Second):
+		t.Fatal("IndexStatus waited for the refresh")
+	}
+
+	testTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+	_, _, err = repo.FindByTimestamp(context.Background(), testTime)
+	assert.NoError(t, err)
+
+	repo.refreshMutex.Unlock()
+	assert.NoError(t, <-refreshed)
+}
+
+func TestLogRepositoryAdmin(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestLogFile(t, tmpDir, "app.log", []string{
+		"2023-01-01T00:00:00.000 line1",
+	})
+
+	repo, err := openTestRepository(tmpDir, testOptions(time.Hour))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	t.Run("reindex picks up a new file", func(t *testing.T) {
+		createTestLogFile(t, tmpDir, "new.log", []string{
+			"2023-01-01T00:00:05.000 line2",
+			"2023-01-01T00:00:06.000 line3",
+		})
+
+		file, err := repo.ReindexFile(context.Background(), "new.log")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(tmpDir, "new.log"), file.Path)
+		assert.Equal(t, int64(2), file.Lines)
+		assert.Equal(t, 2, repo.FileCount())
+	})
+
+	t.Run("reindex of a removed file drops it", func(t *testing.T) {
+		require.NoError(t, os.Remove(filepath.Join(tmpDir, "new.log")))
+
+		_, err := repo.ReindexFile(context.Background(), filepath.Join(tmpDir, "new.log"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.Equal(t, 1, repo.FileCount())
+	})
+
+	t.Run("reindex reports skipped files", func(t *testing.T) {
+		createTestLogFile(t, tmpDir, "notes.txt", []string{"no timestamp"})
+
+		_, err := repo.ReindexFile(context.Background(), "notes.txt")
+		assert.ErrorIs(t, err, models.ErrFileSkipped)
+	})
+
+	t.Run("paths outside the log directory are rejected", func(t *testing.T) {
+		for _, path := range []string{"../app.log", "..", "/etc/passwd", "a/b/app.log", ""} {
+			_, err := repo.ReindexFile(context.Background(), path)
+			assert.ErrorIs(t, err, models.ErrOutsideLogDir, path)
+		}
+	})
+
+	t.Run("names starting with dots are inside the log directory", func(t *testing.T) {
+		createTestLogFile(t, tmpDir, "..app.log", []string{"2023-01-01T00:00:07.000 line4"})
+
+		file, err := repo.ReindexFile(context.Background(), "..app.log")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(tmpDir, "..app.log"), file.Path)
+	})
+
+	t.Run("evict drops the cached reader", func(t *testing.T) {
+		testTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+		_, _, err := repo.FindByTimestamp(context.Background(), testTime)
+		require.NoError(t, err)
+		require.Equal(t, 1, repo.FileCacheStats().Entries)
+
+		evicted, err := repo.EvictFile(context.Background(), "app.log")
+		require.NoError(t, err)
+		assert.True(t, evicted)
+		assert.Equal(t, 0, repo.FileCacheStats().Entries)
+
+		evicted, err = repo.EvictFile(context.Background(), "app.log")
+		require.NoError(t, err)
+		assert.False(t, evicted)
+	})
+
+	t.Run("refresh interval changes at runtime", func(t *testing.T) {
+		require.NoError(t, repo.SetRefreshInterval(context.Background(), 20*time.Millisecond))
+		assert.Equal(t, "20ms", repo.IndexStatus().RefreshInterval)
+		assert.Error(t, repo.SetRefreshInterval(context.Background(), 0))
+
+		before := repo.IndexStatus().LastRefresh
+		assert.Eventually(t, func() bool {
+			return repo.IndexStatus().LastRefresh.After(before)
+		}, 2*time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("refresh interval changes while a refresh runs", func(t *testing.T) {
+		// With the 20ms interval above, the periodic loop soon blocks in
+		// RefreshMetadata behind this lock.
+		repo.refreshMutex.Lock()
+		time.Sleep(50 * time.Millisecond)
+
+		changed := make(chan error)
+		go func() {
+			for _, interval := range []time.Duration{time.Minute, time.Hour} {
+				if err := repo.SetRefreshInterval(context.Background(), interval); err != nil {
+					changed <- err
+					return
+				}
+			}
+			changed <- nil
+		}()
+		select {
+		case err := <-changed:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("SetRefreshInterval waited for the refresh")
+		}
+		repo.refreshMutex.Unlock()
+		assert.Equal(t, "1h0m0s", repo.IndexStatus().RefreshInterval)
+	})
+}
+
+func TestSearchPoolLimits(t *testing.T) {
+	peakOf := func(pool *searchPool, n int) int32 {
+		var running, peak atomic.Int32
+		errs := pool.run(context.Background(), n, func(i int) error {
+			current := running.Add(1)
+			for {
+				p := peak.Load()
+				if current <= p || peak.CompareAndSwap(p, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			return nil
+		})
+		assert.Len(t, errs, n)
+		return peak.Load()
+	}
+
+	assert.LessOrEqual(t, peakOf(newSearchPool(2, 8), 20), int32(2), "global worker slots must bound concurrency")
+	assert.LessOrEqual(t, peakOf(newSearchPool(8, 3), 20), int32(3), "one query must not use more than its parallelism")
+
+	// A query that finds every slot taken gives up once its context ends.
+	pool := newSearchPool(2, 2)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go pool.run(context.Background(), 2, func(i int) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	<-started
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errs := pool.run(ctx, 3, func(i int) error { return nil })
+	for _, err := range errs {
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+}
+
+func TestLogRepositorySources(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "payments"), 0755))
+	createTestLogFile(t, tmpDir, "app.log", []string{
+		"2023-01-01T00:00:00.000 app1",
+		"2023-01-01T00:00:02.000 app2",
+	})
+	createTestLogFile(t, tmpDir, "payments/pay.log", []string{
+		"2023-01-01T00:00:01.000 pay1",
+		"2023-01-01T00:00:03.000 pay2",
+	})
+
+	repo, err := openTestRepository(tmpDir, testOptions(10*time.Millisecond))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	sources := make(map[string]string)
+	for _, file := range repo.IndexStatus().Files {
+		sources[filepath.Base(file.Path)] = file.Source
+	}
+	assert.Equal(t, map[string]string{"app.log": models.DefaultSource, "pay.log": "payments"}, sources)
+
+	from, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+	to, _ := time.Parse(timeFormat, "2023-01-01T00:00:03.000")
+	payTime, _ := time.Parse(timeFormat, "2023-01-01T00:00:01.000")
+
+	t.Run("merged across sources", func(t *testing.T) {
+		result, err := repo.FindRange(context.Background(), models.RangeQuery{From: from, To: to})
+		require.NoError(t, err)
+		assert.Len(t, result.Lines, 4)
+		assert.Equal(t, []string{models.DefaultSource, "payments"}, result.Sources)
+
+		line, source, err := repo.FindByTimestamp(context.Background(), payTime)
+		require.NoError(t, err)
+		assert.Contains(t, line, "pay1")
+		assert.Equal(t, "payments", source)
+	})
+
+	t.Run("hidden sources are left out", func(t *testing.T) {
+		ctx := models.WithSourceAccess(context.Background(), models.SourceAccess{
+			models.DefaultSource: {{}},
+		})
+
+		result, err := repo.FindRange(ctx, models.RangeQuery{From: from, To: to})
+		require.NoError(t, err)
+		require.Len(t, result.Lines, 2)
+		assert.Contains(t, result.Lines[0], "app1")
+		assert.Contains(t, result.Lines[1], "app2")
+
+		count, err := repo.CountRange(ctx, models.RangeQuery{From: from, To: to})
+		require.NoError(t, err)
+		assert.Equal(t, 2, count.Total)
+
+		_, _, err = repo.FindByTimestamp(ctx, payTime)
 		assert.ErrorIs(t, err, models.ErrNotFound)
 	})
+
+	t.Run("time windows apply per source", func(t *testing.T) {
+		windowEnd, _ := time.Parse(timeFormat, "2023-01-01T00:00:02.000")
+		ctx := models.WithSourceAccess(context.Background(), models.SourceAccess{
+			models.DefaultSource: {{}},
+			"payments":           {{End: windowEnd}},
+		})
+
+		result, err := repo.FindRange(ctx, models.RangeQuery{From: from, To: to})
+		require.NoError(t, err)
+		require.Len(t, result.Lines, 3)
+		assert.Contains(t, result.Lines[1], "pay1")
+	})
+
+	t.Run("new source directories are watched", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("file watching is only supported on linux")
+		}
+
+		require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "disk"), 0755))
+		assert.Eventually(t, func() bool { return repo.FileCount() == 2 }, time.Second, 10*time.Millisecond)
+		createTestLogFile(t, tmpDir, "disk/disk.log", []string{"2023-01-01T00:00:04.000 disk1"})
+		assert.Eventually(t, func() bool { return repo.FileCount() == 3 }, 2*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestLogRepositoryMinuteCounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "payments"), 0755))
+	createTestLogFile(t, tmpDir, "app.log", []string{
+		"2023-01-01T00:00:00.000 ok",
+		"2023-01-01T00:00:30.000 ERROR failed",
+		"2023-01-01T00:01:00.000 ok",
+	})
+	createTestLogFile(t, tmpDir, "payments/pay.log", []string{
+		"2023-01-01T00:01:10.000 ERROR declined",
+	})
+
+	opts := testOptions(time.Hour)
+	opts.ErrorPattern = regexp.MustCompile("ERROR")
+	repo, err := openTestRepository(tmpDir, opts)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	first, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+	second := first.Add(time.Minute)
+	ctx := context.Background()
+
+	series, err := repo.MinuteCounts(ctx, first, second)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[time.Time]models.MinuteCount{
+		models.DefaultSource: {first: {Lines: 2, Errors: 1}, second: {Lines: 1}},
+		"payments":           {second: {Lines: 1, Errors: 1}},
+	}, series.Sources)
+	assert.Equal(t, second.Add(10*time.Second), series.End)
+
+	series, err = repo.MinuteCounts(models.WithSourceAccess(ctx, models.SourceAccess{"payments": {{}}}), first, first)
+	require.NoError(t, err)
+	assert.Empty(t, series.Sources, "minutes outside the range and hidden sources are left out")
+	assert.Equal(t, second.Add(10*time.Second), series.End)
+
+	series, err = repo.MinuteCounts(models.WithSourceAccess(ctx, models.SourceAccess{models.DefaultSource: {{}}}), first, first)
+	require.NoError(t, err)
+	assert.Equal(t, second, series.End, "the newest line of a hidden source is not revealed")
+
+	f, err := os.OpenFile(filepath.Join(tmpDir, "app.log"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("2023-01-01T00:01:20.000 ERROR again\n2023-01-01T00:02:00.000 unfinished")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, repo.RefreshMetadata(context.Background()))
+
+	series, err = repo.MinuteCounts(ctx, first, second.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, map[time.Time]models.MinuteCount{
+		first:  {Lines: 2, Errors: 1},
+		second: {Lines: 2, Errors: 1},
+	}, series.Sources[models.DefaultSource], "only finished lines are counted per minute")
+
+	var grown logFileMetadata
+	repo.indexMutex.RLock()
+	for _, meta := range repo.fileIndex {
+		if meta.source == models.DefaultSource {
+			grown = meta
+		}
+	}
+	repo.indexMutex.RUnlock()
+
+	f, err = os.OpenFile(filepath.Join(tmpDir, "app.log"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(" ERROR\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, repo.RefreshMetadata(context.Background()))
+
+	series, err = repo.MinuteCounts(ctx, first, second.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, models.MinuteCount{Lines: 1, Errors: 1}, series.Sources[models.DefaultSource][second.Add(time.Minute)])
+
+	previous := make(map[time.Time]models.MinuteCount)
+	grown.minutes.each(func(minute time.Time, count models.MinuteCount) { previous[minute] = count })
+	assert.Equal(t, map[time.Time]models.MinuteCount{
+		first:  {Lines: 2, Errors: 1},
+		second: {Lines: 2, Errors: 1},
+	}, previous, "older copies of the metadata keep their counts")
+}
+
+func TestLogRepositoryPatterns(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "payments"), 0755))
+	createTestLogFile(t, tmpDir, "app.log", []string{
+		"2023-01-01T00:00:00.000 user alice logged in",
+		"2023-01-01T00:00:30.000 user bob logged in",
+		"2023-01-01T00:02:00.000 disk full on sda1",
+		"2023-01-01T00:02:10.000 user carol logged in",
+	})
+	createTestLogFile(t, tmpDir, "payments/pay.log", []string{
+		"2023-01-01T00:02:20.000 payment 42 declined",
+		"2023-01-01T00:02:30.000 user dave logged in",
+	})
+
+	opts := testOptions(time.Hour)
+	miner := patterns.NewMiner(patterns.Options{MaxPatterns: 100, Similarity: 0.4})
+	opts.Patterns = miner
+	repo, err := openTestRepository(tmpDir, opts)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	first, _ := time.Parse(timeFormat, "2023-01-01T00:00:00.000")
+	third := first.Add(2 * time.Minute)
+	ctx := context.Background()
+
+	counts, err := repo.PatternCounts(ctx, third, third)
+	require.NoError(t, err)
+	require.Len(t, counts.Templates, 4)
+	ids := make(map[string]int)
+	for id, template := range counts.Templates {
+		ids[template] = id
+	}
+	login := ids["user <*> logged in"]
+	require.NotZero(t, login)
+	assert.Equal(t, map[time.Time]int{third: 1}, counts.Minutes[login])
+	assert.Equal(t, first, counts.FirstSeen[login], "first seen looks before the range")
+	assert.Equal(t, third, counts.FirstSeen[ids["disk full on sda1"]])
+	assert.Contains(t, ids, "payment <num> declined")
+	assert.Contains(t, ids, "user dave logged in", "sources are mined apart")
+
+	counts, err = repo.PatternCounts(models.WithSourceAccess(ctx, models.SourceAccess{"payments": {{}}}), first, third)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{
+		ids["payment <num> declined"]: "payment <num> declined",
+		ids["user dave logged in"]:    "user dave logged in",
+	}, counts.Templates)
+
+	query := models.RangeQuery{From: first, To: third.Add(time.Minute), PatternID: login}
+	result, err := repo.FindRange(ctx, query)
+	require.NoError(t, err)
+	require.Len(t, result.Lines, 3)
+	assert.Contains(t, result.Lines[2], "carol")
+
+	query.Pattern = regexp.MustCompile("bob|carol")
+	count, err := repo.CountRange(ctx, query)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count.Total)
+
+	_, err = repo.FindRange(ctx, models.RangeQuery{From: first, To: third, PatternID: 99})
+	assert.ErrorIs(t, err, models.ErrNoSuchPattern)
+
+	plain, err := openTestRepository(tmpDir, testOptions(time.Hour))
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = plain.PatternCounts(ctx, first, third)
+	assert.ErrorIs(t, err, models.ErrNotSupported)
+	_, err = plain.CountRange(ctx, models.RangeQuery{From: first, To: third, PatternID: login})
+	assert.ErrorIs(t, err, models.ErrNotSupported)
+
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, "app.log")))
+	require.NoError(t, repo.RefreshMetadata(ctx))
+	assert.Equal(t, 2, miner.Len(), "patterns of removed files are dropped")
+	_, err = repo.FindRange(ctx, models.RangeQuery{From: first, To: third, PatternID: login})
+	assert.ErrorIs(t, err, models.ErrNoSuchPattern)
+}
+
+// openTestRepository waits for the initial index, which NewLogRepository
+// builds in the background.
+func openTestRepository(dir string, opts Options) (*LogRepository, error) {
+	repo, err := NewLogRepository(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	<-repo.Ready()
+	return repo, nil
+}
+
+func testOptions(watchDebounce time.Duration) Options {
+	return Options{
+		MaxOpenFiles:    10,
+		FileCacheTTL:    time.Minute,
+		RefreshInterval: time.Hour,
+		WatchDebounce:   watchDebounce,
+	}
 }
 
 func createTestLogFile(t *testing.T, dir, name string, lines []string) {
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"maps"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+// minuteCounts holds the per-minute counts of a file in blocks of an hour.
+// Blocks are shared by every copy of the file's metadata and never changed
+// once published, so counting a grown tail copies only the blocks it
+// touches rather than every minute of the file.
+type minuteCounts map[time.Time]*hourCounts
+
+type hourCounts [60]models.MinuteCount
+
+// minuteWriter adds counts to a copy of minuteCounts.
+type minuteWriter struct {
+	counts minuteCounts
+	owned  map[time.Time]bool
+}
+
+func (m minuteCounts) writer() *minuteWriter {
+	counts := maps.Clone(m)
+	if counts == nil {
+		counts = make(minuteCounts)
+	}
+	return &minuteWriter{counts: counts, owned: make(map[time.Time]bool)}
+}
+
+// at returns the count of minute for writing, copying its block the first
+// time it is touched.
+func (w *minuteWriter) at(minute time.Time) *models.MinuteCount {
+	hour := minute.Truncate(time.Hour)
+	block := w.counts[hour]
+	if !w.owned[hour] {
+		if block == nil {
+			block = new(hourCounts)
+		} else {
+			copied := *block
+			block = &copied
+		}
+		w.counts[hour] = block
+		w.owned[hour] = true
+	}
+	return &block[minute.Sub(hour)/time.Minute]
+}
+
+// each calls fn with every minute that has lines.
+func (m minuteCounts) each(fn func(minute time.Time, count models.MinuteCount)) {
+	for hour, block := range m {
+		for i, count := range block {
+			if count.Lines > 0 {
+				fn(hour.Add(time.Duration(i)*time.Minute), count)
+			}
+		}
+	}
+}
+
+// patternCounts holds the lines per pattern and minute of a file, in blocks
+// of an hour shared the same way as minuteCounts.
+type patternCounts map[time.Time]map[patternMinute]int
+
+type patternMinute struct {
+	id     int
+	minute time.Time
+}
+
+// patternWriter adds counts to a copy of patternCounts.
+type patternWriter struct {
+	counts patternCounts
+	owned  map[time.Time]bool
+}
+
+func (p patternCounts) writer() *patternWriter {
+	counts := maps.Clone(p)
+	if counts == nil {
+		counts = make(patternCounts)
+	}
+	return &patternWriter{counts: counts, owned: make(map[time.Time]bool)}
+}
+
+func (w *patternWriter) add(id int, minute time.Time) {
+	hour := minute.Truncate(time.Hour)
+	block := w.counts[hour]
+	if !w.owned[hour] {
+		block = maps.Clone(block)
+		if block == nil {
+			block = make(map[patternMinute]int)
+		}
+		w.counts[hour] = block
+		w.owned[hour] = true
+	}
+	block[patternMinute{id: id, minute: minute}]++
+}
+
+// each calls fn with the count of every pattern and minute.
+func (p patternCounts) each(fn func(key patternMinute, count int)) {
+	for _, block := range p {
+		for key, count := range block {
+			fn(key, count)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// searchPool bounds how many files are scanned at once. Every query gets at
+// most parallelism goroutines, and all of them share one set of worker
+// slots, so a heavy query cannot take every core. Point lookups do not go
+// through the pool and are never queued behind scans.
+type searchPool struct {
+	slots       chan struct{}
+	parallelism int
+}
+
+func newSearchPool(workers, parallelism int) *searchPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &searchPool{
+		slots:       make(chan struct{}, workers),
+		parallelism: parallelism,
+	}
+}
+
+// run calls fn for i in [0, n) and returns the error of every call. Calls
+// that could not get a worker slot before ctx was done get ctx.Err().
+func (p *searchPool) run(ctx context.Context, n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+
+	workers := p.parallelism
+	if n < workers {
+		workers = n
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+
+				select {
+				case p.slots <- struct{}{}:
+				case <-ctx.Done():
+					errs[i] = ctx.Err()
+					continue
+				}
+
+				errs[i] = fn(i)
+				<-p.slots
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
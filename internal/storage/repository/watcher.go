@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Dor1ma/log-finder/internal/metrics"
+	"github.com/Dor1ma/log-finder/internal/models"
+)
+
+type watchEvent struct {
+	name     string
+	overflow bool
+}
+
+func (r *LogRepository) startWatcher() {
+	watcher, err := newDirWatcher(r.logDir)
+	if err != nil {
+		slog.Warn("File watcher unavailable, relying on periodic refresh", "error", err)
+		return
+	}
+	r.watcher = watcher
+
+	events := make(chan []watchEvent)
+
+	r.wg.Add(2)
+	go func() {
+		defer r.wg.Done()
+		defer close(events)
+
+		for {
+			batch, err := watcher.read()
+			if err != nil {
+				return
+			}
+			select {
+			case events <- batch:
+			case <-r.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer r.wg.Done()
+		r.watchLoop(events)
+	}()
+}
+
+// watchLoop collects events for one debounce window and then applies them
+// in a single index update, so bursts of writes cost one rescan per file.
+func (r *LogRepository) watchLoop(events <-chan []watchEvent) {
+	pending := make(map[string]struct{})
+	rescan := false
+
+	var timer *time.Timer
+	var flush <-chan time.Time
+
+	for {
+		select {
+		case batch, ok := <-events:
+			if !ok {
+				return
+			}
+			for _, ev := range batch {
+				if ev.overflow {
+					rescan = true
+					continue
+				}
+				pending[ev.name] = struct{}{}
+			}
+			if flush == nil {
+				timer = time.NewTimer(r.watchDebounce)
+				flush = timer.C
+			}
+
+		case <-flush:
+			flush = nil
+			if rescan {
+				slog.Warn("File watcher lost events, rescanning", "dir", r.logDir)
+				if err := r.RefreshMetadata(context.Background()); err != nil {
+					slog.Error("Metadata refresh failed", "error", err)
+				}
+			} else {
+				names := make([]string, 0, len(pending))
+				for name := range pending {
+					names = append(names, name)
+				}
+				r.refreshFiles(context.Background(), names)
+			}
+			pending = make(map[string]struct{})
+			rescan = false
+
+		case <-r.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+func (r *LogRepository) refreshFiles(ctx context.Context, names []string) {
+	defer metrics.IndexRefreshDuration.ObserveDuration(time.Now(), "incremental")
+
+	r.refreshMutex.Lock()
+	defer r.refreshMutex.Unlock()
+
+	changed := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		changed[filepath.Join(r.logDir, name)] = struct{}{}
+	}
+
+	r.indexMutex.RLock()
+	known := r.indexByID()
+	r.indexMutex.RUnlock()
+
+	var reindexed []logFileMetadata
+	skipped := make(map[string]models.SkippedFile)
+	for path := range changed {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		meta, err := indexFile(ctx, path, r.sourceOf(path), known, r.counter)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping file", "path", path, "error", err)
+			metrics.IndexSkippedFiles.Inc()
+			skipped[path] = models.SkippedFile{Path: path, Source: r.sourceOf(path), Reason: err.Error()}
+			continue
+		}
+		reindexed = append(reindexed, meta)
+	}
+
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+
+	ids := make(map[fileID]struct{}, len(reindexed))
+	for _, meta := range reindexed {
+		ids[meta.id] = struct{}{}
+	}
+	newIndex := make([]logFileMetadata, 0, len(r.fileIndex)+len(reindexed))
+	newIndex = append(newIndex, reindexed...)
+	for _, meta := range r.fileIndex {
+		if _, ok := changed[meta.path]; ok {
+			continue
+		}
+		if _, ok := ids[meta.id]; ok {
+			continue
+		}
+		newIndex = append(newIndex, meta)
+	}
+
+	for path := range changed {
+		delete(r.skipped, path)
+	}
+	maps.Copy(r.skipped, skipped)
+	r.setIndex(newIndex)
+	r.lastRefresh = time.Now()
+	slog.InfoContext(ctx, "Index updated", "changed", len(changed), "files", len(r.fileIndex))
+}
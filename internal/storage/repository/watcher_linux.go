@@ -0,0 +1,164 @@
+//go:build linux
+
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// dirWatcher watches the log directory and its first-level subdirectories.
+// Event names are relative to the log directory.
+type dirWatcher struct {
+	file *os.File
+	fd   int
+	dir  string
+	buf  []byte
+
+	mutex sync.Mutex
+	root  int32
+	subs  map[int32]string
+}
+
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := unix.InotifyAddWatch(fd, dir, watchMask|unix.IN_ONLYDIR)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return &dirWatcher{
+		file: os.NewFile(uintptr(fd), "inotify"),
+		fd:   fd,
+		dir:  dir,
+		buf:  make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1)),
+		root: int32(root),
+		subs: make(map[int32]string),
+	}, nil
+}
+
+// watchRoot watches the log directory again after its watch was lost,
+// for example because the directory was deleted and created anew.
+func (w *dirWatcher) watchRoot() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.root >= 0 {
+		return nil
+	}
+	wd, err := unix.InotifyAddWatch(w.fd, w.dir, watchMask|unix.IN_ONLYDIR)
+	if err != nil {
+		return err
+	}
+	w.root = int32(wd)
+	return nil
+}
+
+// watch adds the subdirectory name of the log directory. Watching a
+// directory twice is harmless.
+func (w *dirWatcher) watch(name string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, filepath.Join(w.dir, name), watchMask|unix.IN_ONLYDIR)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	w.subs[int32(wd)] = name
+	w.mutex.Unlock()
+	return nil
+}
+
+// read blocks until the kernel delivers at least one event. Any event that
+// invalidates the whole directory view (queue overflow, a watched directory
+// being removed or moved, a subdirectory appearing or going away) is
+// reported as an overflow. A watch the kernel dropped is added again on the
+// same path; if that fails, the rescan the overflow causes retries it.
+func (w *dirWatcher) read() ([]watchEvent, error) {
+	n, err := w.file.Read(w.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var events []watchEvent
+	for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&w.buf[offset]))
+		nameBytes := w.buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)]
+		offset += unix.SizeofInotifyEvent + int(raw.Len)
+
+		sub, isSub := w.subs[raw.Wd]
+		switch {
+		case raw.Mask&unix.IN_IGNORED != 0:
+			w.rewatch(raw.Wd)
+			events = append(events, watchEvent{overflow: true})
+		case raw.Mask&unix.IN_MOVE_SELF != 0:
+			// The watch follows the moved directory; dropping it leads to
+			// IN_IGNORED, which watches the path again.
+			unix.InotifyRmWatch(w.fd, uint32(raw.Wd))
+			events = append(events, watchEvent{overflow: true})
+		case raw.Mask&(unix.IN_Q_OVERFLOW|unix.IN_DELETE_SELF) != 0:
+			events = append(events, watchEvent{overflow: true})
+		case raw.Mask&unix.IN_ISDIR != 0:
+			if raw.Wd == w.root {
+				events = append(events, watchEvent{overflow: true})
+			}
+		case isSub:
+			events = append(events, watchEvent{name: filepath.Join(sub, trimNul(nameBytes))})
+		case raw.Wd == w.root:
+			events = append(events, watchEvent{name: trimNul(nameBytes)})
+		}
+	}
+
+	return events, nil
+}
+
+// rewatch replaces the dropped watch wd. The caller holds the mutex.
+func (w *dirWatcher) rewatch(wd int32) {
+	path := w.dir
+	if wd == w.root {
+		w.root = -1
+	} else if name, ok := w.subs[wd]; ok {
+		delete(w.subs, wd)
+		path = filepath.Join(w.dir, name)
+	} else {
+		return
+	}
+
+	next, err := unix.InotifyAddWatch(w.fd, path, watchMask|unix.IN_ONLYDIR)
+	if err != nil {
+		return
+	}
+	if path == w.dir {
+		w.root = int32(next)
+	} else {
+		w.subs[int32(next)] = path[len(w.dir)+1:]
+	}
+}
+
+func (w *dirWatcher) Close() error {
+	return w.file.Close()
+}
+
+func trimNul(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package repository
+
+import "errors"
+
+type dirWatcher struct{}
+
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	return nil, errors.New("file watching is only supported on linux")
+}
+
+func (w *dirWatcher) watchRoot() error {
+	return errors.New("file watching is only supported on linux")
+}
+
+func (w *dirWatcher) watch(name string) error {
+	return errors.New("file watching is only supported on linux")
+}
+
+func (w *dirWatcher) read() ([]watchEvent, error) {
+	return nil, errors.New("file watching is only supported on linux")
+}
+
+func (w *dirWatcher) Close() error {
+	return nil
+}
@@ -0,0 +1,168 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificate verification against the
+	// CAs in it. RequireClientCert rejects clients without a certificate;
+	// otherwise one is verified only when presented.
+	ClientCAFile      string
+	RequireClientCert bool
+	// ReloadInterval is how often the files are checked for changes; zero
+	// leaves reloading to Reload.
+	ReloadInterval time.Duration
+}
+
+// Reloader serves TLS with certificates that can be replaced while the
+// server runs. Handshakes after a reload use the new files; established
+// connections keep going.
+type Reloader struct {
+	opts Options
+
+	state atomic.Pointer[state]
+
+	mutex    sync.Mutex
+	modTimes map[string]time.Time
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type state struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func New(opts Options) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+
+	r := &Reloader{opts: opts, modTimes: make(map[string]time.Time), done: make(chan struct{})}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	if opts.ReloadInterval > 0 {
+		r.wg.Add(1)
+		go r.watch()
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and client CA files again. On error the
+// previous ones stay in use.
+func (r *Reloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Files that fail to load are not retried until they change again.
+	r.modTimes = r.currentModTimes()
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	next := &state{cert: &cert}
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("load client CA: %w", err)
+		}
+		next.clientCAs = x509.NewCertPool()
+		if !next.clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load client CA: no certificates in %s", r.opts.ClientCAFile)
+		}
+	}
+
+	r.state.Store(next)
+	slog.Info("TLS certificates loaded", "cert", r.opts.CertFile, "client_ca", r.opts.ClientCAFile)
+	return nil
+}
+
+func (r *Reloader) currentModTimes() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (r *Reloader) changed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	current := r.currentModTimes()
+	for path, modTime := range current {
+		if !modTime.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) watch() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				slog.Error("Failed to reload TLS certificates, keeping previous ones", "error", err)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Config returns the server configuration. It picks up reloaded files on
+// every handshake.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			current := r.state.Load()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*current.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if current.clientCAs != nil {
+				config.ClientCAs = current.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.opts.RequireClientCert {
+					config.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return config, nil
+		},
+	}
+}
+
+func (r *Reloader) Close() {
+	close(r.done)
+	r.wg.Wait()
+}
@@ -0,0 +1,170 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	certPEM, keyPEM := ca.issue(t, 10, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+
+	reloader, err := New(Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, RequireClientCert: true})
+	require.NoError(t, err)
+	defer reloader.Close()
+
+	var peer string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}))
+	server.TLS = reloader.Config()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCertPEM, clientKeyPEM := ca.issue(t, 20, "ci-runner", x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+		return client.Get(server.URL)
+	}
+
+	t.Run("client certificate is verified", func(t *testing.T) {
+		resp, err := get(clientCert)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "ci-runner", peer)
+		assert.Equal(t, int64(10), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+		_, err = get()
+		assert.Error(t, err)
+	})
+
+	t.Run("reload serves the new certificate", func(t *testing.T) {
+		certPEM, keyPEM := ca.issue(t, 11, "server", x509.ExtKeyUsageServerAuth)
+		writeFile(t, certFile, certPEM)
+		writeFile(t, keyFile, keyPEM)
+		require.NoError(t, reloader.Reload())
+
+		resp, err := get(clientCert)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, int64(11), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+	})
+
+	t.Run("broken files keep the previous certificate", func(t *testing.T) {
+		writeFile(t, keyFile, []byte("not a key"))
+		assert.Error(t, reloader.Reload())
+
+		resp, err := get(clientCert)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, int64(11), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+	})
+}
+
+func TestReloaderWatchesFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	certPEM, keyPEM := ca.issue(t, 10, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+
+	reloader, err := New(Options{CertFile: certFile, KeyFile: keyFile, ReloadInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer reloader.Close()
+
+	certPEM, keyPEM = ca.issue(t, 11, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	assert.Eventually(t, func() bool {
+		leaf, err := x509.ParseCertificate(reloader.state.Load().cert.Certificate[0])
+		return err == nil && leaf.SerialNumber.Int64() == 11
+	}, time.Second, 10*time.Millisecond)
+}
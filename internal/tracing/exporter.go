@@ -0,0 +1,239 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	serviceName   = "log-finder"
+	scopeName     = "github.com/Dor1ma/log-finder"
+	queueSize     = 4096
+	maxBatchSpans = 512
+)
+
+// Exporter writes finished spans as OTLP/JSON, one ExportTraceServiceRequest
+// per line, so the output can be replayed into any OTLP collector or read
+// directly. Spans are written in the background; when the queue is full
+// they are dropped rather than slowing down queries.
+type Exporter struct {
+	out     io.Writer
+	closer  io.Closer
+	queue   chan *Span
+	dropped atomic.Uint64
+	wg      sync.WaitGroup
+
+	closeMutex sync.RWMutex
+	closed     bool
+}
+
+// Open returns an exporter writing to stdout when target is "stdout" and
+// appending to the file named target otherwise.
+func Open(target string) (*Exporter, error) {
+	if target == "stdout" {
+		return NewExporter(os.Stdout, nil), nil
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewExporter(file, file), nil
+}
+
+// NewExporter writes spans to out; closer, if not nil, is closed by Close.
+func NewExporter(out io.Writer, closer io.Closer) *Exporter {
+	e := &Exporter{
+		out:    out,
+		closer: closer,
+		queue:  make(chan *Span, queueSize),
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+func (e *Exporter) export(span *Span) {
+	e.closeMutex.RLock()
+	defer e.closeMutex.RUnlock()
+	if e.closed {
+		return
+	}
+
+	select {
+	case e.queue <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *Exporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Close writes the spans still queued. Spans ended afterwards are discarded.
+func (e *Exporter) Close() error {
+	e.closeMutex.Lock()
+	e.closed = true
+	close(e.queue)
+	e.closeMutex.Unlock()
+
+	e.wg.Wait()
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+
+	w := bufio.NewWriter(e.out)
+	batch := make([]*Span, 0, maxBatchSpans)
+	for span := range e.queue {
+		batch = append(batch[:0], span)
+	drain:
+		for len(batch) < maxBatchSpans {
+			select {
+			case next, ok := <-e.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		if err := json.NewEncoder(w).Encode(newRequest(batch)); err != nil {
+			slog.Warn("Failed to encode spans", "error", err)
+		}
+		if err := w.Flush(); err != nil {
+			slog.Warn("Failed to write spans", "error", err)
+		}
+	}
+}
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []jsonSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type jsonSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            *status     `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type attribute struct {
+	Key   string `json:"key"`
+	Value value  `json:"value"`
+}
+
+// value is an OTLP AnyValue; 64-bit integers are encoded as strings.
+type value struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func attributeValue(v any) value {
+	switch v := v.(type) {
+	case string:
+		return value{StringValue: &v}
+	case bool:
+		return value{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return value{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return value{IntValue: &s}
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		return value{IntValue: &s}
+	case float64:
+		return value{DoubleValue: &v}
+	case interface{ String() string }:
+		s := v.String()
+		return value{StringValue: &s}
+	default:
+		s := jsonString(v)
+		return value{StringValue: &s}
+	}
+}
+
+func jsonString(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func newRequest(batch []*Span) exportRequest {
+	spans := make([]jsonSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mutex.Lock()
+		span := jsonSpan{
+			TraceID:           s.context.TraceID.String(),
+			SpanID:            s.context.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parent != (SpanID{}) {
+			span.ParentSpanID = s.parent.String()
+		}
+		if s.status != statusUnset {
+			span.Status = &status{Code: s.status, Message: s.statusMessage}
+		}
+		s.mutex.Unlock()
+		spans = append(spans, span)
+	}
+
+	name := serviceName
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: []attribute{{Key: "service.name", Value: value{StringValue: &name}}}},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: scopeName},
+			Spans: spans,
+		}},
+	}}}
+}
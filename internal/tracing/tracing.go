@@ -0,0 +1,220 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Span kinds as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+)
+
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+// Span records one timed operation. A nil *Span is valid and ignores all
+// calls, which is what Start returns while tracing is disabled, so callers
+// never have to check.
+type Span struct {
+	exporter *Exporter
+	name     string
+	kind     int
+	context  SpanContext
+	parent   SpanID
+	start    time.Time
+
+	mutex         sync.Mutex
+	end           time.Time
+	attrs         []attribute
+	status        int
+	statusMessage string
+	ended         bool
+}
+
+var exporter atomic.Pointer[Exporter]
+
+// SetExporter enables tracing; nil disables it.
+func SetExporter(e *Exporter) {
+	exporter.Store(e)
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// WithRemoteParent makes sc, usually parsed from an incoming traceparent
+// header, the parent of the next span started from ctx.
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal)
+}
+
+func StartKind(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	e := exporter.Load()
+	if e == nil {
+		return ctx, nil
+	}
+
+	span := &Span{exporter: e, name: name, kind: kind, start: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		span.context.TraceID = parent.context.TraceID
+		span.parent = parent.context.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		// The caller decided not to sample this trace.
+		if !remote.Sampled {
+			return ctx, nil
+		}
+		span.context.TraceID = remote.TraceID
+		span.parent = remote.SpanID
+	} else {
+		span.context.TraceID = newTraceID()
+	}
+	span.context.SpanID = newSpanID()
+	span.context.Sampled = true
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttr records a string, bool, integer or float attribute; other values
+// are stored as their string form.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ended {
+		return
+	}
+	s.attrs = append(s.attrs, attribute{Key: key, Value: attributeValue(value)})
+}
+
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ended {
+		return
+	}
+	s.status = statusError
+	s.statusMessage = err.Error()
+}
+
+// End finishes the span and hands it to the exporter. Calls after the first
+// are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	s.exporter.export(s)
+}
+
+// ParseTraceparent reads a W3C traceparent header value.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	// version-traceid-spanid-flags; later versions may append fields.
+	if len(header) < 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return sc, false
+	}
+	version := header[:2]
+	if version == "ff" || (version == "00" && len(header) != 55) || (len(header) > 55 && header[55] != '-') {
+		return sc, false
+	}
+	if _, err := hex.Decode(make([]byte, 1), []byte(version)); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(header[3:35])); err != nil || sc.TraceID == (TraceID{}) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(header[36:52])); err != nil || sc.SpanID == (SpanID{}) {
+		return sc, false
+	}
+	flags, err := strconv.ParseUint(header[53:55], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags&1 == 1
+	return sc, true
+}
+
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		putUint64(id[:8], rand.Uint64())
+		putUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		putUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+}
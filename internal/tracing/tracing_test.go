@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportSpans(t *testing.T, fn func()) []jsonSpan {
+	var buf bytes.Buffer
+	exporter := NewExporter(&buf, nil)
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	fn()
+	require.NoError(t, exporter.Close())
+
+	var spans []jsonSpan
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var request exportRequest
+		require.NoError(t, decoder.Decode(&request))
+		require.Len(t, request.ResourceSpans, 1)
+		for _, scope := range request.ResourceSpans[0].ScopeSpans {
+			spans = append(spans, scope.Spans...)
+		}
+	}
+	return spans
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	for _, header := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		_, ok := ParseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+
+	_, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.True(t, ok, "later versions may append fields")
+}
+
+func TestSpansExportAsOTLP(t *testing.T) {
+	spans := exportSpans(t, func() {
+		ctx, root := StartKind(context.Background(), "GET /logs", KindServer)
+		root.SetAttr("http.route", "/logs")
+
+		_, child := Start(ctx, "fileCache.Get")
+		child.SetAttr("hit", true)
+		child.SetAttr("size", int64(42))
+		child.RecordError(errors.New("boom"))
+		child.End()
+
+		root.End()
+		root.End()
+	})
+
+	require.Len(t, spans, 2)
+	child, root := spans[0], spans[1]
+
+	assert.Equal(t, "GET /logs", root.Name)
+	assert.Equal(t, KindServer, root.Kind)
+	assert.Empty(t, root.ParentSpanID)
+	assert.Nil(t, root.Status)
+
+	assert.Equal(t, root.TraceID, child.TraceID)
+	assert.Equal(t, root.SpanID, child.ParentSpanID)
+	assert.Equal(t, KindInternal, child.Kind)
+	require.NotNil(t, child.Status)
+	assert.Equal(t, statusError, child.Status.Code)
+	assert.Equal(t, "boom", child.Status.Message)
+	require.Len(t, child.Attributes, 2)
+	assert.True(t, *child.Attributes[0].Value.BoolValue)
+	assert.Equal(t, "42", *child.Attributes[1].Value.IntValue)
+	assert.LessOrEqual(t, child.StartTimeUnixNano, child.EndTimeUnixNano)
+}
+
+func TestRemoteParent(t *testing.T) {
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	spans := exportSpans(t, func() {
+		_, span := Start(WithRemoteParent(context.Background(), parent), "sampled")
+		span.End()
+
+		_, span = Start(WithRemoteParent(context.Background(), unsampled), "unsampled")
+		assert.Nil(t, span)
+		span.SetAttr("ignored", true)
+		span.End()
+	})
+
+	require.Len(t, spans, 1)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+}
+
+func TestDisabledTracing(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop")
+	assert.Nil(t, span)
+	assert.Nil(t, FromContext(ctx))
+	span.SetAttr("key", "value")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+}
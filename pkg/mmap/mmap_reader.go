@@ -2,6 +2,7 @@ package mmap
 
 import (
 	"os"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
@@ -18,12 +19,15 @@ func MapFile(path string) ([]byte, error) {
 		return nil, err
 	}
 
-	size := int(info.Size())
+	return Map(file, info.Size())
+}
+
+func Map(file *os.File, size int64) ([]byte, error) {
 	if size == 0 {
 		return nil, nil
 	}
 
-	data, err := unix.Mmap(int(file.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
 	if err != nil {
 		return nil, err
 	}
@@ -34,3 +38,47 @@ func MapFile(path string) ([]byte, error) {
 func Unmap(data []byte) error {
 	return unix.Munmap(data)
 }
+
+func AdviseRandom(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return unix.Madvise(data, unix.MADV_RANDOM)
+}
+
+func AdviseSequential(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return unix.Madvise(data, unix.MADV_SEQUENTIAL)
+}
+
+// Drop releases the resident pages of a mapping. The mapping stays valid
+// and pages are faulted back in from the file on the next access.
+func Drop(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return unix.Madvise(data, unix.MADV_DONTNEED)
+}
+
+// Resident returns how many bytes of the mapping are currently in memory.
+func Resident(data []byte) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	pageSize := os.Getpagesize()
+	vec := make([]byte, (len(data)+pageSize-1)/pageSize)
+	_, _, errno := unix.Syscall(unix.SYS_MINCORE,
+		uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&vec[0])))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	var pages int64
+	for _, v := range vec {
+		pages += int64(v & 1)
+	}
+	return pages * int64(pageSize), nil
+}
@@ -0,0 +1,51 @@
+package reader
+
+import (
+	"io"
+	"os"
+
+	"github.com/Dor1ma/log-finder/pkg/mmap"
+)
+
+type MmapReader struct {
+	data []byte
+}
+
+func mapFile(file *os.File, size int64) (*MmapReader, error) {
+	data, err := mmap.Map(file, size)
+	if err != nil {
+		return nil, err
+	}
+	return &MmapReader{data: data}, nil
+}
+
+func (r *MmapReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *MmapReader) Size() int64 {
+	return int64(len(r.data))
+}
+
+func (r *MmapReader) Kind() string {
+	return "mmap"
+}
+
+// Bytes exposes the mapping itself for madvise and residency accounting.
+func (r *MmapReader) Bytes() []byte {
+	return r.data
+}
+
+func (r *MmapReader) Close() error {
+	if r.data == nil {
+		return nil
+	}
+	return mmap.Unmap(r.data)
+}
@@ -0,0 +1,43 @@
+package reader
+
+import (
+	"io"
+	"os"
+)
+
+type PreadReader struct {
+	file *os.File
+	size int64
+}
+
+func newPreadReader(file *os.File, size int64) *PreadReader {
+	return &PreadReader{file: file, size: size}
+}
+
+// ReadAt never reads past the size the file had when it was opened, so a
+// search sees the same contents a mapping of that size would.
+func (r *PreadReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - off; int64(len(p)) > remaining {
+		n, err := r.file.ReadAt(p[:remaining], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return r.file.ReadAt(p, off)
+}
+
+func (r *PreadReader) Size() int64 {
+	return r.size
+}
+
+func (r *PreadReader) Kind() string {
+	return "pread"
+}
+
+func (r *PreadReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,71 @@
+package reader
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// FileReader is the random access view of a log file that all search code
+// runs against, whether the file is memory mapped or read with pread.
+type FileReader interface {
+	io.ReaderAt
+	Size() int64
+	Kind() string
+	Close() error
+}
+
+type Mode int
+
+const (
+	ModeAuto Mode = iota
+	ModeMmap
+	ModePread
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeMmap:
+		return "mmap"
+	case ModePread:
+		return "pread"
+	default:
+		return "auto"
+	}
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "auto":
+		return ModeAuto, nil
+	case "mmap":
+		return ModeMmap, nil
+	case "pread":
+		return ModePread, nil
+	default:
+		return ModeAuto, fmt.Errorf("unknown reader mode %q, expected auto, mmap or pread", s)
+	}
+}
+
+// Open takes ownership of file. In auto mode the file is mapped when
+// possible and read with pread when it does not fit in the address space
+// or the filesystem refuses the mapping.
+func Open(file *os.File, size int64, mode Mode) (FileReader, error) {
+	if mode == ModePread || mode == ModeAuto && size > math.MaxInt {
+		return newPreadReader(file, size), nil
+	}
+
+	r, err := mapFile(file, size)
+	if err != nil {
+		if mode == ModeAuto {
+			return newPreadReader(file, size), nil
+		}
+		file.Close()
+		return nil, err
+	}
+
+	file.Close()
+	return r, nil
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"io"
 	"os"
 	"time"
 
@@ -11,6 +13,8 @@ import (
 
 var timeFormat = "2006-01-02T15:04:05.000"
 
+const tailChunkSize = 4096
+
 func GetFileTimeBounds(path string) (time.Time, time.Time, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -47,6 +51,64 @@ func GetFileTimeBounds(path string) (time.Time, time.Time, error) {
 	return start, end, nil
 }
 
+// GetFileStartTime returns the timestamp of the first line of the file.
+func GetFileStartTime(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return time.Time{}, err
+	}
+	return ParseTimestamp(line)
+}
+
+// GetFileEndTime reads the file backwards from its end and returns the
+// timestamp of the last complete line, so a growing file can have its
+// bounds extended without rescanning it.
+func GetFileEndTime(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	size := info.Size()
+
+	for chunk := int64(tailChunkSize); ; chunk *= 2 {
+		if chunk > size {
+			chunk = size
+		}
+
+		tail := make([]byte, chunk)
+		if _, err := file.ReadAt(tail, size-chunk); err != nil && err != io.EOF {
+			return time.Time{}, err
+		}
+
+		lines := bytes.Split(bytes.TrimRight(tail, "\n"), []byte{'\n'})
+		if chunk < size {
+			lines = lines[1:]
+		}
+
+		for i := len(lines) - 1; i >= 0; i-- {
+			if ts, err := ParseTimestamp(string(lines[i])); err == nil {
+				return ts, nil
+			}
+		}
+
+		if chunk == size {
+			return time.Time{}, models.ErrInvalidFormat
+		}
+	}
+}
+
 func ParseTimestamp(line string) (time.Time, error) {
 	if len(line) < 23 {
 		return time.Time{}, models.ErrInvalidFormat
@@ -54,30 +116,129 @@ func ParseTimestamp(line string) (time.Time, error) {
 	return time.Parse(timeFormat, line[:23])
 }
 
+// Message returns line without its leading timestamp.
+func Message(line string) string {
+	if len(line) > len(timeFormat) && line[len(timeFormat)] == ' ' {
+		if _, err := ParseTimestamp(line); err == nil {
+			return line[len(timeFormat)+1:]
+		}
+	}
+	return line
+}
+
 func BinarySearchInData(data []byte, target time.Time) (string, error) {
-	lines := bytes.Split(data, []byte{'\n'})
-	low := 0
-	high := len(lines) - 1
+	return BinarySearchInReader(context.Background(), bytes.NewReader(data), int64(len(data)), target)
+}
 
-	for low <= high {
-		mid := (low + high) / 2
-		line := string(lines[mid])
+// BinarySearchInReader returns the first line logged at target. Only the
+// lines it probes are read, so it runs the same way over a mapping and over
+// pread.
+func BinarySearchInReader(ctx context.Context, r io.ReaderAt, size int64, target time.Time) (string, error) {
+	offset, err := SeekTimestamp(ctx, r, size, target)
+	if err != nil {
+		return "", err
+	}
+	if offset >= size {
+		return "", models.ErrNotFound
+	}
 
-		lineTime, err := ParseTimestamp(line)
+	_, _, line, err := lineAtOrAfter(r, size, offset)
+	if err != nil {
+		return "", err
+	}
+
+	lineTime, err := ParseTimestamp(line)
+	if err != nil {
+		return "", err
+	}
+	if !lineTime.Equal(target) {
+		return "", models.ErrNotFound
+	}
+
+	return line, nil
+}
+
+// SeekTimestamp bisects the byte range of a sorted log and returns the offset
+// of the first line logged at or after target, or size if there is none.
+func SeekTimestamp(ctx context.Context, r io.ReaderAt, size int64, target time.Time) (int64, error) {
+	low, high := int64(0), size
+
+	for low < high {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		mid := low + (high-low)/2
+
+		start, next, line, err := lineAtOrAfter(r, size, mid)
 		if err != nil {
-			return "", err
+			return 0, err
+		}
+		if start >= high {
+			high = mid
+			continue
 		}
 
-		if lineTime.Equal(target) {
-			return line, nil
+		lineTime, err := ParseTimestamp(line)
+		if err != nil {
+			return 0, err
 		}
 
-		if target.Before(lineTime) {
-			high = mid - 1
+		if lineTime.Before(target) {
+			low = next
 		} else {
-			low = mid + 1
+			high = start
+		}
+	}
+
+	return low, nil
+}
+
+const probeSize = 512
+
+// lineAtOrAfter returns the first line that starts at or after offset,
+// together with its start and the start of the line following it.
+func lineAtOrAfter(r io.ReaderAt, size, offset int64) (int64, int64, string, error) {
+	start := offset
+	if offset > 0 {
+		newline, err := indexByteFrom(r, size, offset-1)
+		if err != nil {
+			return 0, 0, "", err
 		}
+		start = newline + 1
+	}
+	if start >= size {
+		return size, size, "", nil
+	}
+
+	end, err := indexByteFrom(r, size, start)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	line := make([]byte, end-start)
+	if _, err := r.ReadAt(line, start); err != nil && err != io.EOF {
+		return 0, 0, "", err
 	}
 
-	return "", models.ErrNotFound
+	return start, end + 1, string(line), nil
+}
+
+// indexByteFrom returns the offset of the first newline at or after offset,
+// or size when the rest of the data has none.
+func indexByteFrom(r io.ReaderAt, size, offset int64) (int64, error) {
+	buf := make([]byte, probeSize)
+	for pos := offset; pos < size; pos += probeSize {
+		n, err := r.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i), nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return size, nil
 }
@@ -2,7 +2,10 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -46,6 +49,38 @@ func TestBinarySearch(t *testing.T) {
 	})
 }
 
+func TestBinarySearchInReader(t *testing.T) {
+	long := strings.Repeat("x", 2000)
+	data := []byte(strings.Join([]string{
+		"2023-01-01T00:00:00.000 first " + long,
+		"2023-01-01T00:00:01.000 dup1",
+		"2023-01-01T00:00:01.000 dup2",
+		"2023-01-01T00:00:03.000 after gap " + long,
+		"2023-01-01T00:00:04.000 last",
+	}, "\n") + "\n")
+
+	search := func(ts string) (string, error) {
+		target, err := time.Parse(timeFormat, ts)
+		require.NoError(t, err)
+		return BinarySearchInReader(context.Background(), bytes.NewReader(data), int64(len(data)), target)
+	}
+
+	result, err := search("2023-01-01T00:00:01.000")
+	require.NoError(t, err)
+	assert.Contains(t, result, "dup1", "first of equal timestamps should win")
+
+	for _, ts := range []string{"2023-01-01T00:00:00.000", "2023-01-01T00:00:03.000", "2023-01-01T00:00:04.000"} {
+		result, err = search(ts)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(result, ts))
+	}
+
+	for _, ts := range []string{"2022-12-31T23:59:59.000", "2023-01-01T00:00:02.000", "2023-01-01T00:00:05.000"} {
+		_, err = search(ts)
+		assert.ErrorIs(t, err, models.ErrNotFound, ts)
+	}
+}
+
 func TestTimeBounds(t *testing.T) {
 	tmpFile := createTestFile(t, []string{
 		"2023-01-01T00:00:00.000 first",
@@ -59,6 +94,63 @@ func TestTimeBounds(t *testing.T) {
 
 	assert.Equal(t, "2023-01-01T00:00:00.000", start.Format(timeFormat))
 	assert.Equal(t, "2023-01-01T00:00:02.000", end.Format(timeFormat))
+
+	start, err = GetFileStartTime(tmpFile)
+	require.NoError(t, err)
+	assert.Equal(t, "2023-01-01T00:00:00.000", start.Format(timeFormat))
+}
+
+func TestFileEndTime(t *testing.T) {
+	tmpFile := createTestFile(t, []string{
+		"2023-01-01T00:00:00.000 first",
+		"2023-01-01T00:00:02.000 last",
+		"2023-01-01T00:0",
+	})
+	defer os.Remove(tmpFile)
+
+	end, err := GetFileEndTime(tmpFile)
+	require.NoError(t, err)
+	assert.Equal(t, "2023-01-01T00:00:02.000", end.Format(timeFormat))
+
+	emptyFile := createTestFile(t, nil)
+	defer os.Remove(emptyFile)
+
+	_, err = GetFileEndTime(emptyFile)
+	assert.ErrorIs(t, err, models.ErrInvalidFormat)
+}
+
+func TestScanLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "minutes.log")
+	data := strings.Join([]string{
+		"2023-01-01T00:00:10.000 ok",
+		"2023-01-01T00:00:50.000 failed",
+		"2023-01-01T00:01:00.000 ok",
+		"no timestamp",
+		"2023-01-01T00:01:30.000 unfinished",
+	}, "\n")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	minutes := make(map[time.Time][]string)
+	onLine := func(minute time.Time, line []byte) {
+		minutes[minute] = append(minutes[minute], Message(string(line)))
+	}
+
+	stats, err := ScanLines(path, 0, int64(len(data)), onLine)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), stats.Lines)
+	assert.False(t, stats.Complete)
+	assert.Equal(t, int64(strings.LastIndex(data, "\n")+1), stats.CountedTo)
+
+	first := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, map[time.Time][]string{
+		first:                  {"ok", "failed"},
+		first.Add(time.Minute): {"ok"},
+	}, minutes, "the unfinished line is not passed on")
+
+	stats, err = ScanLines(path, stats.CountedTo, int64(len(data)), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Lines)
 }
 
 func createTestFile(t *testing.T, lines []string) string {
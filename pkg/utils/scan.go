@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const scanCheckEvery = 256
+
+// ScanRange calls fn for every line logged in [from, to], in file order,
+// until fn returns false. Lines without a timestamp are skipped. The
+// context is checked every few hundred lines, so a long scan stops soon
+// after the query is cancelled or runs out of time.
+func ScanRange(ctx context.Context, r io.ReaderAt, size int64, from, to time.Time, fn func(line string) bool) error {
+	offset, err := SeekTimestamp(ctx, r, size, from)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(r, offset, size-offset))
+	for n := 0; ; n++ {
+		if n%scanCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSuffix(line, "\n")
+		if lineTime, parseErr := ParseTimestamp(line); parseErr == nil {
+			if lineTime.After(to) || !fn(line) {
+				return nil
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// LineStats describes bytes [offset, size) of a log file. Lines counts its
+// lines, including a final line without a newline; Complete reports whether
+// the range ends with a newline, i.e. whether its last line is finished.
+// CountedTo is the offset after the last finished line, where scanning
+// continues once the file grows.
+type LineStats struct {
+	Lines     int64
+	Complete  bool
+	CountedTo int64
+}
+
+const minuteFormat = "2006-01-02T15:04"
+
+// ScanLines reads bytes [offset, size) of path once, counting its lines,
+// and calls onLine, when set, with every finished line that starts with a
+// timestamp and the minute it was logged in. Only the first 64KB of very
+// long lines are passed on.
+func ScanLines(path string, offset, size int64, onLine func(minute time.Time, line []byte)) (LineStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return LineStats{}, err
+	}
+	defer file.Close()
+
+	stats := LineStats{Complete: true, CountedTo: offset}
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, offset, size-offset), 64*1024)
+	pos := offset
+	var long []byte
+	var lastPrefix string
+	var lastMinute time.Time
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		pos += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			if long == nil {
+				long = bytes.Clone(chunk)
+			}
+			continue
+		}
+
+		line := chunk
+		if long != nil {
+			line = long
+		}
+		switch {
+		case len(chunk) > 0 && chunk[len(chunk)-1] == '\n':
+			stats.Lines++
+			stats.CountedTo = pos
+			if onLine != nil && len(line) >= len(minuteFormat) {
+				// Lines of one minute share the prefix, so it is parsed once.
+				if prefix := line[:len(minuteFormat)]; string(prefix) != lastPrefix {
+					lastPrefix = string(prefix)
+					lastMinute, _ = time.Parse(minuteFormat, lastPrefix)
+				}
+				if !lastMinute.IsZero() {
+					onLine(lastMinute, bytes.TrimSuffix(line, []byte{'\n'}))
+				}
+			}
+		case len(chunk) > 0 || long != nil:
+			stats.Lines++
+			stats.Complete = false
+		}
+		long = nil
+
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return LineStats{}, err
+		}
+	}
+}